@@ -7,20 +7,27 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/marktheunissen/watchbot/pkg/app"
 	"github.com/marktheunissen/watchbot/pkg/appmetrics"
+	"github.com/marktheunissen/watchbot/pkg/archive"
 	"github.com/marktheunissen/watchbot/pkg/camera"
 	"github.com/marktheunissen/watchbot/pkg/datastore"
 	"github.com/marktheunissen/watchbot/pkg/detect"
+	"github.com/marktheunissen/watchbot/pkg/frame"
 	"github.com/marktheunissen/watchbot/pkg/jobs"
 	"github.com/marktheunissen/watchbot/pkg/messaging"
+	"github.com/marktheunissen/watchbot/pkg/sun"
 	"github.com/marktheunissen/watchbot/pkg/systemerr"
 	"github.com/marktheunissen/watchbot/pkg/telegram"
+	"github.com/marktheunissen/watchbot/pkg/zone"
 	_ "github.com/mattn/go-sqlite3"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -40,11 +47,13 @@ func initConfig() error {
 	viper.SetDefault("debug", false)
 	viper.SetDefault("log-journal", true)
 	viper.SetDefault("metrics-debug-port", 6060)
+	viper.SetDefault("detect-backend", "ncs")
 	viper.SetDefault("detect-graph-labels", []string{"background", "aeroplane", "bicycle", "bird", "boat", "bottle", "bus", "car", "cat", "chair", "cow", "dining table", "dog", "horse", "motorbike", "person", "potted plant", "sheep", "sofa", "train", "tvmonitor"})
 	viper.SetDefault("detect-graph-width", 300)
 	viper.SetDefault("detect-graph-height", 300)
 	viper.SetDefault("detect-graph-file", "/opt/graph-mobilenet-sdk-1.0/graph")
 	viper.SetDefault("frame-interval-ms", 200)
+	viper.SetDefault("event-max-age", "720h")
 	viper.SetDefault("sqlite-db-dir", "/var/watchbot/")
 	viper.SetDefault("active", true)
 
@@ -74,7 +83,7 @@ func initLog() {
 	}
 }
 
-func initCam(viperConf *viper.Viper, index int) (*camera.Cam, error) {
+func initCam(viperConf *viper.Viper, id string, archiveQuota *archive.Quota) (*camera.Cam, error) {
 	// Telegram bot first, so that we get a clue if stuck in crash loop due to
 	// subsequent component failure.
 	tgConfig := telegram.Config{
@@ -88,13 +97,34 @@ func initCam(viperConf *viper.Viper, index int) (*camera.Cam, error) {
 	bot.SendMsg("🤖")
 
 	// Datastore: SQLite DB
-	dbFile := filepath.Clean(fmt.Sprintf("%s/camera%d.db", viper.GetString("sqlite-db-dir"), index))
+	dbFile := filepath.Clean(fmt.Sprintf("%s/%s.db", viper.GetString("sqlite-db-dir"), id))
+	coords, err := getCoords(viperConf)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := getLocation(viperConf)
+	if err != nil {
+		return nil, err
+	}
 	dsConfig := datastore.Config{
 		Filename: dbFile,
+		Coords:   coords,
+		Location: loc,
 	}
 	store, err := datastore.New(dsConfig)
 	exitIfErr(err, "datastore.New")
 
+	// Cameras without their own alert labels use the global ones.
+	if !viperConf.IsSet("alert-labels") {
+		viperConf.Set("alert-labels", viper.GetStringSlice("detect-alert-labels"))
+	}
+
+	// Each camera archives to its own directory, the max size is shared by all of them.
+	archiveDir := ""
+	if viper.GetString("archive-dir") != "" {
+		archiveDir = filepath.Join(viper.GetString("archive-dir"), id)
+	}
+
 	camURI := strings.Replace(viperConf.GetString("pipeline"), "{url}", viperConf.GetString("url"), 1)
 	if camURI == "" {
 		return nil, errors.New("VideoCapture URL & pipeline are required")
@@ -104,7 +134,7 @@ func initCam(viperConf *viper.Viper, index int) (*camera.Cam, error) {
 		Name:            viperConf.GetString("name"),
 		Bot:             bot,
 		Store:           store,
-		Index:           index,
+		ID:              id,
 		VideoCaptureURI: camURI,
 		CropX:           viperConf.GetInt("crop-x"),
 		CropY:           viperConf.GetInt("crop-y"),
@@ -115,21 +145,58 @@ func initCam(viperConf *viper.Viper, index int) (*camera.Cam, error) {
 		MinWidth:        viperConf.GetInt("min-width"),
 		MinHeight:       viperConf.GetInt("min-height"),
 		MinConfidence:   viperConf.GetInt("min-confidence"),
+		AlertLabels:     viperConf.GetStringSlice("alert-labels"),
+		LabelLimits:     getLabelLimits(viperConf),
 		RequirePortrait: viperConf.GetBool("require-portrait"),
 		ROIX:            viperConf.GetInt("roi-x"),
 		ROIY:            viperConf.GetInt("roi-y"),
 		ROIWidth:        viperConf.GetInt("roi-w"),
 		ROIHeight:       viperConf.GetInt("roi-h"),
+		Zones:           getZones(viperConf),
+		Tripwires:       getTripwires(viperConf),
+		DwellRules:      getDwellRules(viperConf),
 		SendRejected:    viperConf.GetBool("send-rejected"),
 		PubSubControl:   viperConf.GetBool("pubsub-control"),
+
+		NMSThreshold:       viperConf.GetFloat64("nms-threshold"),
+		NMSLabelThresholds: getFloatMap(viperConf, "nms-label-thresholds"),
+		MergeOverlaps:      viperConf.GetBool("merge-overlaps"),
+		TrackMaxAge:        viperConf.GetDuration("track-max-age"),
+		TrackMinIoU:        viperConf.GetFloat64("track-min-iou"),
+
+		MotionMinArea:       viperConf.GetFloat64("motion-min-area"),
+		MotionThreshold:     viperConf.GetInt("motion-threshold"),
+		MotionAlert:         viperConf.GetBool("motion-alert"),
+		MotionAlertInterval: viperConf.GetDuration("motion-alert-interval"),
+
+		StationaryAfter:  viperConf.GetDuration("stationary-after"),
+		StationaryMinIoU: viperConf.GetFloat64("stationary-min-iou"),
+		StationaryForget: viperConf.GetDuration("stationary-forget"),
+
+		ClipPreRoll:   viperConf.GetDuration("clip-pre-roll"),
+		ClipPostRoll:  viperConf.GetDuration("clip-post-roll"),
+		ClipMaxLength: viperConf.GetDuration("clip-max-length"),
+		ClipMaxBytes:  viperConf.GetInt64("clip-max-size-mb") * 1024 * 1024,
+		ClipMaxWidth:  viperConf.GetInt("clip-max-width"),
+		ClipCodec:     viperConf.GetString("clip-codec"),
+
+		AlertMode:      viperConf.GetString("alert-mode"),
+		BurstQuiet:     viperConf.GetDuration("burst-quiet"),
+		BurstMaxFrames: viperConf.GetInt("burst-max-frames"),
+		BurstMaxLength: viperConf.GetDuration("burst-max-length"),
+		BurstMaxWidth:  viperConf.GetInt("burst-max-width"),
+
+		ArchiveDir:    archiveDir,
+		ArchiveMaxAge: viper.GetDuration("archive-max-age"),
+		ArchiveQuota:  archiveQuota,
 	}
 
 	c, err := camera.New(config)
 	if err != nil {
 		return nil, err
 	}
-	log.Infof("camera%d (%s) Datastore Config: %+v", index, config.Name, dsConfig)
-	log.Infof("camera%d (%s) Config: %+v", index, config.Name, config)
+	log.Infof("camera %s (%s) Datastore Config: %+v", id, c.Name, dsConfig)
+	log.Infof("camera %s (%s) Config: %+v", id, c.Name, config)
 	return c, nil
 }
 
@@ -172,18 +239,13 @@ func main() {
 
 	// Cameras configuration
 	var cams []*camera.Cam
-	for i := 0; i < 4; i++ {
-		camIndex := fmt.Sprintf("camera%d", i)
-		conf := viper.Sub(camIndex)
-		if conf != nil {
-			c, err := initCam(conf, i)
-			exitIfErr(err, camIndex+".initCam")
-			cams = append(cams, c)
-			c.SnapshotChan = SnapshotChan
-			c.FrameChan = FrameChan
-		} else {
-			log.Infof("%s: no config found", camIndex)
-		}
+	archiveQuota := archive.NewQuota(viper.GetInt64("archive-max-size-mb") * 1024 * 1024)
+	for _, cc := range getCamConfigs() {
+		c, err := initCam(cc.conf, cc.id, archiveQuota)
+		exitIfErr(err, cc.id+".initCam")
+		cams = append(cams, c)
+		c.SnapshotChan = SnapshotChan
+		c.FrameChan = FrameChan
 	}
 	if len(cams) > 0 {
 		log.Infof("Cameras initialized: %d", len(cams))
@@ -192,14 +254,31 @@ func main() {
 	}
 
 	// Detector
+	anchors, err := detect.ParseAnchors(viper.GetStringSlice("detect-yolo-anchors"))
+	exitIfErr(err, "detect.ParseAnchors")
 	detectorConfig := detect.Config{
-		Cameras:        cams,
-		StickDeviceNum: viper.GetInt("detect-stick-device-num"),
-		GraphFileName:  viper.GetString("detect-graph-file"),
-		GraphLabels:    viper.GetStringSlice("detect-graph-labels"),
-		AlertLabels:    viper.GetStringSlice("detect-alert-labels"),
-		GraphWidth:     viper.GetInt("detect-graph-width"),
-		GraphHeight:    viper.GetInt("detect-graph-height"),
+		Cameras:           cams,
+		Backend:           viper.GetString("detect-backend"),
+		StickDeviceNum:    viper.GetInt("detect-stick-device-num"),
+		GraphFileName:     viper.GetString("detect-graph-file"),
+		GraphConfigFile:   viper.GetString("detect-graph-config-file"),
+		GraphOutputNames:  viper.GetStringSlice("detect-graph-outputs"),
+		GraphLabels:       viper.GetStringSlice("detect-graph-labels"),
+		GraphWidth:        viper.GetInt("detect-graph-width"),
+		GraphHeight:       viper.GetInt("detect-graph-height"),
+		InputScale:        getOptionalFloat(viper.GetViper(), "detect-input-scale"),
+		InputMean:         getOptionalFloat(viper.GetViper(), "detect-input-mean"),
+		InputSwapRB:       viper.GetBool("detect-input-swap-rb"),
+		Decoder:           viper.GetString("detect-decoder"),
+		Anchors:           anchors,
+		MinScore:          viper.GetFloat64("detect-min-score"),
+		NMSThreshold:      viper.GetFloat64("detect-nms-threshold"),
+		ReplayFileName:    viper.GetString("detect-replay-file"),
+		RecordFileName:    viper.GetString("detect-record-file"),
+		StallTimeout:      viper.GetDuration("capture-stall-timeout"),
+		ReconnectMin:      viper.GetDuration("capture-reconnect-min"),
+		ReconnectMax:      viper.GetDuration("capture-reconnect-max"),
+		ReconnectAttempts: viper.GetInt("capture-reconnect-attempts"),
 	}
 	detector, err := detect.New(detectorConfig)
 	exitIfErr(err, "detector.New")
@@ -238,6 +317,7 @@ func main() {
 		HeartbeatURL:    viper.GetString("heartbeat-url"),
 		SnapshotChan:    SnapshotChan,
 		FrameChan:       FrameChan,
+		EventMaxAge:     viper.GetDuration("event-max-age"),
 	}
 	a, err := app.New(appConfig)
 	exitIfErr(err, "app.New")
@@ -266,12 +346,233 @@ func main() {
 	exitIfErr(err, "app.Run")
 	detector.Close()
 	for _, cam := range a.Cams {
-		log.Infof("Cleanup camera%d", cam.Index)
+		log.Infof("Cleanup camera %s", cam.ID)
 		cam.Store.Close()
 	}
 	log.Info("Shutdown complete")
 }
 
+var camIDRegexp = regexp.MustCompile(`^[a-z0-9_-]+$`)
+var legacyCamRegexp = regexp.MustCompile(`^camera[0-9]+$`)
+
+type camConfig struct {
+	id   string
+	conf *viper.Viper
+}
+
+// getCamConfigs reads the cameras keyed by their ID, which also names the camera's
+// SQLite file and metrics, so it should not change once chosen, e.g.
+//
+//	cameras:
+//	  driveway:
+//	    url: rtsp://192.168.1.10/stream1
+//	  garden:
+//	    url: rtsp://192.168.1.11/stream1
+//
+// The older top level camera0, camera1, ... keys are still read, with the key as the ID.
+func getCamConfigs() []camConfig {
+	ret := []camConfig{}
+	seen := map[string]bool{}
+	add := func(id string, conf *viper.Viper) {
+		if !camIDRegexp.MatchString(id) {
+			exitIfErr(fmt.Errorf("camera ID must only contain a-z, 0-9, - and _: %s", id), "cameras")
+		}
+		if seen[id] {
+			exitIfErr(fmt.Errorf("duplicate camera ID: %s", id), "cameras")
+		}
+		if conf == nil {
+			exitIfErr(fmt.Errorf("camera %s has no config", id), "cameras")
+		}
+		seen[id] = true
+		ret = append(ret, camConfig{id: id, conf: conf})
+	}
+
+	ids := []string{}
+	for id := range viper.GetStringMap("cameras") {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		add(id, viper.Sub("cameras."+id))
+	}
+
+	legacy := []string{}
+	for key := range viper.AllSettings() {
+		if legacyCamRegexp.MatchString(key) {
+			legacy = append(legacy, key)
+		}
+	}
+	sort.Strings(legacy)
+	for _, id := range legacy {
+		add(id, viper.Sub(id))
+	}
+	return ret
+}
+
+// getFloatMap reads a map of label to number from the config, e.g. per label thresholds.
+func getFloatMap(viperConf *viper.Viper, key string) map[string]float64 {
+	ret := map[string]float64{}
+	for k, v := range viperConf.GetStringMapString(key) {
+		f, err := strconv.ParseFloat(v, 64)
+		exitIfErr(err, key+"."+k)
+		ret[k] = f
+	}
+	return ret
+}
+
+// getOptionalFloat reads a number from the config, or nil if it is unset, so that 0
+// can be told apart from a default.
+func getOptionalFloat(viperConf *viper.Viper, key string) *float64 {
+	if !viperConf.IsSet(key) {
+		return nil
+	}
+	v := viperConf.GetFloat64(key)
+	return &v
+}
+
+// getLabelLimits reads the per label size and confidence limits, e.g.
+//
+//	label-limits:
+//	  person:
+//	    min-confidence: 40
+func getLabelLimits(viperConf *viper.Viper) map[string]frame.LabelLimits {
+	ret := map[string]frame.LabelLimits{}
+	for label := range viperConf.GetStringMap("label-limits") {
+		conf := viperConf.Sub("label-limits." + label)
+		if conf == nil {
+			continue
+		}
+		// Only the limits given are overridden, so that 0 can remove a camera wide one.
+		limit := func(key string) *int {
+			if !conf.IsSet(key) {
+				return nil
+			}
+			v := conf.GetInt(key)
+			return &v
+		}
+		ret[label] = frame.LabelLimits{
+			MinConfidence: limit("min-confidence"),
+			MinWidth:      limit("min-width"),
+			MinHeight:     limit("min-height"),
+			MaxWidth:      limit("max-width"),
+			MaxHeight:     limit("max-height"),
+		}
+	}
+	return ret
+}
+
+// getZones reads the include zones and exclusion masks, e.g.
+//
+//	zones:
+//	  driveway:
+//	    points: "60,700 250,300 420,280 700,650"
+//	    anchor: bottom-centre
+//	exclude-zones:
+//	  footpath:
+//	    points: "0,600 720,560 720,620 0,680"
+func getZones(viperConf *viper.Viper) []*zone.Zone {
+	ret := []*zone.Zone{}
+	for _, key := range []string{"zones", "exclude-zones"} {
+		names := []string{}
+		for name := range viperConf.GetStringMap(key) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			conf := viperConf.Sub(key + "." + name)
+			if conf == nil {
+				continue
+			}
+			z, err := zone.New(name, conf.GetString("points"), conf.GetString("anchor"), conf.GetFloat64("min-overlap"), key == "exclude-zones")
+			exitIfErr(err, key)
+			ret = append(ret, z)
+		}
+	}
+	return ret
+}
+
+// getDwellRules reads the dwell settings of the include zones, e.g.
+//
+//	zones:
+//	  garage:
+//	    points: "400,300 700,300 700,720 400,720"
+//	    dwell: 30s
+//	    dwell-repeat: 5m
+//	    dwell-labels: ["person"]
+func getDwellRules(viperConf *viper.Viper) []*zone.DwellRule {
+	ret := []*zone.DwellRule{}
+	for name := range viperConf.GetStringMap("zones") {
+		conf := viperConf.Sub("zones." + name)
+		if conf == nil || conf.GetDuration("dwell") == 0 {
+			continue
+		}
+		ret = append(ret, &zone.DwellRule{
+			Zone:     name,
+			Labels:   conf.GetStringSlice("dwell-labels"),
+			MinDwell: conf.GetDuration("dwell"),
+			Repeat:   conf.GetDuration("dwell-repeat"),
+		})
+	}
+	return ret
+}
+
+// getTripwires reads the named lines, e.g.
+//
+//	tripwires:
+//	  gate:
+//	    points: "300,420 520,400"
+func getTripwires(viperConf *viper.Viper) []*zone.Tripwire {
+	ret := []*zone.Tripwire{}
+	names := []string{}
+	for name := range viperConf.GetStringMap("tripwires") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		conf := viperConf.Sub("tripwires." + name)
+		if conf == nil {
+			continue
+		}
+		t, err := zone.NewTripwire(name, conf.GetString("points"), conf.GetString("anchor"))
+		exitIfErr(err, "tripwires")
+		ret = append(ret, t)
+	}
+	return ret
+}
+
+// getCoords reads the camera's latitude and longitude for the sun rules, or the global
+// ones, nil if neither is set.
+func getCoords(viperConf *viper.Viper) (*sun.Coords, error) {
+	conf := viperConf
+	if !conf.IsSet("latitude") {
+		if !viper.IsSet("latitude") {
+			return nil, nil
+		}
+		conf = viper.GetViper()
+	}
+	if !conf.IsSet("longitude") {
+		return nil, errors.New("longitude is required with latitude")
+	}
+	c := &sun.Coords{
+		Lat: conf.GetFloat64("latitude"),
+		Lon: conf.GetFloat64("longitude"),
+	}
+	return c, c.Validate()
+}
+
+// getLocation reads the camera's IANA time zone, or the global one, time.Local if
+// neither is set.
+func getLocation(viperConf *viper.Viper) (*time.Location, error) {
+	name := viperConf.GetString("timezone")
+	if name == "" {
+		name = viper.GetString("timezone")
+	}
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
 func exitIfErr(err error, msg string) {
 	if err != nil {
 		log.Fatal(msg + ": " + err.Error())
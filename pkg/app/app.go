@@ -7,15 +7,22 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/marktheunissen/watchbot/pkg/archive"
+	"github.com/marktheunissen/watchbot/pkg/burst"
 	"github.com/marktheunissen/watchbot/pkg/camera"
+	"github.com/marktheunissen/watchbot/pkg/clip"
+	"github.com/marktheunissen/watchbot/pkg/datastore"
 	"github.com/marktheunissen/watchbot/pkg/detect"
 	"github.com/marktheunissen/watchbot/pkg/frame"
 	"github.com/marktheunissen/watchbot/pkg/jobs"
 	"github.com/marktheunissen/watchbot/pkg/messaging"
 	"github.com/marktheunissen/watchbot/pkg/systemerr"
+	"github.com/marktheunissen/watchbot/pkg/tracker"
 	"github.com/marktheunissen/watchbot/pkg/utils"
+	"github.com/marktheunissen/watchbot/pkg/zone"
 	"github.com/sirupsen/logrus"
 )
 
@@ -35,6 +42,9 @@ type Config struct {
 	HeartbeatURL    string
 	SnapshotChan    chan jobs.Cmd
 	FrameChan       chan jobs.Cmd
+
+	// Events older than this are deleted from the datastores, 0 keeps them forever.
+	EventMaxAge time.Duration
 }
 
 type App struct {
@@ -46,11 +56,15 @@ type App struct {
 	FrameInterval time.Duration
 	StartupTime   time.Time
 	HeartbeatURL  string
+	EventMaxAge   time.Duration
 	CancelFn      context.CancelFunc
 	RoundRobin    int
 
+	camsByID map[string]*camera.Cam
+
 	AlertUploadChan chan *jobs.UploadJob
 	InfoUploadChan  chan *jobs.UploadJob
+	RecordChan      chan *frameRecord
 	SnapshotChan    chan jobs.Cmd
 	FrameChan       chan jobs.Cmd
 	MsgChan         chan messaging.Msg
@@ -61,8 +75,13 @@ func New(config Config) (*App, error) {
 		config.FrameIntervalMS = 200
 	}
 	fi := time.Duration(config.FrameIntervalMS) * time.Millisecond
+	camsByID := map[string]*camera.Cam{}
 	for _, cam := range config.Cams {
-		stats.cams = append(stats.cams, NewCamMetrics(cam.Name))
+		if _, ok := camsByID[cam.ID]; ok {
+			return nil, fmt.Errorf("Duplicate camera ID: %s", cam.ID)
+		}
+		camsByID[cam.ID] = cam
+		stats.cams[cam.ID] = NewCamMetrics(cam.ID)
 	}
 	a := &App{
 		Cams:            config.Cams,
@@ -71,21 +90,32 @@ func New(config Config) (*App, error) {
 		PubSub:          config.PubSub,
 		FrameInterval:   fi,
 		HeartbeatURL:    config.HeartbeatURL,
+		EventMaxAge:     config.EventMaxAge,
 		AlertUploadChan: make(chan *jobs.UploadJob, 5000),
 		InfoUploadChan:  make(chan *jobs.UploadJob, 5000),
+		RecordChan:      make(chan *frameRecord, 1000),
 		SnapshotChan:    config.SnapshotChan,
 		FrameChan:       config.FrameChan,
 		MsgChan:         make(chan messaging.Msg),
+		camsByID:        camsByID,
 	}
 	return a, nil
 }
 
+// Cam returns the camera with the ID, nil if there is none.
+func (a *App) Cam(id string) *camera.Cam {
+	return a.camsByID[id]
+}
+
 func (a *App) Run(ctx context.Context) error {
 	a.StartupTime = time.Now().Round(time.Second)
 
 	// Supervise frame rate, exit for restart if it drops
 	go a.Supervisor(ctx)
 
+	// Report camera connection changes to Telegram
+	go a.ConnectionWatcher(ctx)
+
 	// Send critical errors to Telegram
 	go a.ErrorPoller(ctx)
 
@@ -95,6 +125,9 @@ func (a *App) Run(ctx context.Context) error {
 	// Uploader read
 	go a.Uploader(ctx)
 
+	// Writes the events to the datastore, and the hits to the local archive
+	go a.Recorder(ctx)
+
 	// Heartbeat to the healthcheck alert service
 	go a.Heartbeat(ctx)
 
@@ -117,42 +150,63 @@ func (a *App) Run(ctx context.Context) error {
 				log.Debug("Camera is not active yet")
 				break
 			}
+			if err == detect.ReplayFinished {
+				log.Info("Replay finished, stopping")
+				a.BotBroadcastMsg("Replay finished")
+				return nil
+			}
 			if err != nil {
 				return err
 			}
 
 		case cmd := <-a.SnapshotChan:
 			t := time.Now()
-			jpegBytes, err := a.Detector.SnapshotNextFrame(cmd.CamIndex)
+			jpegBytes, err := a.Detector.SnapshotNextFrame(cmd.CamID)
 			if err == detect.CamStatusInactive {
-				a.Cams[cmd.CamIndex].Bot.SendMsg("Camera feed is currently inactive, turn it on first")
+				a.Cam(cmd.CamID).Bot.SendMsg("Camera feed is currently inactive, turn it on first")
+				break
+			}
+			if err == detect.NoNewFrame || err == detect.CamStatusStalled || err == detect.CamStatusConnecting {
+				a.Cam(cmd.CamID).Bot.SendMsg("No frame from the camera yet, try again")
+				break
+			}
+			if err == detect.CamStatusFailed {
+				a.Cam(cmd.CamID).Bot.SendMsg("Camera connection failed, try `bot reconnect`")
 				break
 			}
 			if err != nil {
 				return err
 			}
 			a.InfoUploadChan <- &jobs.UploadJob{
-				Caption:  fmt.Sprintf("Snapshot: %s", t.Format("2006-01-02 15:04:05.00")),
-				Data:     bytes.NewBuffer(jpegBytes),
-				CamIndex: cmd.CamIndex,
+				Caption: fmt.Sprintf("Snapshot: %s", t.In(a.Cam(cmd.CamID).Location()).Format("2006-01-02 15:04:05.00 MST")),
+				Data:    bytes.NewBuffer(jpegBytes),
+				CamID:   cmd.CamID,
 			}
-			stats.cams[cmd.CamIndex].snapshot.Inc(1)
+			stats.cams[cmd.CamID].snapshot.Inc(1)
 
 		case cmd := <-a.FrameChan:
-			caption, jpegBytes, err := a.Detector.AnnotateNextFrame(cmd.CamIndex)
+			caption, jpegBytes, err := a.Detector.AnnotateNextFrame(cmd.CamID)
 			if err == detect.CamStatusInactive {
-				a.Cams[cmd.CamIndex].Bot.SendMsg("Camera feed is currently inactive, turn it on first")
+				a.Cam(cmd.CamID).Bot.SendMsg("Camera feed is currently inactive, turn it on first")
+				break
+			}
+			if err == detect.NoNewFrame || err == detect.CamStatusStalled || err == detect.CamStatusConnecting {
+				a.Cam(cmd.CamID).Bot.SendMsg("No frame from the camera yet, try again")
+				break
+			}
+			if err == detect.CamStatusFailed {
+				a.Cam(cmd.CamID).Bot.SendMsg("Camera connection failed, try `bot reconnect`")
 				break
 			}
 			if err != nil {
 				return err
 			}
 			a.InfoUploadChan <- &jobs.UploadJob{
-				Caption:  caption,
-				Data:     bytes.NewBuffer(jpegBytes),
-				CamIndex: cmd.CamIndex,
+				Caption: caption,
+				Data:    bytes.NewBuffer(jpegBytes),
+				CamID:   cmd.CamID,
 			}
-			stats.cams[cmd.CamIndex].snapshot.Inc(1)
+			stats.cams[cmd.CamID].snapshot.Inc(1)
 
 		case <-ctx.Done():
 			log.Info("App stopping")
@@ -166,20 +220,20 @@ func (a *App) Uploader(ctx context.Context) error {
 	for {
 		select {
 		case job := <-a.AlertUploadChan:
-			err := a.Cams[job.CamIndex].Bot.SendEvents([]string{job.Caption}, job.Data, true)
+			err := a.sendJob(job, true)
 			if err != nil {
 				log.Errorf("AlertChan Uploader SendEvents: %s", err)
-				stats.cams[job.CamIndex].uploadError.Inc(1)
+				stats.cams[job.CamID].uploadError.Inc(1)
 			} else {
-				stats.cams[job.CamIndex].uploadSuccess.Inc(1)
+				stats.cams[job.CamID].uploadSuccess.Inc(1)
 			}
 		case job := <-a.InfoUploadChan:
-			err := a.Cams[job.CamIndex].Bot.SendEvents([]string{job.Caption}, job.Data, false)
+			err := a.sendJob(job, false)
 			if err != nil {
 				log.Errorf("InfoChan Uploader SendEvents: %s", err)
-				stats.cams[job.CamIndex].uploadError.Inc(1)
+				stats.cams[job.CamID].uploadError.Inc(1)
 			} else {
-				stats.cams[job.CamIndex].uploadSuccess.Inc(1)
+				stats.cams[job.CamID].uploadSuccess.Inc(1)
 			}
 		case <-ctx.Done():
 			log.Info("Uploader stopping")
@@ -189,6 +243,157 @@ func (a *App) Uploader(ctx context.Context) error {
 	return nil
 }
 
+// frameRecord is what happened to the boxes of one frame, and the hit to archive if
+// any.
+type frameRecord struct {
+	CamID  string
+	Hit    *archive.Hit
+	Events []datastore.Event
+}
+
+func (r *frameRecord) add(box *frame.Box, now time.Time, status string, reason string) {
+	r.Events = append(r.Events, datastore.Event{
+		Camera:     r.CamID,
+		Time:       now,
+		Label:      box.Label,
+		Confidence: box.Confidence,
+		Rect:       box.Coords,
+		TrackID:    box.TrackID,
+		Status:     status,
+		Reason:     reason,
+	})
+}
+
+// Recorder writes the hits to each camera's archive and the events to its datastore,
+// away from the main loop, and prunes the archive after each write and the events
+// every hour.
+func (a *App) Recorder(ctx context.Context) {
+	a.pruneEvents(time.Now())
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+	for {
+		select {
+		case now := <-prune.C:
+			a.pruneEvents(now)
+		case rec := <-a.RecordChan:
+			cam := a.Cam(rec.CamID)
+			camStats := stats.cams[cam.ID]
+			if rec.Hit != nil {
+				a.archiveHit(cam, rec)
+			}
+			if len(rec.Events) == 0 {
+				continue
+			}
+			err := cam.Store.EventAdd(rec.Events...)
+			if err != nil {
+				log.Errorf("camera %s (%s) event write: %s", cam.ID, cam.Name, err)
+				camStats.eventError.Inc(1)
+				continue
+			}
+			camStats.eventWrite.Inc(int64(len(rec.Events)))
+		case <-ctx.Done():
+			log.Info("Recorder stopping")
+			return
+		}
+	}
+}
+
+// pruneEvents deletes the events that are past the max age from each camera's datastore.
+func (a *App) pruneEvents(now time.Time) {
+	if a.EventMaxAge == 0 {
+		return
+	}
+	for _, cam := range a.Cams {
+		camStats := stats.cams[cam.ID]
+		n, err := cam.Store.EventPrune(now.Add(-a.EventMaxAge))
+		if err != nil {
+			log.Errorf("camera %s (%s) event prune: %s", cam.ID, cam.Name, err)
+			camStats.eventError.Inc(1)
+			continue
+		}
+		camStats.eventPrune.Inc(n)
+	}
+}
+
+// archiveHit writes the hit, and points the events of its boxes at it.
+func (a *App) archiveHit(cam *camera.Cam, rec *frameRecord) {
+	camStats := stats.cams[cam.ID]
+	entry, err := cam.Archive.Write(rec.Hit)
+	if err != nil {
+		log.Errorf("camera %s (%s) archive write: %s", cam.ID, cam.Name, err)
+		camStats.archiveError.Inc(1)
+		return
+	}
+	camStats.archiveWrite.Inc(1)
+	for i := range rec.Events {
+		if rec.Events[i].Status != datastore.EventRejected {
+			rec.Events[i].Archive = entry.Dir
+		}
+	}
+	n, err := cam.Archive.Prune(time.Now())
+	if err != nil {
+		log.Errorf("camera %s (%s) archive prune: %s", cam.ID, cam.Name, err)
+		camStats.archiveError.Inc(1)
+	}
+	camStats.archivePrune.Inc(int64(n))
+}
+
+// record queues the frame's events, and its hit if the camera archives them and its
+// recording schedule is on.
+func (a *App) record(cam *camera.Cam, rec *frameRecord, fdr *frame.FrameDetectResult) {
+	if cam.Archive != nil && cam.SchedActive(datastore.RecordSched) && len(fdr.HitBoxes()) > 0 {
+		rec.Hit = &archive.Hit{
+			CamID:     cam.ID,
+			CamName:   cam.Name,
+			Time:      fdr.Time,
+			JPEGBytes: fdr.JPEGBytes,
+			Boxes:     fdr.HitBoxes(),
+		}
+	}
+	if len(rec.Events) == 0 && rec.Hit == nil {
+		return
+	}
+	a.queueRecord(cam, rec)
+}
+
+// recordAlert queues the event of an alert that isn't sent with a frame's boxes, e.g.
+// a tripwire crossing, once it has been sent or dropped.
+func (a *App) recordAlert(cam *camera.Cam, box *frame.Box, t time.Time, status string, reason string) {
+	rec := &frameRecord{CamID: cam.ID}
+	rec.add(box, t, status, reason)
+	a.queueRecord(cam, rec)
+}
+
+// queueRecord hands the record to the Recorder, dropping it if the Recorder is behind.
+func (a *App) queueRecord(cam *camera.Cam, rec *frameRecord) {
+	select {
+	case a.RecordChan <- rec:
+	default:
+		camStats := stats.cams[cam.ID]
+		camStats.eventDrop.Inc(int64(len(rec.Events)))
+		if rec.Hit != nil {
+			camStats.archiveDrop.Inc(1)
+		}
+	}
+}
+
+// sendJob uploads the job, alerts without a notification sound during quiet hours.
+func (a *App) sendJob(job *jobs.UploadJob, isAlert bool) error {
+	cam := a.Cam(job.CamID)
+	bot := cam.Bot
+	if !job.Time.IsZero() {
+		job.Caption = strings.TrimPrefix(job.Caption+", "+cam.FormatTime(job.Time), ", ")
+	}
+	silent := isAlert && cam.SchedActive(datastore.QuietSched)
+	if job.Video {
+		return bot.SendVideo(job.Caption, job.Data, isAlert, silent)
+	}
+	if job.GIF {
+		return bot.SendGIF(job.Caption, job.Data, isAlert, silent)
+	}
+	return bot.SendEvents([]string{job.Caption}, job.Data, isAlert, silent)
+}
+
 func (a *App) BotBroadcastMsg(msg string) {
 	for _, cam := range a.Cams {
 		cam.Bot.SendMsg(msg)
@@ -238,31 +443,61 @@ func (a *App) Heartbeat(ctx context.Context) {
 	}
 }
 
+// NextFrame runs the detector on the freshest frame of the next active camera that
+// has one, so a camera without new frames doesn't hold up the others.
 func (a *App) NextFrame() error {
-	a.RoundRobin += 1
-	if a.RoundRobin >= len(a.Cams) {
-		a.RoundRobin = 0
-	}
-	if a.Cams[a.RoundRobin].IsActive() {
+	for range a.Cams {
+		a.RoundRobin += 1
+		if a.RoundRobin >= len(a.Cams) {
+			a.RoundRobin = 0
+		}
+		if !a.Cams[a.RoundRobin].IsActive() {
+			log.Debugf("schedule for camera %s is off, skipping frame", a.Cams[a.RoundRobin].ID)
+			stats.frameSkip.Mark(1)
+			continue
+		}
 		err := a.NextFrameFromCam(a.RoundRobin)
+		if err == detect.NoNewFrame || err == detect.CamStatusInactive || err == detect.CamStatusConnecting || err == detect.CamStatusFailed {
+			continue
+		}
 		if err != nil {
 			return err
 		}
 		stats.frameRead.Mark(1)
-	} else {
-		log.Debugf("schedule for camera%d is off, skipping frame", a.RoundRobin)
-		stats.frameSkip.Mark(1)
+		return nil
 	}
 	return nil
 }
 
 func (a *App) NextFrameFromCam(index int) error {
 	cam := a.Cams[index]
-	camStats := stats.cams[cam.Index]
-	fdr, err := a.Detector.DetectNextFrame(cam.Index)
-	if err == detect.CamStatusInactive {
+	camStats := stats.cams[cam.ID]
+	fdr, err := a.Detector.DetectNextFrame(cam.ID)
+	if cs, ok := a.Detector.CaptureStats(cam.ID); ok {
+		camStats.captureRead.Update(cs.Read)
+		camStats.captureDropped.Update(cs.Dropped)
+	}
+	if err == nil || err == detect.NoMotion {
+		a.pushClipFrame(cam)
+	}
+	switch err {
+	case detect.CamStatusInactive, detect.CamStatusConnecting, detect.CamStatusFailed, detect.ReplayFinished, detect.NoNewFrame:
 		return err
 	}
+	if err == detect.CamStatusStalled {
+		log.Warnf("camera %s (%s) is stalled, skipping", cam.ID, cam.Name)
+		camStats.captureStalled.Inc(1)
+		return nil
+	}
+	if err == detect.NoMotion {
+		// Nothing changed, so whatever was tracked is still in view.
+		camStats.motionNone.Inc(1)
+		cam.Tracker.Hold(time.Now())
+		cam.Stationary.Hold(time.Now())
+		a.checkDwell(cam)
+		a.checkBurst(cam)
+		return nil
+	}
 	if err != nil {
 		camStats.detectorError.Inc(1)
 		return err
@@ -270,43 +505,204 @@ func (a *App) NextFrameFromCam(index int) error {
 	if fdr == nil {
 		log.Debug("nothing detected in frame")
 		camStats.detectorNone.Inc(1)
+		a.endTracks(cam, cam.Tracker.Update(nil, time.Now()))
+		cam.Stationary.Reject(nil, time.Now())
+		a.checkDwell(cam)
+		a.checkBurst(cam)
 		return nil
 	}
-	fdr.RejectSize(cam.MinWidth, cam.MinHeight, cam.MaxWidth, cam.MaxHeight)
+	now := fdr.Time
+	camStats.boxSuppress.Inc(int64(fdr.Suppressed))
 	fdr.RejectOrientation(cam.RequirePortrait)
 	fdr.RejectOutsideROI(cam.ROIRect)
-	fdr.RejectLowConfidence(cam.MinConfidence)
+	fdr.RejectZones(cam.Zones)
+	fdr.RejectLimits(cam.Limits(), cam.LabelLimits)
+	a.endTracks(cam, cam.Tracker.Update(fdr.Boxes, now))
+	for _, box := range fdr.Boxes {
+		// Every tracked box moved its track, so check the wires whether or not it was
+		// rejected in this frame, or a crossing could be missed.
+		a.checkTripwires(cam, box, fdr.JPEGBytes, now)
+	}
+	for _, id := range cam.Stationary.Reject(fdr.Boxes, now) {
+		// Alert again once it moves.
+		camStats.boxStationary.Inc(1)
+		cam.Tracker.ClearAlerted(id)
+	}
+	rec := &frameRecord{CamID: cam.ID}
+	defer a.record(cam, rec, fdr)
 	for _, box := range fdr.RejectedBoxes() {
 		camStats.boxReject.Inc(1)
+		rec.add(box, now, datastore.EventRejected, box.RejectReason)
 		if cam.SendRejected {
 			a.InfoUploadChan <- &jobs.UploadJob{
-				CamIndex: cam.Index,
-				Caption:  box.RejectReason,
-				Data:     bytes.NewBuffer(box.JPEGBytes),
+				CamID:   cam.ID,
+				Caption: box.RejectReason,
+				Data:    bytes.NewBuffer(box.JPEGBytes),
 			}
 		}
 	}
 	if len(fdr.HitBoxes()) > 0 {
 		camStats.detectorHit.Inc(1)
-		log.Infof("camera%d (%s) detector hit", cam.Index, cam.Name)
-		a.maybeSendOverview(cam.Index, bytes.NewBuffer(fdr.JPEGBytes))
+		log.Infof("camera %s (%s) detector hit", cam.ID, cam.Name)
+
+		// Alert once per track, rather than on every frame the object is in view.
+		newHits := []*frame.Box{}
 		for _, box := range fdr.HitBoxes() {
 			a.collectBoxStats(camStats, box)
-			a.maybeSendBox(cam.Index, box.LabelConfidence(), bytes.NewBuffer(box.JPEGBytes))
+			if cam.Clip != nil {
+				cam.Clip.Extend(now, box.Label)
+			}
+			cam.Dwell.Update(box.TrackID, box.Label, box.Zones, fdr.JPEGBytes, now)
+			if cam.Tracker.Alerted(box.TrackID) {
+				camStats.boxTracked.Inc(1)
+				rec.add(box, now, datastore.EventSkipped, "already alerted")
+				continue
+			}
+			if cam.Dwell.Covers(box.Label, box.Zones) {
+				// Only alerts once it has stayed long enough.
+				camStats.boxDwelling.Inc(1)
+				rec.add(box, now, datastore.EventSkipped, "dwelling")
+				continue
+			}
+			if !cam.SchedActive(datastore.AlertSched) {
+				// Not marked as alerted, so that it alerts if it's still in view
+				// when the alerting schedule comes on.
+				camStats.boxOffSched.Inc(1)
+				rec.add(box, now, datastore.EventSkipped, "alerting schedule off")
+				continue
+			}
+			newHits = append(newHits, box)
+		}
+		for _, box := range newHits {
+			if cam.Clip != nil {
+				cam.Clip.Trigger(now, box.Label)
+			}
+		}
+		if cam.Burst.Enabled() {
+			// The whole event goes out as one animation, once it's quiet.
+			if len(newHits) > 0 || cam.Burst.Active() {
+				labels := []string{}
+				for _, box := range fdr.HitBoxes() {
+					labels = append(labels, box.Label)
+				}
+				cam.Burst.Add(fdr.JPEGBytes, labels, now)
+			}
+			for _, box := range newHits {
+				cam.Tracker.SetAlerted(box.TrackID)
+				// Recorded as sent or dropped once the event goes out.
+				cam.Burst.Hit(box, now)
+			}
+		} else {
+			if len(newHits) > 0 {
+				a.maybeSendOverview(cam, bytes.NewBuffer(fdr.JPEGBytes))
+			}
+			for _, box := range newHits {
+				if a.maybeSendBox(cam, box.Caption(), bytes.NewBuffer(box.JPEGBytes)) {
+					cam.Tracker.SetAlerted(box.TrackID)
+					rec.add(box, now, datastore.EventSent, "")
+				} else {
+					rec.add(box, now, datastore.EventDropped, "rate limited")
+				}
+			}
 		}
+	} else if fdr.Motion != nil && cam.MotionAlert && cam.SchedActive(datastore.AlertSched) {
+		camStats.motionHit.Inc(1)
+		a.maybeSendMotion(cam, fdr.Motion, bytes.NewBuffer(fdr.JPEGBytes))
 	}
+	a.checkDwell(cam)
+	a.checkBurst(cam)
 	return nil
 }
 
+func (a *App) endTracks(cam *camera.Cam, expired []tracker.Track) {
+	for _, track := range expired {
+		log.Debugf("camera %s (%s) track %d ended: %s, lifetime: %v, path: %v", cam.ID, cam.Name, track.ID, track.Label, track.Lifetime(), track.Path)
+		stats.cams[cam.ID].trackLifetimes.Inc(int(track.Lifetime().Seconds()))
+		cam.Dwell.End(track.ID)
+	}
+}
+
+// checkTripwires sends an event for each line the box's track crossed since its last hit.
+func (a *App) checkTripwires(cam *camera.Cam, box *frame.Box, jpegBytes []byte, now time.Time) {
+	if len(cam.Tripwires) == 0 || !cam.SchedActive(datastore.AlertSched) {
+		return
+	}
+	track, ok := cam.Tracker.Track(box.TrackID)
+	if !ok || track.Hits < 2 {
+		return
+	}
+	for _, wire := range cam.Tripwires {
+		direction := wire.Cross(track.PrevBox, track.Box)
+		if direction == "" {
+			continue
+		}
+		log.Infof("camera %s (%s) track %d crossed %s: %s", cam.ID, cam.Name, track.ID, wire.Name, direction)
+		camStats := stats.cams[cam.ID]
+		camStats.tripwireCross.Inc(1)
+		reason := fmt.Sprintf("tripwire %s %s", wire.Name, direction)
+		if !cam.TakeFrameBuckets() {
+			camStats.tripwireDrop.Inc(1)
+			a.recordAlert(cam, box, now, datastore.EventDropped, reason+", rate limited")
+			continue
+		}
+		camStats.tripwireSend.Inc(1)
+		a.recordAlert(cam, box, now, datastore.EventSent, reason)
+		a.AlertUploadChan <- &jobs.UploadJob{
+			CamID:   cam.ID,
+			Caption: tripwireCaption(box, wire.Name, direction),
+			Data:    bytes.NewBuffer(jpegBytes),
+			Time:    time.Now(),
+		}
+	}
+}
+
+// checkDwell sends an alert for each track that stayed in a zone for too long.
+func (a *App) checkDwell(cam *camera.Cam) {
+	camStats := stats.cams[cam.ID]
+	now := time.Now()
+	for _, due := range cam.Dwell.Due(now) {
+		if !cam.SchedActive(datastore.AlertSched) {
+			// Not marked as alerted, so that it alerts if it's still there when the
+			// alerting schedule comes on.
+			continue
+		}
+		log.Infof("camera %s (%s) track %d in %s for %v", cam.ID, cam.Name, due.TrackID, due.Zone, due.Duration)
+		box := &frame.Box{Label: due.Label, TrackID: due.TrackID}
+		reason := fmt.Sprintf("dwell in %s for %v", due.Zone, utils.Round(due.Duration, time.Second))
+		if !cam.TakeFrameBuckets() {
+			camStats.dwellDrop.Inc(1)
+			a.recordAlert(cam, box, now, datastore.EventDropped, reason+", rate limited")
+			continue
+		}
+		camStats.dwellSend.Inc(1)
+		cam.Dwell.SetAlerted(due.TrackID, due.Zone, now)
+		a.recordAlert(cam, box, now, datastore.EventSent, reason)
+		a.AlertUploadChan <- &jobs.UploadJob{
+			CamID:   cam.ID,
+			Caption: fmt.Sprintf("%s in %s for %v", strings.Title(due.Label), due.Zone, utils.Round(due.Duration, time.Second)),
+			Data:    bytes.NewBuffer(due.JPEGBytes),
+			Time:    time.Now(),
+		}
+	}
+}
+
+// tripwireCaption reads e.g. "Person entered via gate".
+func tripwireCaption(box *frame.Box, wire string, direction string) string {
+	verb := "entered"
+	if direction == zone.DirectionOut {
+		verb = "left"
+	}
+	return fmt.Sprintf("%s %s via %s", box.LabelPretty(), verb, wire)
+}
+
 func (a *App) collectBoxStats(stats *CamMetrics, b *frame.Box) {
 	stats.boxWidths.Inc(b.GetWidth())
 	stats.boxHeights.Inc(b.GetHeight())
 	stats.boxConfidences.Inc(b.Confidence)
 }
 
-func (a *App) maybeSendOverview(camIndex int, jpegBytes io.Reader) {
-	cam := a.Cams[camIndex]
-	camStats := stats.cams[camIndex]
+func (a *App) maybeSendOverview(cam *camera.Cam, jpegBytes io.Reader) {
+	camStats := stats.cams[cam.ID]
 	if time.Now().Sub(cam.LastOverviewSent) < 10*time.Second {
 		camStats.overviewDrop.Inc(1)
 		return
@@ -319,31 +715,149 @@ func (a *App) maybeSendOverview(camIndex int, jpegBytes io.Reader) {
 	cam.LastOverviewSent = time.Now()
 	camStats.overviewSend.Inc(1)
 	a.AlertUploadChan <- &jobs.UploadJob{
-		CamIndex: camIndex,
-		Caption:  "",
-		Data:     jpegBytes,
+		CamID:   cam.ID,
+		Caption: "",
+		Data:    jpegBytes,
+		Time:    cam.LastOverviewSent,
 	}
 }
 
-func (a *App) maybeSendBox(camIndex int, label string, jpegBytes io.Reader) {
-	cam := a.Cams[camIndex]
-	camStats := stats.cams[camIndex]
+func (a *App) maybeSendMotion(cam *camera.Cam, motion *frame.Motion, jpegBytes io.Reader) {
+	camStats := stats.cams[cam.ID]
+	if time.Now().Sub(cam.LastMotionSent) < cam.MotionAlertInterval {
+		camStats.motionDrop.Inc(1)
+		return
+	}
 	canContinue := cam.TakeFrameBuckets()
 	if !canContinue {
-		camStats.boxDrop.Inc(1)
+		camStats.motionDrop.Inc(1)
 		return
 	}
+	cam.LastMotionSent = time.Now()
+	camStats.motionSend.Inc(1)
+	a.AlertUploadChan <- &jobs.UploadJob{
+		CamID:   cam.ID,
+		Caption: fmt.Sprintf("Motion: %.1f%%", motion.Percent),
+		Data:    jpegBytes,
+		Time:    cam.LastMotionSent,
+	}
+}
+
+func (a *App) maybeSendBox(cam *camera.Cam, label string, jpegBytes io.Reader) bool {
+	camStats := stats.cams[cam.ID]
+	canContinue := cam.TakeFrameBuckets()
+	if !canContinue {
+		camStats.boxDrop.Inc(1)
+		return false
+	}
 	camStats.boxSend.Inc(1)
 	a.AlertUploadChan <- &jobs.UploadJob{
-		CamIndex: camIndex,
-		Caption:  label,
-		Data:     jpegBytes,
+		CamID:   cam.ID,
+		Caption: label,
+		Data:    jpegBytes,
+		Time:    time.Now(),
+	}
+	return true
+}
+
+// checkBurst sends the camera's collected event once it has gone quiet.
+func (a *App) checkBurst(cam *camera.Cam) {
+	e := cam.Burst.Due(time.Now())
+	if e == nil {
+		return
+	}
+	camStats := stats.cams[cam.ID]
+	canContinue := cam.TakeFrameBuckets()
+	if !canContinue {
+		camStats.burstDrop.Inc(1)
+		a.recordBurst(cam, e, datastore.EventDropped, cam.Burst.Mode+", rate limited")
+		return
+	}
+	log.Infof("camera %s (%s) sending %s of %d frames", cam.ID, cam.Name, cam.Burst.Mode, len(e.Frames))
+	go func() {
+		job := &jobs.UploadJob{
+			CamID:   cam.ID,
+			Caption: e.Caption(),
+			Time:    e.Frames[0].Time,
+		}
+		var data []byte
+		var err error
+		if cam.Burst.Mode == burst.ModeMP4 {
+			data, err = e.MP4(cam.Burst.Codec)
+			job.Video = true
+		} else {
+			data, err = e.GIF(cam.Burst.MaxWidth)
+			job.GIF = true
+		}
+		if err != nil {
+			log.Errorf("camera %s (%s) burst: %s", cam.ID, cam.Name, err)
+			camStats.burstError.Inc(1)
+			a.recordBurst(cam, e, datastore.EventDropped, cam.Burst.Mode+" error")
+			return
+		}
+		job.Data = bytes.NewBuffer(data)
+		camStats.burstSend.Inc(1)
+		a.recordBurst(cam, e, datastore.EventSent, cam.Burst.Mode)
+		a.AlertUploadChan <- job
+	}()
+}
+
+// recordBurst queues the events of the boxes the burst alerted for.
+func (a *App) recordBurst(cam *camera.Cam, e *burst.Event, status string, reason string) {
+	if len(e.Hits) == 0 {
+		return
+	}
+	rec := &frameRecord{CamID: cam.ID}
+	for _, h := range e.Hits {
+		rec.add(h.Box, h.Time, status, reason)
+	}
+	a.queueRecord(cam, rec)
+}
+
+// pushClipFrame adds the frame just taken from the camera to its clip buffer, and sends
+// the clip once it has ended.
+func (a *App) pushClipFrame(cam *camera.Cam) {
+	if cam.Clip == nil {
+		return
+	}
+	c := cam.Clip.Push(a.Detector.FrameRaw, a.Detector.FrameTime)
+	if c != nil {
+		a.maybeSendClip(cam, c)
+	}
+}
+
+// maybeSendClip encodes the clip in the background, it takes from the same buckets as
+// the images so that clips can't go over the Telegram limits.
+func (a *App) maybeSendClip(cam *camera.Cam, c *clip.Clip) {
+	camStats := stats.cams[cam.ID]
+	canContinue := cam.TakeFrameBuckets()
+	if !canContinue {
+		camStats.clipDrop.Inc(1)
+		c.Close()
+		return
 	}
+	go func() {
+		defer c.Close()
+		data, err := c.MP4()
+		if err != nil {
+			log.Errorf("camera %s (%s) clip: %s", cam.ID, cam.Name, err)
+			camStats.clipError.Inc(1)
+			return
+		}
+		camStats.clipSend.Inc(1)
+		a.AlertUploadChan <- &jobs.UploadJob{
+			CamID:   cam.ID,
+			Caption: c.Caption(),
+			Data:    bytes.NewBuffer(data),
+			Video:   true,
+			Time:    c.Start(),
+		}
+	}()
 }
 
 func (a *App) GetParams() string {
 	out := fmt.Sprintf("FrameInterval: %v\n", a.FrameInterval)
-	out += fmt.Sprintf("AlertLabels: %v\n", a.Detector.AlertLabels)
+	out += fmt.Sprintf("Backend: %s\n", a.Detector.Backend.Name())
 	return utils.MarkdownCode(out)
 }
 
@@ -3,6 +3,9 @@ package app
 import (
 	"reflect"
 	"testing"
+
+	"github.com/marktheunissen/watchbot/pkg/datastore"
+	"github.com/marktheunissen/watchbot/pkg/jobs"
 )
 
 // TestEnsureStats ensures that the stats are all correctly non-nil.
@@ -17,3 +20,23 @@ func TestEnsureStats(t *testing.T) {
 		}
 	}
 }
+
+func TestSchedArgs(t *testing.T) {
+	tests := []struct {
+		cmd   jobs.Cmd
+		sched datastore.ScheduleName
+		verb  string
+		obj   string
+	}{
+		{jobs.Cmd{Noun: "sched", Verb: "on", Obj: "mon-fri 22:30-06:15"}, datastore.UploadSched, "on", "mon-fri 22:30-06:15"},
+		{jobs.Cmd{Noun: "sched", Verb: "alert", Obj: "on mon-fri 18:00-08:00"}, datastore.AlertSched, "on", "mon-fri 18:00-08:00"},
+		{jobs.Cmd{Noun: "sched", Verb: "quiet", Obj: "GET"}, datastore.QuietSched, "get", ""},
+		{jobs.Cmd{Noun: "mode", Verb: "recording", Obj: "set off for 2d"}, datastore.RecordSched, "set", "off for 2d"},
+	}
+	for _, test := range tests {
+		sched, cmd := schedArgs(test.cmd)
+		if sched != test.sched || cmd.Verb != test.verb || cmd.Obj != test.obj {
+			t.Errorf("%v: got %s %q %q", test.cmd, sched, cmd.Verb, cmd.Obj)
+		}
+	}
+}
@@ -10,39 +10,107 @@ import (
 )
 
 type CamMetrics struct {
-	snapshot       metrics.Counter
-	uploadError    metrics.Counter
-	uploadSuccess  metrics.Counter
-	overviewDrop   metrics.Counter
-	overviewSend   metrics.Counter
-	boxSend        metrics.Counter
-	boxDrop        metrics.Counter
-	boxReject      metrics.Counter
-	detectorError  metrics.Counter
-	detectorNone   metrics.Counter
-	detectorHit    metrics.Counter
-	boxWidths      *HistVals
-	boxHeights     *HistVals
-	boxConfidences *HistVals
+	snapshot         metrics.Counter
+	captureRead      metrics.Gauge
+	captureDropped   metrics.Gauge
+	captureStalled   metrics.Counter
+	captureReconnect metrics.Counter
+	captureFailed    metrics.Counter
+	uploadError      metrics.Counter
+	uploadSuccess    metrics.Counter
+	overviewDrop     metrics.Counter
+	overviewSend     metrics.Counter
+	boxSend          metrics.Counter
+	boxDrop          metrics.Counter
+	boxReject        metrics.Counter
+	boxSuppress      metrics.Counter
+	boxTracked       metrics.Counter
+	detectorError    metrics.Counter
+	detectorNone     metrics.Counter
+	detectorHit      metrics.Counter
+	motionNone       metrics.Counter
+	motionHit        metrics.Counter
+	motionSend       metrics.Counter
+	motionDrop       metrics.Counter
+	tripwireCross    metrics.Counter
+	tripwireSend     metrics.Counter
+	tripwireDrop     metrics.Counter
+	boxDwelling      metrics.Counter
+	boxStationary    metrics.Counter
+	boxOffSched      metrics.Counter
+	dwellSend        metrics.Counter
+	dwellDrop        metrics.Counter
+	clipSend         metrics.Counter
+	clipDrop         metrics.Counter
+	clipError        metrics.Counter
+	burstSend        metrics.Counter
+	burstDrop        metrics.Counter
+	burstError       metrics.Counter
+	archiveWrite     metrics.Counter
+	archiveDrop      metrics.Counter
+	archiveError     metrics.Counter
+	archivePrune     metrics.Counter
+	eventWrite       metrics.Counter
+	eventDrop        metrics.Counter
+	eventError       metrics.Counter
+	eventPrune       metrics.Counter
+	boxWidths        *HistVals
+	boxHeights       *HistVals
+	boxConfidences   *HistVals
+	trackLifetimes   *HistVals
 }
 
 func NewCamMetrics(name string) *CamMetrics {
 	name = strings.ToLower(name)
 	return &CamMetrics{
-		snapshot:       metrics.GetOrRegisterCounter(name+".snapshot", metrics.DefaultRegistry),
-		uploadError:    metrics.GetOrRegisterCounter(name+".upload.error", metrics.DefaultRegistry),
-		uploadSuccess:  metrics.GetOrRegisterCounter(name+".upload.success", metrics.DefaultRegistry),
-		overviewDrop:   metrics.GetOrRegisterCounter(name+".overview.drop", metrics.DefaultRegistry),
-		overviewSend:   metrics.GetOrRegisterCounter(name+".overview.send", metrics.DefaultRegistry),
-		boxDrop:        metrics.GetOrRegisterCounter(name+".box.drop", metrics.DefaultRegistry),
-		boxSend:        metrics.GetOrRegisterCounter(name+".box.send", metrics.DefaultRegistry),
-		boxReject:      metrics.GetOrRegisterCounter(name+".box.reject", metrics.DefaultRegistry),
-		detectorError:  metrics.GetOrRegisterCounter(name+".detector.error", metrics.DefaultRegistry),
-		detectorNone:   metrics.GetOrRegisterCounter(name+".detector.none", metrics.DefaultRegistry),
-		detectorHit:    metrics.GetOrRegisterCounter(name+".detector.hit", metrics.DefaultRegistry),
-		boxWidths:      NewHistVals(name+" Box Widths", 40),
-		boxHeights:     NewHistVals(name+" Box Heights", 40),
-		boxConfidences: NewHistVals(name+" Confidences", 20),
+		snapshot:         metrics.GetOrRegisterCounter(name+".snapshot", metrics.DefaultRegistry),
+		captureRead:      metrics.GetOrRegisterGauge(name+".capture.read", metrics.DefaultRegistry),
+		captureDropped:   metrics.GetOrRegisterGauge(name+".capture.dropped", metrics.DefaultRegistry),
+		captureStalled:   metrics.GetOrRegisterCounter(name+".capture.stalled", metrics.DefaultRegistry),
+		captureReconnect: metrics.GetOrRegisterCounter(name+".capture.reconnect", metrics.DefaultRegistry),
+		captureFailed:    metrics.GetOrRegisterCounter(name+".capture.failed", metrics.DefaultRegistry),
+		uploadError:      metrics.GetOrRegisterCounter(name+".upload.error", metrics.DefaultRegistry),
+		uploadSuccess:    metrics.GetOrRegisterCounter(name+".upload.success", metrics.DefaultRegistry),
+		overviewDrop:     metrics.GetOrRegisterCounter(name+".overview.drop", metrics.DefaultRegistry),
+		overviewSend:     metrics.GetOrRegisterCounter(name+".overview.send", metrics.DefaultRegistry),
+		boxDrop:          metrics.GetOrRegisterCounter(name+".box.drop", metrics.DefaultRegistry),
+		boxSend:          metrics.GetOrRegisterCounter(name+".box.send", metrics.DefaultRegistry),
+		boxReject:        metrics.GetOrRegisterCounter(name+".box.reject", metrics.DefaultRegistry),
+		boxSuppress:      metrics.GetOrRegisterCounter(name+".box.suppress", metrics.DefaultRegistry),
+		boxTracked:       metrics.GetOrRegisterCounter(name+".box.tracked", metrics.DefaultRegistry),
+		detectorError:    metrics.GetOrRegisterCounter(name+".detector.error", metrics.DefaultRegistry),
+		detectorNone:     metrics.GetOrRegisterCounter(name+".detector.none", metrics.DefaultRegistry),
+		detectorHit:      metrics.GetOrRegisterCounter(name+".detector.hit", metrics.DefaultRegistry),
+		motionNone:       metrics.GetOrRegisterCounter(name+".motion.none", metrics.DefaultRegistry),
+		motionHit:        metrics.GetOrRegisterCounter(name+".motion.hit", metrics.DefaultRegistry),
+		motionSend:       metrics.GetOrRegisterCounter(name+".motion.send", metrics.DefaultRegistry),
+		motionDrop:       metrics.GetOrRegisterCounter(name+".motion.drop", metrics.DefaultRegistry),
+		tripwireCross:    metrics.GetOrRegisterCounter(name+".tripwire.cross", metrics.DefaultRegistry),
+		tripwireSend:     metrics.GetOrRegisterCounter(name+".tripwire.send", metrics.DefaultRegistry),
+		tripwireDrop:     metrics.GetOrRegisterCounter(name+".tripwire.drop", metrics.DefaultRegistry),
+		boxDwelling:      metrics.GetOrRegisterCounter(name+".box.dwelling", metrics.DefaultRegistry),
+		boxStationary:    metrics.GetOrRegisterCounter(name+".box.stationary", metrics.DefaultRegistry),
+		boxOffSched:      metrics.GetOrRegisterCounter(name+".box.offsched", metrics.DefaultRegistry),
+		dwellSend:        metrics.GetOrRegisterCounter(name+".dwell.send", metrics.DefaultRegistry),
+		dwellDrop:        metrics.GetOrRegisterCounter(name+".dwell.drop", metrics.DefaultRegistry),
+		clipSend:         metrics.GetOrRegisterCounter(name+".clip.send", metrics.DefaultRegistry),
+		clipDrop:         metrics.GetOrRegisterCounter(name+".clip.drop", metrics.DefaultRegistry),
+		clipError:        metrics.GetOrRegisterCounter(name+".clip.error", metrics.DefaultRegistry),
+		burstSend:        metrics.GetOrRegisterCounter(name+".burst.send", metrics.DefaultRegistry),
+		burstDrop:        metrics.GetOrRegisterCounter(name+".burst.drop", metrics.DefaultRegistry),
+		burstError:       metrics.GetOrRegisterCounter(name+".burst.error", metrics.DefaultRegistry),
+		archiveWrite:     metrics.GetOrRegisterCounter(name+".archive.write", metrics.DefaultRegistry),
+		archiveDrop:      metrics.GetOrRegisterCounter(name+".archive.drop", metrics.DefaultRegistry),
+		archiveError:     metrics.GetOrRegisterCounter(name+".archive.error", metrics.DefaultRegistry),
+		archivePrune:     metrics.GetOrRegisterCounter(name+".archive.prune", metrics.DefaultRegistry),
+		eventWrite:       metrics.GetOrRegisterCounter(name+".event.write", metrics.DefaultRegistry),
+		eventDrop:        metrics.GetOrRegisterCounter(name+".event.drop", metrics.DefaultRegistry),
+		eventError:       metrics.GetOrRegisterCounter(name+".event.error", metrics.DefaultRegistry),
+		eventPrune:       metrics.GetOrRegisterCounter(name+".event.prune", metrics.DefaultRegistry),
+		boxWidths:        NewHistVals(name+" Box Widths", 40),
+		boxHeights:       NewHistVals(name+" Box Heights", 40),
+		boxConfidences:   NewHistVals(name+" Confidences", 20),
+		trackLifetimes:   NewHistVals(name+" Track Lifetimes", 40),
 	}
 }
 
@@ -53,7 +121,7 @@ type appMetrics struct {
 	supervisorTick metrics.Counter
 	heartbeatTick  metrics.Counter
 	heartbeatError metrics.Counter
-	cams           []*CamMetrics
+	cams           map[string]*CamMetrics
 }
 
 var stats = &appMetrics{
@@ -63,7 +131,7 @@ var stats = &appMetrics{
 	supervisorTick: metrics.GetOrRegisterCounter("supervisor.tick", metrics.DefaultRegistry),
 	heartbeatTick:  metrics.GetOrRegisterCounter("heartbeat.tick", metrics.DefaultRegistry),
 	heartbeatError: metrics.GetOrRegisterCounter("heartbeat.error", metrics.DefaultRegistry),
-	cams:           []*CamMetrics{},
+	cams:           map[string]*CamMetrics{},
 }
 
 func MetricsPrintOut() string {
@@ -3,11 +3,15 @@ package app
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/marktheunissen/watchbot/pkg/camera"
 	"github.com/marktheunissen/watchbot/pkg/datastore"
+	"github.com/marktheunissen/watchbot/pkg/detect"
 	"github.com/marktheunissen/watchbot/pkg/jobs"
+	"github.com/marktheunissen/watchbot/pkg/utils"
 )
 
 var helpText = `
@@ -20,20 +24,35 @@ bot metrics
 bot uptime
 bot tokens
 bot hists
+bot tracks
+bot stationary [clear]
+bot archive [label]
+bot events [day|hour]
+bot conn
+bot reconnect
 bot isactive
 bot restart
-bot sched get
-bot sched init
-bot sched on <hour-day|day|hour,...> e.g. mon-0, mon, 0
-bot sched off <hour-day|day|hour,...>
-bot mode get
-bot mode set <on|off|sched>
+bot sched [name] get
+bot sched [name] init
+bot sched [name] on <range,...> e.g. mon-fri 22:30-06:15, sat, mon-0
+bot sched [name] off <range,...>
+bot sched [name] except [<on|off> <date[..date]> [HH:MM-HH:MM] [note]] e.g. off 2026-12-24..2026-12-26 christmas
+bot sched [name] except del <id>
+bot sched [name] sun [[days] from <sunrise|sunset>[+-offset] to <sunrise|sunset>[+-offset]] e.g. from sunset+30m to sunrise-15m
+bot sched [name] sun del <id>
+bot sched [name] export [yaml|json]
+bot sched import <yaml or json>
+bot sched [name] copy <camera>
+bot mode [name] get
+bot mode [name] set <on|off|sched> [for <duration>|until <date> [HH:MM]] e.g. on for 3h
+bot mode [name] clear
+Schedules: detect (the default), alert, record, quiet (alerts without sound), e.g. bot sched alert on mon-fri 18:00-08:00
 `
 
 func (a *App) ListenTelegram(ctx context.Context) {
 	cmds := make(chan jobs.Cmd, 10000)
-	for i, _ := range a.Cams {
-		go a.Cams[i].Bot.PollUpdatesToChan(i, cmds)
+	for _, cam := range a.Cams {
+		go cam.Bot.PollUpdatesToChan(cam.ID, cmds)
 	}
 
 	log := log.WithField("function", "listenTelegram")
@@ -50,7 +69,11 @@ func (a *App) ListenTelegram(ctx context.Context) {
 
 func (a *App) handleIncomingCmd(cmd jobs.Cmd) {
 	log.Infof("Got cmd: %v", cmd)
-	c := a.Cams[cmd.CamIndex]
+	c := a.Cam(cmd.CamID)
+	if c == nil {
+		log.Errorf("Cmd for unknown camera: %s", cmd.CamID)
+		return
+	}
 	if cmd.Noun == "help" {
 		c.Bot.SendMsg(helpText)
 	}
@@ -74,27 +97,71 @@ func (a *App) handleIncomingCmd(cmd jobs.Cmd) {
 		a.CancelAndExit()
 	}
 	if cmd.Noun == "hists" {
-		i := cmd.CamIndex
-		err := a.SendHist(i, stats.cams[i].boxHeights)
+		camStats := stats.cams[c.ID]
+		err := a.SendHist(c, camStats.boxHeights)
 		if err != nil {
 			log.Errorf("Hists: %s", err)
 		}
-		err = a.SendHist(i, stats.cams[i].boxWidths)
+		err = a.SendHist(c, camStats.boxWidths)
 		if err != nil {
 			log.Errorf("Hists: %s", err)
 		}
-		err = a.SendHist(i, stats.cams[i].boxConfidences)
+		err = a.SendHist(c, camStats.boxConfidences)
 		if err != nil {
 			log.Errorf("Hists: %s", err)
 		}
+		err = a.SendHist(c, camStats.trackLifetimes)
+		if err != nil {
+			log.Errorf("Hists: %s", err)
+		}
+	}
+	if cmd.Noun == "tracks" {
+		c.Bot.SendMsg(c.TracksSummary())
+	}
+	if cmd.Noun == "stationary" {
+		if cmd.Verb == "clear" {
+			err := c.Stationary.Clear()
+			if err != nil {
+				log.Errorf("Stationary clear: %s", err)
+			}
+		}
+		c.Bot.SendMsg(c.StationarySummary())
+	}
+	if cmd.Noun == "archive" {
+		c.Bot.SendMsg(archiveSummary(c, cmd.Verb))
+	}
+	if cmd.Noun == "events" {
+		c.Bot.SendMsg(eventsSummary(c, cmd.Verb, time.Now().In(c.Location())))
+	}
+	if cmd.Noun == "conn" {
+		cs, ok := a.Detector.CaptureStats(c.ID)
+		if !ok {
+			c.Bot.SendMsg("Camera feed is currently inactive")
+		} else {
+			c.Bot.SendMsg(fmt.Sprintf("Connection: %s, failed attempts: %d, connects: %d, last frame: %s", cs.State, cs.Attempt, cs.Connects, cs.FrameTime.In(c.Location()).Format("2006-01-02 15:04:05 MST")))
+		}
+	}
+	if cmd.Noun == "reconnect" {
+		err := a.Detector.Reconnect(c.ID)
+		if err == detect.CamStatusInactive {
+			c.Bot.SendMsg("Camera feed is currently inactive, turn it on first")
+		} else if err != nil {
+			log.Errorf("Reconnect: %s", err)
+		} else {
+			c.Bot.SendMsg("Reconnecting")
+		}
 	}
 	if cmd.Noun == "isactive" {
-		active, err := c.Store.SchedActiveNow(datastore.UploadSched)
-		if err != nil {
-			log.Errorf("SchedActiveNow: %s", err)
-			return
+		out := ""
+		for _, sched := range datastore.Schedules {
+			active, err := c.Store.SchedActiveNow(sched)
+			if err != nil {
+				log.Errorf("SchedActiveNow: %s", err)
+				return
+			}
+			out += fmt.Sprintf("%s: %v\n", sched, active)
 		}
-		c.Bot.SendMsg(fmt.Sprintf("IsActive: %v", active))
+		c.Bot.SendMsg(utils.MarkdownCode(out))
 	}
 	if cmd.Noun == "metrics" {
 		c.Bot.SendMsg(MetricsPrintOut())
@@ -103,89 +170,354 @@ func (a *App) handleIncomingCmd(cmd jobs.Cmd) {
 		c.Bot.SendMsg(fmt.Sprintf("Uptime: %s", time.Now().Round(time.Second).Sub(a.StartupTime)))
 	}
 	if cmd.Noun == "sched" {
+		// Export and copy take all the schedules unless one is named.
+		_, named := datastore.StrScheduleName(cmd.Verb)
+		sched, cmd := schedArgs(cmd)
 		if cmd.Verb == "init" {
-			err := c.Store.SchedActivateAll(datastore.UploadSched)
+			err := c.Store.SchedActivateAll(sched)
 			if err != nil {
 				log.Errorf("Sched init: %s", err)
 			}
-			filebytes, err := c.Store.SchedGetTable(datastore.UploadSched)
-			if err != nil {
-				log.Errorf("Sched get: %s", err)
-			} else {
-				c.Bot.SendImageBytesBuf(filebytes)
-			}
+			a.sendSched(c, sched)
 		}
 		if cmd.Verb == "get" {
-			filebytes, err := c.Store.SchedGetTable(datastore.UploadSched)
-			if err != nil {
-				log.Errorf("Sched get: %s", err)
-			} else {
-				c.Bot.SendImageBytesBuf(filebytes)
-			}
+			a.sendSched(c, sched)
 		}
 		if cmd.Verb == "on" {
 			if cmd.Obj == "" {
-				c.Bot.SendMsg("usage: bot sched on <hour-day|day|hour,...>")
+				c.Bot.SendMsg("usage: bot sched [name] on <range,...> e.g. mon-fri 22:30-06:15, sat, mon-0")
 				return
 			}
 			for _, o := range strings.Split(cmd.Obj, ",") {
-				err := c.Store.SchedActivate(datastore.UploadSched, strings.TrimSpace(o))
+				err := c.Store.SchedActivate(sched, strings.TrimSpace(o))
 				if err != nil {
 					log.Errorf("Sched set: %s", err)
+					c.Bot.SendMsg(fmt.Sprintf("Sched error: %s", err))
 				}
 			}
-			filebytes, err := c.Store.SchedGetTable(datastore.UploadSched)
-			if err != nil {
-				log.Errorf("Sched get: %s", err)
-			} else {
-				c.Bot.SendImageBytesBuf(filebytes)
-			}
+			a.sendSched(c, sched)
 		}
 		if cmd.Verb == "off" {
 			if cmd.Obj == "" {
-				c.Bot.SendMsg("usage: bot sched off <hour-day|day|hour,...>")
+				c.Bot.SendMsg("usage: bot sched [name] off <range,...> e.g. mon-fri 22:30-06:15, sat, mon-0")
 				return
 			}
 			for _, o := range strings.Split(cmd.Obj, ",") {
-				err := c.Store.SchedDeactivate(datastore.UploadSched, strings.TrimSpace(o))
+				err := c.Store.SchedDeactivate(sched, strings.TrimSpace(o))
 				if err != nil {
 					log.Errorf("Sched set: %s", err)
+					c.Bot.SendMsg(fmt.Sprintf("Sched error: %s", err))
 				}
 			}
-			filebytes, err := c.Store.SchedGetTable(datastore.UploadSched)
+			a.sendSched(c, sched)
+		}
+		if cmd.Verb == "except" {
+			c.Bot.SendMsg(schedExcept(c, sched, cmd.Obj))
+		}
+		if cmd.Verb == "sun" {
+			c.Bot.SendMsg(schedSun(c, sched, cmd.Obj, time.Now()))
+		}
+		scheds := []datastore.ScheduleName{}
+		if named {
+			scheds = append(scheds, sched)
+		}
+		if cmd.Verb == "export" {
+			c.Bot.SendMsg(schedExport(c, cmd.Obj, scheds))
+		}
+		if cmd.Verb == "import" {
+			c.Bot.SendMsg(schedImport(c, cmd.Obj))
+		}
+		if cmd.Verb == "copy" {
+			from := a.camByName(cmd.Obj)
+			if from == nil {
+				c.Bot.SendMsg("usage: bot sched [name] copy <camera>, the ID or name of the camera to copy from")
+				return
+			}
+			err := c.Store.SchedCopy(from.Store, scheds...)
 			if err != nil {
-				log.Errorf("Sched get: %s", err)
-			} else {
-				c.Bot.SendImageBytesBuf(filebytes)
+				c.Bot.SendMsg(fmt.Sprintf("Sched error: %s", err))
+				return
 			}
+			c.Bot.SendMsg(fmt.Sprintf("Copied the schedules of %s (%s)", from.ID, from.Name))
 		}
 	}
 	if cmd.Noun == "mode" {
+		sched, cmd := schedArgs(cmd)
 		if cmd.Verb == "get" {
-			currMode, err := c.Store.SchedGetMode(datastore.UploadSched)
-			if err != nil {
-				log.Errorf("Mode get: %s", err)
-			} else {
-				c.Bot.SendMsg(fmt.Sprintf("Mode set to '%s'", datastore.ModeStr(currMode)))
-			}
+			c.Bot.SendMsg(modeSummary(c, sched))
 		}
 		if cmd.Verb == "set" {
+			if len(strings.Fields(cmd.Obj)) > 1 {
+				o, err := datastore.ParseOverride(cmd.Obj, time.Now().In(c.Location()))
+				if err != nil {
+					c.Bot.SendMsg(fmt.Sprintf("Mode error: %s", err))
+					return
+				}
+				err = c.Store.SchedSetOverride(sched, o)
+				if err != nil {
+					log.Errorf("Mode set: %s", err)
+				} else {
+					c.Bot.SendMsg(modeSummary(c, sched))
+				}
+				return
+			}
 			mode := datastore.StrMode(cmd.Obj)
 			if mode == datastore.ModeInvalid {
-				c.Bot.SendMsg("usage: bot mode <on|off|sched>")
+				c.Bot.SendMsg("usage: bot mode [name] set <on|off|sched> [for <duration>|until <date> [HH:MM]]")
 				return
 			}
-			err := c.Store.SchedSetMode(datastore.UploadSched, mode)
+			err := c.Store.SchedSetMode(sched, mode)
 			if err != nil {
 				log.Errorf("Mode set: %s", err)
 			} else {
-				newMode, err := c.Store.SchedGetMode(datastore.UploadSched)
-				if err != nil {
-					log.Errorf("Mode get: %s", err)
-				} else {
-					c.Bot.SendMsg(fmt.Sprintf("Mode set to '%s'", datastore.ModeStr(newMode)))
-				}
+				c.Bot.SendMsg(modeSummary(c, sched))
+			}
+		}
+		if cmd.Verb == "clear" {
+			err := c.Store.SchedClearOverride(sched)
+			if err != nil {
+				log.Errorf("Mode clear: %s", err)
+			} else {
+				c.Bot.SendMsg(modeSummary(c, sched))
 			}
 		}
 	}
 }
+
+// schedArgs takes the schedule name off a sched or mode command, e.g.
+// bot sched alert on 22:00-07:00, and the detection schedule if there is none.
+func schedArgs(cmd jobs.Cmd) (datastore.ScheduleName, jobs.Cmd) {
+	sched, ok := datastore.StrScheduleName(cmd.Verb)
+	if !ok {
+		return datastore.UploadSched, cmd
+	}
+	verb, obj := utils.CutWord(cmd.Obj)
+	cmd.Verb = strings.ToLower(verb)
+	cmd.Obj = obj
+	return sched, cmd
+}
+
+// camByName returns the camera with the ID, or else the name, nil if there is none.
+func (a *App) camByName(s string) *camera.Cam {
+	if c := a.Cam(s); c != nil {
+		return c
+	}
+	for _, c := range a.Cams {
+		if strings.EqualFold(c.Name, s) {
+			return c
+		}
+	}
+	return nil
+}
+
+// schedExport formats the schedules, with their modes and overrides, as YAML, or JSON
+// if asked for.
+func schedExport(c *camera.Cam, format string, scheds []datastore.ScheduleName) string {
+	e, err := c.Store.SchedExport(scheds...)
+	if err != nil {
+		return fmt.Sprintf("Sched error: %s", err)
+	}
+	var data []byte
+	switch strings.ToLower(format) {
+	case "", "yaml":
+		data, err = e.YAML()
+	case "json":
+		data, err = e.JSON()
+	default:
+		return "usage: bot sched [name] export [yaml|json]"
+	}
+	if err != nil {
+		return fmt.Sprintf("Sched error: %s", err)
+	}
+	return utils.MarkdownCode(strings.TrimSuffix(string(data), "\n") + "\n")
+}
+
+// schedImport replaces the schedules in the pasted export, which may still be in the
+// code block it was sent in.
+func schedImport(c *camera.Cam, obj string) string {
+	obj = strings.TrimSpace(strings.Trim(strings.TrimSpace(obj), "`"))
+	if obj == "" {
+		return "usage: bot sched import <yaml or json>, as sent by bot sched export"
+	}
+	e, err := datastore.ParseExport([]byte(obj))
+	if err != nil {
+		return fmt.Sprintf("Sched error: %s", err)
+	}
+	err = c.Store.SchedImport(e)
+	if err != nil {
+		return fmt.Sprintf("Sched error: %s", err)
+	}
+	return fmt.Sprintf("Imported schedules: %s", strings.Join(e.Names(), ", "))
+}
+
+// modeSummary describes the schedule's mode, and the timed override on it if there is
+// one.
+func modeSummary(c *camera.Cam, sched datastore.ScheduleName) string {
+	mode, err := c.Store.SchedGetMode(sched)
+	if err != nil {
+		return fmt.Sprintf("Mode error: %s", err)
+	}
+	o, err := c.Store.SchedGetOverride(sched)
+	if err != nil {
+		return fmt.Sprintf("Mode error: %s", err)
+	}
+	if o != nil {
+		return fmt.Sprintf("Mode of '%s' set to '%s', overridden: %s", sched, datastore.ModeStr(mode), o)
+	}
+	return fmt.Sprintf("Mode of '%s' set to '%s'", sched, datastore.ModeStr(mode))
+}
+
+// schedExcept adds or deletes a date exception, and lists those that haven't ended.
+func schedExcept(c *camera.Cam, sched datastore.ScheduleName, obj string) string {
+	fields := strings.Fields(obj)
+	if len(fields) == 2 && fields[0] == "del" {
+		id, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return "usage: bot sched [name] except del <id>"
+		}
+		err = c.Store.SchedRemoveException(sched, id)
+		if err != nil {
+			return fmt.Sprintf("Sched error: %s", err)
+		}
+	} else if len(fields) > 0 {
+		e, err := datastore.ParseException(obj)
+		if err != nil {
+			return fmt.Sprintf("Sched error: %s", err)
+		}
+		_, err = c.Store.SchedAddException(sched, e)
+		if err != nil {
+			return fmt.Sprintf("Sched error: %s", err)
+		}
+	}
+	exceptions, err := c.Store.SchedExceptions(sched)
+	if err != nil {
+		return fmt.Sprintf("Sched error: %s", err)
+	}
+	if len(exceptions) == 0 {
+		return "No exceptions"
+	}
+	out := ""
+	for _, e := range exceptions {
+		out += e.String() + "\n"
+	}
+	return utils.MarkdownCode(out)
+}
+
+// archiveSummary lists the latest archived hits, optionally only those with the label.
+func archiveSummary(c *camera.Cam, label string) string {
+	if c.Archive == nil {
+		return "Archive is not enabled"
+	}
+	count, size, err := c.Archive.Usage()
+	if err != nil {
+		return fmt.Sprintf("Archive error: %s", err)
+	}
+	entries, err := c.Store.ArchiveList(datastore.ArchiveQuery{Label: label, Limit: 10})
+	if err != nil {
+		return fmt.Sprintf("Archive error: %s", err)
+	}
+	out := fmt.Sprintf("%d hits, %.1f MB in %s\n", count, float64(size)/1024/1024, c.Archive.Root)
+	if c.Archive.Quota != nil && c.Archive.Quota.MaxBytes > 0 {
+		total, err := c.Archive.Quota.Usage()
+		if err != nil {
+			return fmt.Sprintf("Archive error: %s", err)
+		}
+		out += fmt.Sprintf("%.1f of %.1f MB used by all cameras\n", float64(total)/1024/1024, float64(c.Archive.Quota.MaxBytes)/1024/1024)
+	}
+	for _, e := range entries {
+		out += fmt.Sprintf("%s %s (%d boxes) %s\n", e.Time.In(c.Location()).Format("Jan 2 15:04:05"), strings.Join(e.Labels, ", "), e.Boxes, e.Dir)
+	}
+	return utils.MarkdownCode(out)
+}
+
+// eventsSummary counts the camera's events per label and status, per day for the last
+// week or per hour for the last day.
+func eventsSummary(c *camera.Cam, period string, now time.Time) string {
+	q := datastore.EventQuery{From: now.AddDate(0, 0, -7)}
+	p, layout := datastore.Daily, "Mon Jan 2"
+	if period == "hour" {
+		q.From = now.Add(-24 * time.Hour)
+		p, layout = datastore.Hourly, "Mon 15:00"
+	}
+	counts, err := c.Store.EventCounts(q, p)
+	if err != nil {
+		return fmt.Sprintf("Events error: %s", err)
+	}
+	if len(counts) == 0 {
+		return "No events"
+	}
+	out := ""
+	for _, ec := range counts {
+		out += fmt.Sprintf("%s %s %s: %d\n", ec.Start.Format(layout), ec.Label, ec.Status, ec.Count)
+	}
+	return utils.MarkdownCode(out)
+}
+
+// sendSched sends the schedule's grid, and its ranges.
+func (a *App) sendSched(c *camera.Cam, sched datastore.ScheduleName) {
+	filebytes, err := c.Store.SchedGetTable(sched)
+	if err != nil {
+		log.Errorf("Sched get: %s", err)
+		return
+	}
+	c.Bot.SendImageBytesBuf(filebytes)
+	ranges, err := c.Store.SchedRanges(sched)
+	if err != nil {
+		log.Errorf("Sched get: %s", err)
+		return
+	}
+	if len(ranges) == 0 {
+		c.Bot.SendMsg(fmt.Sprintf("Schedule '%s' is empty, time zone: %s", sched, c.Location()))
+		return
+	}
+	out := fmt.Sprintf("Schedule '%s', time zone: %s\n", sched, c.Location())
+	for _, r := range ranges {
+		out += r.String() + "\n"
+	}
+	c.Bot.SendMsg(utils.MarkdownCode(out))
+}
+
+// schedSun adds or deletes a sun rule, and lists them with today's sun times.
+func schedSun(c *camera.Cam, sched datastore.ScheduleName, obj string, now time.Time) string {
+	fields := strings.Fields(obj)
+	if len(fields) == 2 && fields[0] == "del" {
+		id, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return "usage: bot sched [name] sun del <id>"
+		}
+		err = c.Store.SchedRemoveSunRule(sched, id)
+		if err != nil {
+			return fmt.Sprintf("Sched error: %s", err)
+		}
+	} else if len(fields) > 0 {
+		r, err := datastore.ParseSunRule(obj)
+		if err != nil {
+			return fmt.Sprintf("Sched error: %s", err)
+		}
+		_, err = c.Store.SchedAddSunRule(sched, r)
+		if err != nil {
+			return fmt.Sprintf("Sched error: %s", err)
+		}
+	}
+	day, ok := c.Store.SunTimes(now)
+	if !ok {
+		return "Sun rules need latitude and longitude in the config"
+	}
+	out := ""
+	switch {
+	case day.AlwaysUp:
+		out += "Today: the sun doesn't set\n"
+	case day.AlwaysDown:
+		out += "Today: the sun doesn't rise\n"
+	default:
+		out += fmt.Sprintf("Today: sunrise %s, sunset %s\n", day.Sunrise.Format("15:04 MST"), day.Sunset.Format("15:04 MST"))
+	}
+	rules, err := c.Store.SchedSunRules(sched)
+	if err != nil {
+		return fmt.Sprintf("Sched error: %s", err)
+	}
+	for _, r := range rules {
+		out += fmt.Sprintf("%d: %s\n", r.ID, r)
+	}
+	return utils.MarkdownCode(out)
+}
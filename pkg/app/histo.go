@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 
+	"github.com/marktheunissen/watchbot/pkg/camera"
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/vg"
@@ -44,7 +45,7 @@ func (h *HistVals) XYer() plotter.XYer {
 	return xys
 }
 
-func (a *App) SendHist(camIndex int, hist *HistVals) error {
+func (a *App) SendHist(cam *camera.Cam, hist *HistVals) error {
 	if hist.Len() <= 0 {
 		return errors.New("no data")
 	}
@@ -73,6 +74,6 @@ func (a *App) SendHist(camIndex int, hist *HistVals) error {
 	if n <= 0 {
 		return errors.New("zero bytes")
 	}
-	a.Cams[camIndex].Bot.SendImageBytesBuf(b)
+	cam.Bot.SendImageBytesBuf(b)
 	return nil
 }
@@ -44,10 +44,10 @@ func (a *App) handleMessage(ctx context.Context, msg messaging.Msg) {
 			for _, c := range a.Cams {
 				if c.PubSubControl {
 					c := jobs.Cmd{
-						CamIndex: c.Index,
-						Noun:     "mode",
-						Verb:     "set",
-						Obj:      "off",
+						CamID: c.ID,
+						Noun:  "mode",
+						Verb:  "set",
+						Obj:   "off",
 					}
 					a.handleIncomingCmd(c)
 				}
@@ -56,10 +56,10 @@ func (a *App) handleMessage(ctx context.Context, msg messaging.Msg) {
 			for _, c := range a.Cams {
 				if c.PubSubControl {
 					c := jobs.Cmd{
-						CamIndex: c.Index,
-						Noun:     "mode",
-						Verb:     "set",
-						Obj:      "on",
+						CamID: c.ID,
+						Noun:  "mode",
+						Verb:  "set",
+						Obj:   "on",
 					}
 					a.handleIncomingCmd(c)
 				}
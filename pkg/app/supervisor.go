@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/marktheunissen/watchbot/pkg/camera"
 	"github.com/marktheunissen/watchbot/pkg/datastore"
+	"github.com/marktheunissen/watchbot/pkg/detect"
+	"github.com/marktheunissen/watchbot/pkg/utils"
 )
 
 func (a *App) Supervisor(ctx context.Context) {
@@ -25,7 +28,8 @@ func (a *App) Supervisor(ctx context.Context) {
 }
 
 func (a *App) checkMainTicker() {
-	// Ensure the stream hasn't crashed, shutdown the app if it has.
+	// Ensure the main loop hasn't hung, shutdown the app if it has. The ticker keeps
+	// running while cameras reconnect, so a dropped stream doesn't trigger this.
 	ss := stats.mainTicker.Snapshot()
 	if ss.Count() > 600 && ss.Rate1() < 2 {
 		log.Infof("1 min: %.2f", ss.Rate1())
@@ -37,9 +41,49 @@ func (a *App) checkMainTicker() {
 	}
 }
 
+// ConnectionWatcher tells each camera's chat when its stream drops, comes back, or
+// runs out of reconnect attempts. The retries in between are only logged.
+func (a *App) ConnectionWatcher(ctx context.Context) {
+	for {
+		select {
+		case ev := <-a.Detector.Events:
+			a.handleConnEvent(ev)
+
+		case <-ctx.Done():
+			log.Info("ConnectionWatcher stopping")
+			return
+		}
+	}
+}
+
+func (a *App) handleConnEvent(ev detect.ConnEvent) {
+	cam := a.Cam(ev.CamID)
+	if cam == nil {
+		return
+	}
+	camStats := stats.cams[cam.ID]
+	log.Infof("camera %s (%s) connection %s, attempt: %d, delay: %v, err: %v", cam.ID, cam.Name, ev.State, ev.Attempt, ev.Delay, ev.Err)
+	switch ev.State {
+	case detect.ConnBackoff:
+		camStats.captureReconnect.Inc(1)
+		if ev.Attempt == 1 {
+			cam.Bot.SendMsg(fmt.Sprintf("Camera connection lost: %s, reconnecting in %v", ev.Err, utils.Round(ev.Delay, time.Second)))
+		}
+	case detect.ConnStreaming:
+		if ev.Attempt > 0 {
+			cam.Bot.SendMsg(fmt.Sprintf("Camera reconnected after %d attempts", ev.Attempt+1))
+		}
+	case detect.ConnFailed:
+		camStats.captureFailed.Inc(1)
+		cam.Bot.SendMsg(fmt.Sprintf("Camera connection failed after %d attempts: %s, use `bot reconnect` to retry", ev.Attempt, ev.Err))
+	}
+}
+
 func (a *App) checkModeSwitch() {
 	// Emit a message if the detector is switching mode, set the control bit
-	// on the camera object.
+	// on the camera object. The schedule covers the weekly ranges, sun rules,
+	// exceptions and timed overrides. The alerting, recording and quiet hours
+	// schedules only change what's done with the detections.
 	stateChanged := false
 	for _, cam := range a.Cams {
 		active, err := cam.Store.SchedActiveNow(datastore.UploadSched)
@@ -56,6 +100,7 @@ func (a *App) checkModeSwitch() {
 			}
 			stateChanged = true
 		}
+		a.checkSchedSwitch(cam)
 	}
 	if stateChanged {
 		log.Info("A camera has changed status, toggling the capture feed")
@@ -65,3 +110,32 @@ func (a *App) checkModeSwitch() {
 		}
 	}
 }
+
+// checkSchedSwitch updates the camera's other schedules, and says when they change
+// after the first check.
+func (a *App) checkSchedSwitch(cam *camera.Cam) {
+	for _, sched := range datastore.Schedules {
+		if sched == datastore.UploadSched {
+			continue
+		}
+		active, err := cam.Store.SchedActiveNow(sched)
+		if err != nil {
+			log.Errorf("SchedActiveNow error: %s", err)
+			return
+		}
+		was, checked := cam.SchedState(sched)
+		if !checked || active != was {
+			cam.SetSchedActive(sched, active)
+		}
+		if checked && active != was {
+			cam.Bot.SendMsg(fmt.Sprintf("Schedule '%s' changed state: %s", sched, onOff(active)))
+		}
+	}
+}
+
+func onOff(active bool) string {
+	if active {
+		return "ON"
+	}
+	return "OFF"
+}
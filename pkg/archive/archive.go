@@ -0,0 +1,304 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/marktheunissen/watchbot/pkg/datastore"
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	"github.com/marktheunissen/watchbot/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.WithField("component", "archive")
+
+// Store indexes the archive, so that it can be queried and pruned without walking it.
+type Store interface {
+	ArchiveAdd(e datastore.ArchiveEntry) (int64, error)
+	ArchiveOldest(n int) ([]datastore.ArchiveEntry, error)
+	ArchiveSize() (int, int64, error)
+	ArchiveRemove(id int64) error
+}
+
+type Config struct {
+	// Directory the hits are written under, in a dated tree.
+	Root string
+
+	// Hits older than MaxAge are deleted, 0 is unlimited.
+	MaxAge time.Duration
+
+	// The size limit, shared with the other archives in the quota. nil is unlimited.
+	Quota *Quota
+}
+
+// Hit is one frame with hits to archive.
+type Hit struct {
+	CamID     string
+	CamName   string
+	Time      time.Time
+	JPEGBytes []byte
+	Boxes     []*frame.Box
+}
+
+// Meta is written next to the images of each hit as meta.json.
+type Meta struct {
+	Camera   string    `json:"camera"`
+	Name     string    `json:"name"`
+	Time     time.Time `json:"time"`
+	Overview string    `json:"overview"`
+	Boxes    []MetaBox `json:"boxes"`
+}
+
+type MetaBox struct {
+	Label      string   `json:"label"`
+	Confidence int      `json:"confidence"`
+	X1         int      `json:"x1"`
+	Y1         int      `json:"y1"`
+	X2         int      `json:"x2"`
+	Y2         int      `json:"y2"`
+	Track      int      `json:"track"`
+	Zones      []string `json:"zones,omitempty"`
+	File       string   `json:"file"`
+}
+
+// Archive writes hits to disk, e.g. <root>/2018/09/05/221503.250/, and keeps them
+// within the retention limits.
+type Archive struct {
+	Config
+	store Store
+	lock  sync.Mutex
+}
+
+func New(config Config, store Store) (*Archive, error) {
+	if config.Root == "" {
+		return nil, errors.New("Archive root is required")
+	}
+	config.Root = filepath.Clean(config.Root)
+	err := os.MkdirAll(config.Root, 0755)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{Config: config, store: store}
+	if config.Quota != nil {
+		config.Quota.add(a)
+	}
+	return a, nil
+}
+
+// Write stores the overview, a crop of each box and the metadata, and indexes them.
+func (a *Archive) Write(hit *Hit) (datastore.ArchiveEntry, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	rel := hit.Time.Format("2006/01/02/150405.000")
+	for i := 1; ; i++ {
+		_, err := os.Stat(filepath.Join(a.Root, rel))
+		if os.IsNotExist(err) {
+			break
+		}
+		rel = fmt.Sprintf("%s-%d", hit.Time.Format("2006/01/02/150405.000"), i)
+	}
+	dir := filepath.Join(a.Root, rel)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return datastore.ArchiveEntry{}, err
+	}
+
+	entry := datastore.ArchiveEntry{
+		Time:   hit.Time,
+		Dir:    rel,
+		Labels: []string{},
+		Boxes:  len(hit.Boxes),
+	}
+	write := func(name string, data []byte) error {
+		entry.Bytes += int64(len(data))
+		return ioutil.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+	meta := Meta{
+		Camera:   hit.CamID,
+		Name:     hit.CamName,
+		Time:     hit.Time,
+		Overview: "overview.jpg",
+		Boxes:    []MetaBox{},
+	}
+	err = write(meta.Overview, hit.JPEGBytes)
+	if err != nil {
+		return entry, err
+	}
+	for i, box := range hit.Boxes {
+		mb := MetaBox{
+			Label:      box.Label,
+			Confidence: box.Confidence,
+			X1:         box.Coords.Min.X,
+			Y1:         box.Coords.Min.Y,
+			X2:         box.Coords.Max.X,
+			Y2:         box.Coords.Max.Y,
+			Track:      box.TrackID,
+			Zones:      box.Zones,
+			File:       fmt.Sprintf("box-%d-%s.jpg", i, box.Label),
+		}
+		err = write(mb.File, box.JPEGBytes)
+		if err != nil {
+			return entry, err
+		}
+		meta.Boxes = append(meta.Boxes, mb)
+		if !utils.StringInSlice(box.Label, entry.Labels) {
+			entry.Labels = append(entry.Labels, box.Label)
+		}
+	}
+	metaBytes, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return entry, err
+	}
+	err = write("meta.json", metaBytes)
+	if err != nil {
+		return entry, err
+	}
+	entry.ID, err = a.store.ArchiveAdd(entry)
+	return entry, err
+}
+
+// Prune deletes the hits that are past the max age, then the oldest hits of all the
+// archives in the quota while they're over it, and returns how many it deleted.
+func (a *Archive) Prune(now time.Time) (int, error) {
+	pruned, err := a.pruneAge(now)
+	if err != nil || a.Quota == nil {
+		return pruned, err
+	}
+	n, err := a.Quota.prune()
+	return pruned + n, err
+}
+
+func (a *Archive) pruneAge(now time.Time) (int, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.MaxAge == 0 {
+		return 0, nil
+	}
+	pruned := 0
+	for {
+		oldest, err := a.store.ArchiveOldest(100)
+		if err != nil {
+			return pruned, err
+		}
+		if len(oldest) == 0 {
+			return pruned, nil
+		}
+		for _, e := range oldest {
+			if now.Sub(e.Time) <= a.MaxAge {
+				return pruned, nil
+			}
+			err = a.remove(e)
+			if err != nil {
+				return pruned, err
+			}
+			pruned++
+		}
+	}
+}
+
+// remove deletes the hit's directory, and the dated directories above it once empty.
+func (a *Archive) remove(e datastore.ArchiveEntry) error {
+	dir := filepath.Join(a.Root, e.Dir)
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+	for parent := filepath.Dir(dir); parent != a.Root && parent != "."; parent = filepath.Dir(parent) {
+		if os.Remove(parent) != nil {
+			break
+		}
+	}
+	log.Debugf("pruned %s", dir)
+	return a.store.ArchiveRemove(e.ID)
+}
+
+// Usage returns the number of hits archived and their total bytes.
+func (a *Archive) Usage() (int, int64, error) {
+	return a.store.ArchiveSize()
+}
+
+// Quota limits the total size of several archives, e.g. those of all the cameras
+// sharing a disk. Once they're larger than MaxBytes together, the oldest hits across
+// all of them are deleted, whichever camera they're from.
+type Quota struct {
+	// 0 is unlimited.
+	MaxBytes int64
+
+	lock     sync.Mutex
+	archives []*Archive
+}
+
+func NewQuota(maxBytes int64) *Quota {
+	return &Quota{MaxBytes: maxBytes}
+}
+
+func (q *Quota) add(a *Archive) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.archives = append(q.archives, a)
+}
+
+// Usage returns the total bytes of all the archives in the quota.
+func (q *Quota) Usage() (int64, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.size()
+}
+
+func (q *Quota) size() (int64, error) {
+	total := int64(0)
+	for _, a := range q.archives {
+		_, size, err := a.store.ArchiveSize()
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// prune deletes the oldest hit of any of the archives, until they're within MaxBytes.
+func (q *Quota) prune() (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.MaxBytes == 0 {
+		return 0, nil
+	}
+	total, err := q.size()
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for total > q.MaxBytes {
+		var from *Archive
+		var oldest datastore.ArchiveEntry
+		for _, a := range q.archives {
+			e, err := a.store.ArchiveOldest(1)
+			if err != nil {
+				return pruned, err
+			}
+			if len(e) > 0 && (from == nil || e[0].Time.Before(oldest.Time)) {
+				from, oldest = a, e[0]
+			}
+		}
+		if from == nil {
+			return pruned, nil
+		}
+		from.lock.Lock()
+		err = from.remove(oldest)
+		from.lock.Unlock()
+		if err != nil {
+			return pruned, err
+		}
+		total -= oldest.Bytes
+		pruned++
+	}
+	return pruned, nil
+}
@@ -0,0 +1,145 @@
+package archive
+
+import (
+	"encoding/json"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marktheunissen/watchbot/pkg/datastore"
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	h "github.com/marktheunissen/watchbot/pkg/test/helpers"
+)
+
+func testArchive(t *testing.T, config Config) (*Archive, *datastore.Store, func()) {
+	root, err := ioutil.TempDir("", "test-archive")
+	h.FatalIfErr(t, err)
+	store, err := datastore.New(datastore.Config{Filename: filepath.Join(root, "test.db")})
+	h.FatalIfErr(t, err)
+	config.Root = filepath.Join(root, "cam")
+	a, err := New(config, store)
+	h.FatalIfErr(t, err)
+	cleanup := func() {
+		store.Close()
+		os.RemoveAll(root)
+	}
+	return a, store, cleanup
+}
+
+func testHit(at time.Time) *Hit {
+	return &Hit{
+		CamID:     "driveway",
+		Time:      at,
+		JPEGBytes: make([]byte, 100),
+		Boxes: []*frame.Box{
+			{Label: "person", Confidence: 80, Coords: image.Rect(1, 2, 3, 4), JPEGBytes: make([]byte, 50)},
+		},
+	}
+}
+
+func TestWrite(t *testing.T) {
+	a, store, cleanup := testArchive(t, Config{})
+	defer cleanup()
+
+	at := time.Date(2018, 9, 5, 22, 15, 3, 250000000, time.Local)
+	e, err := a.Write(testHit(at))
+	h.FatalIfErr(t, err)
+	if e.Dir != "2018/09/05/221503.250" || e.Bytes < 150 {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(a.Root, e.Dir, "meta.json"))
+	h.FatalIfErr(t, err)
+	meta := Meta{}
+	h.FatalIfErr(t, json.Unmarshal(data, &meta))
+	if meta.Camera != "driveway" || len(meta.Boxes) != 1 || meta.Boxes[0].X2 != 3 || meta.Boxes[0].File != "box-0-person.jpg" {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+	_, err = os.Stat(filepath.Join(a.Root, e.Dir, "box-0-person.jpg"))
+	h.FatalIfErr(t, err)
+
+	// Same time again goes in its own directory.
+	e2, err := a.Write(testHit(at))
+	h.FatalIfErr(t, err)
+	if e2.Dir != "2018/09/05/221503.250-1" {
+		t.Fatalf("want a second directory, got: %s", e2.Dir)
+	}
+	list, err := store.ArchiveList(datastore.ArchiveQuery{Label: "person"})
+	h.FatalIfErr(t, err)
+	if len(list) != 2 {
+		t.Fatalf("want 2 indexed hits, got: %+v", list)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	a, store, cleanup := testArchive(t, Config{MaxAge: 48 * time.Hour})
+	defer cleanup()
+
+	now := time.Date(2018, 9, 10, 12, 0, 0, 0, time.Local)
+	for days := 4; days >= 0; days-- {
+		_, err := a.Write(testHit(now.Add(-time.Duration(days) * 24 * time.Hour)))
+		h.FatalIfErr(t, err)
+	}
+	n, err := a.Prune(now)
+	h.FatalIfErr(t, err)
+	if n != 2 {
+		t.Fatalf("want the 2 hits past the max age pruned, got: %d", n)
+	}
+	if _, err := os.Stat(filepath.Join(a.Root, "2018/09/06")); !os.IsNotExist(err) {
+		t.Fatal("want the empty day directory removed")
+	}
+
+	// Keep only the newest by size.
+	count, size, err := store.ArchiveSize()
+	h.FatalIfErr(t, err)
+	a.Quota = NewQuota(size / int64(count))
+	a.Quota.add(a)
+	n, err = a.Prune(now)
+	h.FatalIfErr(t, err)
+	list, err := store.ArchiveList(datastore.ArchiveQuery{})
+	h.FatalIfErr(t, err)
+	if n != 2 || len(list) != 1 || !list[0].Time.Equal(now) {
+		t.Fatalf("want only the newest hit kept, pruned %d, got: %+v", n, list)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	q := NewQuota(0)
+	a, _, cleanup := testArchive(t, Config{Quota: q})
+	defer cleanup()
+	b, _, cleanupB := testArchive(t, Config{Quota: q})
+	defer cleanupB()
+
+	// Alternate the hits between the cameras, a's are the older of each pair.
+	now := time.Date(2018, 9, 10, 12, 0, 0, 0, time.Local)
+	for hours := 6; hours > 0; hours-- {
+		_, err := a.Write(testHit(now.Add(-time.Duration(2*hours) * time.Hour)))
+		h.FatalIfErr(t, err)
+		_, err = b.Write(testHit(now.Add(-time.Duration(2*hours-1) * time.Hour)))
+		h.FatalIfErr(t, err)
+	}
+	total, err := q.Usage()
+	h.FatalIfErr(t, err)
+
+	// The size is shared, pruning from either camera keeps the newest 4 of all of them.
+	q.MaxBytes = total / 3
+	n, err := b.Prune(now)
+	h.FatalIfErr(t, err)
+	if n != 8 {
+		t.Fatalf("want the oldest 8 hits pruned, got: %d", n)
+	}
+	for _, arch := range []*Archive{a, b} {
+		count, _, err := arch.Usage()
+		h.FatalIfErr(t, err)
+		if count != 2 {
+			t.Fatalf("want 2 hits kept in %s, got: %d", arch.Root, count)
+		}
+	}
+	left, err := a.store.ArchiveOldest(1)
+	h.FatalIfErr(t, err)
+	if !left[0].Time.Equal(now.Add(-4 * time.Hour)) {
+		t.Fatalf("want a's hits from 4 hours ago kept, got: %v", left[0].Time)
+	}
+}
@@ -0,0 +1,241 @@
+package burst
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"strings"
+	"time"
+
+	"github.com/marktheunissen/watchbot/pkg/clip"
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	"github.com/marktheunissen/watchbot/pkg/utils"
+)
+
+const (
+	// ModeImages sends an overview and an image per box for each hit.
+	ModeImages = "images"
+
+	// ModeGIF and ModeMP4 collect the annotated frames of an event, and send them as
+	// a single animation once it goes quiet.
+	ModeGIF = "gif"
+	ModeMP4 = "mp4"
+)
+
+type Config struct {
+	Mode string
+
+	// The event ends once there has been no hit for this long.
+	Quiet time.Duration
+
+	// Long events are thinned out evenly to at most this many frames.
+	MaxFrames int
+
+	// Events are sent at this length, even if the hits continue.
+	MaxLength time.Duration
+
+	// GIF frames are scaled down to this width.
+	MaxWidth int
+
+	// FourCC of the MP4 codec.
+	Codec string
+}
+
+// Burst collects the annotated frames of one event, from the first hit until the
+// camera has been quiet for a while.
+type Burst struct {
+	Config
+	frames []Frame
+	labels []string
+	hits   []Hit
+	seen   int
+	stride int
+	last   time.Time
+}
+
+// Frame is one annotated JPEG of the event.
+type Frame struct {
+	JPEG []byte
+	Time time.Time
+}
+
+// Hit is a box that alerts with the event rather than on its own.
+type Hit struct {
+	Box  *frame.Box
+	Time time.Time
+}
+
+func New(config Config) (*Burst, error) {
+	if config.Mode == "" {
+		config.Mode = ModeImages
+	}
+	if !utils.StringInSlice(config.Mode, []string{ModeImages, ModeGIF, ModeMP4}) {
+		return nil, fmt.Errorf("unknown alert mode: %s, must be one of: %s, %s, %s", config.Mode, ModeImages, ModeGIF, ModeMP4)
+	}
+	if config.Quiet == 0 {
+		config.Quiet = 5 * time.Second
+	}
+	if config.MaxFrames == 0 {
+		config.MaxFrames = 30
+	}
+	if config.MaxLength == 0 {
+		config.MaxLength = 2 * time.Minute
+	}
+	if config.MaxWidth == 0 {
+		config.MaxWidth = 480
+	}
+	if config.Codec == "" {
+		config.Codec = "avc1"
+	}
+	return &Burst{Config: config, stride: 1}, nil
+}
+
+// Enabled returns true if hits should be collected rather than sent as images.
+func (b *Burst) Enabled() bool {
+	return b.Mode != ModeImages
+}
+
+// Active returns true while an event is being collected.
+func (b *Burst) Active() bool {
+	return b.seen > 0
+}
+
+// Add collects a frame of the event, starting it if there is none.
+func (b *Burst) Add(jpegBytes []byte, labels []string, t time.Time) {
+	b.last = t
+	for _, l := range labels {
+		if !utils.StringInSlice(l, b.labels) {
+			b.labels = append(b.labels, l)
+		}
+	}
+	b.seen++
+	if (b.seen-1)%b.stride != 0 {
+		return
+	}
+	b.frames = append(b.frames, Frame{JPEG: jpegBytes, Time: t})
+	if len(b.frames) > b.MaxFrames {
+		// Keep every other frame, and only every other one of those to come.
+		kept := b.frames[:0]
+		for i := 0; i < len(b.frames); i += 2 {
+			kept = append(kept, b.frames[i])
+		}
+		b.frames = kept
+		b.stride *= 2
+	}
+}
+
+// Hit adds a box to the ones the event alerts for, so that what happened to the event
+// can be recorded for each of them.
+func (b *Burst) Hit(box *frame.Box, t time.Time) {
+	b.hits = append(b.hits, Hit{Box: box, Time: t})
+}
+
+// Due returns the event once it has gone quiet or reached the max length, and starts
+// collecting a new one.
+func (b *Burst) Due(now time.Time) *Event {
+	if !b.Active() {
+		return nil
+	}
+	if now.Sub(b.last) < b.Quiet && now.Sub(b.frames[0].Time) < b.MaxLength {
+		return nil
+	}
+	e := &Event{
+		Frames: b.frames,
+		Labels: b.labels,
+		Hits:   b.hits,
+		End:    b.last,
+	}
+	b.frames = nil
+	b.labels = nil
+	b.hits = nil
+	b.seen = 0
+	b.stride = 1
+	return e
+}
+
+// Event is the frames of one event, spread evenly from its first to last hit.
+type Event struct {
+	Frames []Frame
+	Labels []string
+	Hits   []Hit
+	End    time.Time
+}
+
+func (e *Event) Duration() time.Duration {
+	return e.End.Sub(e.Frames[0].Time)
+}
+
+func (e *Event) Caption() string {
+	labels := []string{}
+	for _, l := range e.Labels {
+		labels = append(labels, strings.Title(l))
+	}
+	return fmt.Sprintf("%s: %v", strings.Join(labels, ", "), utils.Round(e.Duration(), time.Second))
+}
+
+// GIF scales the frames down to maxWidth, and shows each until the next was taken.
+func (e *Event) GIF(maxWidth int) ([]byte, error) {
+	g := &gif.GIF{}
+	for i, f := range e.Frames {
+		img, err := jpeg.Decode(bytes.NewReader(f.JPEG))
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %s", i, err)
+		}
+		g.Image = append(g.Image, paletted(img, maxWidth))
+
+		// Delay is in 100ths of a second, pause on the last frame before it loops.
+		delay := 200
+		if i+1 < len(e.Frames) {
+			delay = int(e.Frames[i+1].Time.Sub(f.Time) / (10 * time.Millisecond))
+			if delay < 10 {
+				delay = 10
+			}
+			if delay > 100 {
+				delay = 100
+			}
+		}
+		g.Delay = append(g.Delay, delay)
+	}
+	b := &bytes.Buffer{}
+	err := gif.EncodeAll(b, g)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// paletted scales the image to at most maxWidth, nearest neighbour, onto the web safe
+// palette, whose index can be worked out directly rather than searched for.
+func paletted(img image.Image, maxWidth int) *image.Paletted {
+	src := img.Bounds()
+	w, h := src.Dx(), src.Dy()
+	if w > maxWidth {
+		h = h * maxWidth / w
+		w = maxWidth
+	}
+	dst := image.NewPaletted(image.Rect(0, 0, w, h), palette.WebSafe)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, _ := img.At(src.Min.X+x*src.Dx()/w, src.Min.Y+y*src.Dy()/h).RGBA()
+			dst.Pix[y*dst.Stride+x] = uint8(webSafe(r)*36 + webSafe(g)*6 + webSafe(b))
+		}
+	}
+	return dst
+}
+
+// webSafe returns the nearest of the 6 levels of a 16 bit colour channel.
+func webSafe(v uint32) uint32 {
+	return (v>>8 + 25) / 51
+}
+
+// MP4 encodes the frames as a video, played back in real time.
+func (e *Event) MP4(codec string) ([]byte, error) {
+	c := &clip.Clip{Codec: codec}
+	for _, f := range e.Frames {
+		c.Frames = append(c.Frames, clip.Frame{JPEG: f.JPEG, Time: f.Time})
+	}
+	return c.MP4()
+}
@@ -0,0 +1,108 @@
+package burst
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	h "github.com/marktheunissen/watchbot/pkg/test/helpers"
+)
+
+func testJPEG(t *testing.T, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	b := &bytes.Buffer{}
+	h.FatalIfErr(t, jpeg.Encode(b, img, nil))
+	return b.Bytes()
+}
+
+func TestBurst(t *testing.T) {
+	b, err := New(Config{Mode: ModeGIF, Quiet: 5 * time.Second, MaxFrames: 4})
+	h.FatalIfErr(t, err)
+	start := time.Now()
+	at := func(secs int) time.Time { return start.Add(time.Duration(secs) * time.Second) }
+
+	if b.Due(at(0)) != nil {
+		t.Fatal("want no event before a hit")
+	}
+	for i := 0; i < 10; i++ {
+		label := "person"
+		if i == 5 {
+			label = "car"
+		}
+		b.Add([]byte{byte(i)}, []string{label}, at(i))
+		if i == 0 || i == 5 {
+			b.Hit(&frame.Box{Label: label, TrackID: i}, at(i))
+		}
+	}
+	if b.Due(at(12)) != nil {
+		t.Fatal("want no event before it's quiet")
+	}
+	e := b.Due(at(14))
+	if e == nil {
+		t.Fatal("want the event once quiet")
+	}
+	// 10 frames thinned to every 4th.
+	got := []byte{}
+	for _, f := range e.Frames {
+		got = append(got, f.JPEG[0])
+	}
+	if !bytes.Equal(got, []byte{0, 4, 8}) {
+		t.Fatalf("want frames 0, 4, 8, got: %v", got)
+	}
+	if e.Caption() != "Person, Car: 9s" {
+		t.Fatalf("unexpected caption: %s", e.Caption())
+	}
+	if len(e.Hits) != 2 || e.Hits[1].Box.Label != "car" || e.Hits[1].Time != at(5) {
+		t.Fatalf("want the person and car hits, got: %v", e.Hits)
+	}
+	if b.Active() || b.Due(at(30)) != nil {
+		t.Fatal("want the burst reset after the event")
+	}
+}
+
+func TestBurstMode(t *testing.T) {
+	b, err := New(Config{})
+	h.FatalIfErr(t, err)
+	if b.Enabled() {
+		t.Fatal("want images by default")
+	}
+	_, err = New(Config{Mode: "avi"})
+	if err == nil {
+		t.Fatal("want error for unknown mode")
+	}
+}
+
+func TestGIF(t *testing.T) {
+	start := time.Now()
+	e := &Event{
+		Frames: []Frame{
+			{JPEG: testJPEG(t, color.RGBA{255, 0, 0, 255}), Time: start},
+			{JPEG: testJPEG(t, color.RGBA{0, 0, 255, 255}), Time: start.Add(500 * time.Millisecond)},
+		},
+		End: start.Add(500 * time.Millisecond),
+	}
+	data, err := e.GIF(32)
+	h.FatalIfErr(t, err)
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	h.FatalIfErr(t, err)
+	if len(g.Image) != 2 || g.Delay[0] != 50 {
+		t.Fatalf("want 2 frames with 50 delay, got: %d, %v", len(g.Image), g.Delay)
+	}
+	if g.Image[0].Bounds() != image.Rect(0, 0, 32, 16) {
+		t.Fatalf("want frames scaled to 32x16, got: %v", g.Image[0].Bounds())
+	}
+	r, _, b, _ := g.Image[0].At(10, 10).RGBA()
+	if r>>8 < 200 || b>>8 > 50 {
+		t.Fatalf("want red first frame, got: %v", g.Image[0].At(10, 10))
+	}
+}
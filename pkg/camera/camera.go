@@ -1,6 +1,7 @@
 package camera
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"strings"
@@ -8,10 +9,17 @@ import (
 	"time"
 
 	"github.com/juju/ratelimit"
+	"github.com/marktheunissen/watchbot/pkg/archive"
+	"github.com/marktheunissen/watchbot/pkg/burst"
+	"github.com/marktheunissen/watchbot/pkg/clip"
 	"github.com/marktheunissen/watchbot/pkg/datastore"
+	"github.com/marktheunissen/watchbot/pkg/frame"
 	"github.com/marktheunissen/watchbot/pkg/jobs"
+	"github.com/marktheunissen/watchbot/pkg/stationary"
 	"github.com/marktheunissen/watchbot/pkg/telegram"
+	"github.com/marktheunissen/watchbot/pkg/tracker"
 	"github.com/marktheunissen/watchbot/pkg/utils"
+	"github.com/marktheunissen/watchbot/pkg/zone"
 	"github.com/sirupsen/logrus"
 )
 
@@ -19,7 +27,7 @@ var log = logrus.WithField("component", "camera")
 
 type Config struct {
 	Name            string
-	Index           int
+	ID              string
 	Bot             *telegram.Bot
 	Store           *datastore.Store
 	VideoCaptureURI string
@@ -30,6 +38,8 @@ type Config struct {
 	MinHeight       int
 	RequirePortrait bool
 	MinConfidence   int
+	AlertLabels     []string
+	LabelLimits     map[string]frame.LabelLimits
 	SendRejected    bool
 	CropX           int
 	CropY           int
@@ -39,17 +49,76 @@ type Config struct {
 	ROIY            int
 	ROIWidth        int
 	ROIHeight       int
+
+	// Polygon zones and exclusion masks, and lines that raise an event when a tracked
+	// object crosses them, relative to the crop region like the ROI.
+	Zones     []*zone.Zone
+	Tripwires []*zone.Tripwire
+
+	// Objects in a zone with a dwell rule for their label only alert once they have
+	// stayed there for the rule's minimum dwell time.
+	DwellRules []*zone.DwellRule
+
+	// Overlapping boxes with the same label are suppressed above the IoU threshold,
+	// which can be set per label. Merging grows the kept box over the suppressed ones.
+	NMSThreshold       float64
+	NMSLabelThresholds map[string]float64
+	MergeOverlaps      bool
+
+	// Tracks live on for TrackMaxAge without being seen, boxes match tracks they
+	// overlap by TrackMinIoU.
+	TrackMaxAge time.Duration
+	TrackMinIoU float64
+
+	// Inference only runs when at least MotionMinArea percent of the ROI changed,
+	// 0 disables the motion prefilter. MotionAlert sends motion without detections
+	// as its own alert, at most once per MotionAlertInterval.
+	MotionMinArea       float64
+	MotionThreshold     int
+	MotionAlert         bool
+	MotionAlertInterval time.Duration
+
+	// Boxes that stay within StationaryMinIoU of the same place for StationaryAfter
+	// stop alerting, until they move or aren't seen for StationaryForget.
+	StationaryAfter  time.Duration
+	StationaryMinIoU float64
+	StationaryForget time.Duration
+
+	// Hits record a video clip from ClipPreRoll before to ClipPostRoll after, up to
+	// ClipMaxLength long, or ClipMaxBytes of frames scaled down to ClipMaxWidth. 0 for
+	// both rolls disables clips.
+	ClipPreRoll   time.Duration
+	ClipPostRoll  time.Duration
+	ClipMaxLength time.Duration
+	ClipMaxBytes  int64
+	ClipMaxWidth  int
+	ClipCodec     string
+
+	// AlertMode images sends an overview and each box, gif or mp4 collect the frames of
+	// an event until it has been quiet for BurstQuiet, and send them as one animation.
+	AlertMode      string
+	BurstQuiet     time.Duration
+	BurstMaxFrames int
+	BurstMaxLength time.Duration
+	BurstMaxWidth  int
+
+	// Every hit is written under ArchiveDir, "" disables the archive. The quota is
+	// shared by all the cameras.
+	ArchiveDir    string
+	ArchiveMaxAge time.Duration
+	ArchiveQuota  *archive.Quota
 }
 
 type Cam struct {
 	Name             string
-	Index            int
+	ID               string
 	Bot              *telegram.Bot
 	Store            *datastore.Store
 	FrameLimit       *ratelimit.Bucket
 	BurstLimit       *ratelimit.Bucket
 	PaceLimit        *ratelimit.Bucket
 	LastOverviewSent time.Time
+	LastMotionSent   time.Time
 	SnapshotChan     chan jobs.Cmd
 	FrameChan        chan jobs.Cmd
 	PubSubControl    bool
@@ -59,13 +128,30 @@ type Cam struct {
 	MinHeight        int
 	RequirePortrait  bool
 	MinConfidence    int
+	AlertLabels      []string
+	LabelLimits      map[string]frame.LabelLimits
 	CropRect         *image.Rectangle
 	ROIRect          *image.Rectangle
+	Zones            []*zone.Zone
+	Tripwires        []*zone.Tripwire
+	Dwell            *zone.Dwell
+	Stationary       *stationary.Filter
+	Clip             *clip.Buffer
+	Burst            *burst.Burst
+	Archive          *archive.Archive
 	SendRejected     bool
 	VideoCaptureURI  string
+	NMS              frame.NMSConfig
+	Tracker          *tracker.Tracker
+
+	MotionMinArea       float64
+	MotionThreshold     int
+	MotionAlert         bool
+	MotionAlertInterval time.Duration
 
-	// Whether or not this camera should be active according to the schedule
-	active     bool
+	// Whether each of the camera's schedules is on, as of the last check. Detection is
+	// whether the camera should be active at all.
+	active     map[datastore.ScheduleName]bool
 	activeLock sync.Mutex
 }
 
@@ -77,12 +163,81 @@ func New(config Config) (*Cam, error) {
 			return nil, fmt.Errorf("ROI: %v must be smaller and relative to the crop image: %v", roiRect, cropRect)
 		}
 	}
+	if config.ID == "" {
+		return nil, errors.New("Camera ID is required")
+	}
+	if config.Name == "" {
+		config.Name = config.ID
+	}
 	if config.MinConfidence == 0 {
 		config.MinConfidence = 15
 	}
+	if config.MotionAlertInterval == 0 {
+		config.MotionAlertInterval = time.Minute
+	}
+	if len(config.AlertLabels) == 0 {
+		log.Warnf("no alert labels given to %s, will not alert on anything", config.Name)
+	}
+	for label := range config.LabelLimits {
+		if !utils.StringInSlice(label, config.AlertLabels) {
+			log.Warnf("%s has limits for label: %s, which is not an alert label", config.Name, label)
+		}
+	}
+	for _, rule := range config.DwellRules {
+		found := false
+		for _, z := range config.Zones {
+			if z.Name == rule.Zone && !z.Exclude {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("dwell rule for unknown zone: %s", rule.Zone)
+		}
+	}
+	stationaryFilter, err := stationary.New(stationary.Config{
+		After:  config.StationaryAfter,
+		MinIoU: config.StationaryMinIoU,
+		Forget: config.StationaryForget,
+	}, config.Store)
+	if err != nil {
+		return nil, err
+	}
+	burstAlerts, err := burst.New(burst.Config{
+		Mode:      config.AlertMode,
+		Quiet:     config.BurstQuiet,
+		MaxFrames: config.BurstMaxFrames,
+		MaxLength: config.BurstMaxLength,
+		MaxWidth:  config.BurstMaxWidth,
+		Codec:     config.ClipCodec,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var hitArchive *archive.Archive
+	if config.ArchiveDir != "" {
+		hitArchive, err = archive.New(archive.Config{
+			Root:   config.ArchiveDir,
+			MaxAge: config.ArchiveMaxAge,
+			Quota:  config.ArchiveQuota,
+		}, config.Store)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var clipBuffer *clip.Buffer
+	if config.ClipPreRoll > 0 || config.ClipPostRoll > 0 {
+		clipBuffer = clip.New(clip.Config{
+			PreRoll:   config.ClipPreRoll,
+			PostRoll:  config.ClipPostRoll,
+			MaxLength: config.ClipMaxLength,
+			MaxBytes:  config.ClipMaxBytes,
+			MaxWidth:  config.ClipMaxWidth,
+			Codec:     config.ClipCodec,
+		})
+	}
 	c := &Cam{
 		Name:  config.Name,
-		Index: config.Index,
+		ID:    config.ID,
 		Store: config.Store,
 		Bot:   config.Bot,
 
@@ -101,6 +256,7 @@ func New(config Config) (*Cam, error) {
 		PaceLimit: ratelimit.NewBucketWithQuantum(15*time.Second, 6, 6),
 
 		LastOverviewSent: time.Now().Add(-10 * time.Second),
+		LastMotionSent:   time.Now().Add(-config.MotionAlertInterval),
 
 		MinConfidence:   config.MinConfidence,
 		MaxWidth:        config.MaxWidth,
@@ -108,17 +264,52 @@ func New(config Config) (*Cam, error) {
 		MinWidth:        config.MinWidth,
 		MinHeight:       config.MinHeight,
 		RequirePortrait: config.RequirePortrait,
+		AlertLabels:     config.AlertLabels,
+		LabelLimits:     config.LabelLimits,
 		CropRect:        cropRect,
 		ROIRect:         roiRect,
+		Zones:           config.Zones,
+		Tripwires:       config.Tripwires,
+		Dwell:           zone.NewDwell(config.DwellRules),
+		Stationary:      stationaryFilter,
+		Clip:            clipBuffer,
+		Burst:           burstAlerts,
+		Archive:         hitArchive,
 
 		SendRejected: config.SendRejected,
 
+		NMS: frame.NMSConfig{
+			Threshold:       config.NMSThreshold,
+			LabelThresholds: config.NMSLabelThresholds,
+			Merge:           config.MergeOverlaps,
+		},
+		Tracker: tracker.New(tracker.Config{
+			MaxAge: config.TrackMaxAge,
+			MinIoU: config.TrackMinIoU,
+		}),
+
+		MotionMinArea:       config.MotionMinArea,
+		MotionThreshold:     config.MotionThreshold,
+		MotionAlert:         config.MotionAlert,
+		MotionAlertInterval: config.MotionAlertInterval,
+
 		VideoCaptureURI: config.VideoCaptureURI,
 		PubSubControl:   config.PubSubControl,
 	}
 	return c, nil
 }
 
+// Limits are the camera wide limits, which LabelLimits override per label.
+func (c *Cam) Limits() frame.Limits {
+	return frame.Limits{
+		MinConfidence: c.MinConfidence,
+		MinWidth:      c.MinWidth,
+		MinHeight:     c.MinHeight,
+		MaxWidth:      c.MaxWidth,
+		MaxHeight:     c.MaxHeight,
+	}
+}
+
 func (c *Cam) TakeFrameBuckets() bool {
 	fl := c.FrameLimit.TakeAvailable(1)
 	if fl != 1 {
@@ -147,17 +338,48 @@ func (c *Cam) GetParams() string {
 		uri = before[0] + "://" + after[1]
 	}
 	out := fmt.Sprintf("Name: %s\n", c.Name)
-	out += fmt.Sprintf("Index: %d\n", c.Index)
+	out += fmt.Sprintf("ID: %s\n", c.ID)
+	out += fmt.Sprintf("TimeZone: %s\n", c.Location())
 	out += fmt.Sprintf("MaxWidth: %d\n", c.MaxWidth)
 	out += fmt.Sprintf("MaxHeight: %d\n", c.MaxHeight)
 	out += fmt.Sprintf("MinWidth: %d\n", c.MinWidth)
 	out += fmt.Sprintf("MinHeight: %d\n", c.MinHeight)
 	out += fmt.Sprintf("MinConfidence: %d\n", c.MinConfidence)
 	out += fmt.Sprintf("RequirePortrait: %v\n", c.RequirePortrait)
+	out += fmt.Sprintf("AlertLabels: %v\n", c.AlertLabels)
+	for label, limits := range c.LabelLimits {
+		out += fmt.Sprintf("Limits %s: %+v\n", label, limits)
+	}
 	out += fmt.Sprintf("Crop: %+v\n", c.CropRect)
 	out += fmt.Sprintf("ROI: %+v\n", c.ROIRect)
+	for _, z := range c.Zones {
+		out += fmt.Sprintf("Zone %s\n", z)
+	}
+	for _, t := range c.Tripwires {
+		out += fmt.Sprintf("Tripwire %s\n", t)
+	}
+	for _, r := range c.Dwell.Rules() {
+		out += fmt.Sprintf("Dwell %s: %v, repeat: %v, labels: %v\n", r.Zone, r.MinDwell, r.Repeat, r.Labels)
+	}
 	out += fmt.Sprintf("Capture: %s\n", uri)
 	out += fmt.Sprintf("SendRejected: %v\n", c.SendRejected)
+	out += fmt.Sprintf("NMS: %+v\n", c.NMS)
+	out += fmt.Sprintf("MotionMinArea: %.1f%%\n", c.MotionMinArea)
+	out += fmt.Sprintf("MotionAlert: %v every %v\n", c.MotionAlert, c.MotionAlertInterval)
+	out += fmt.Sprintf("AlertMode: %s\n", c.Burst.Mode)
+	if c.Burst.Enabled() {
+		out += fmt.Sprintf("Burst: quiet %v, max %d frames, max %v\n", c.Burst.Quiet, c.Burst.MaxFrames, c.Burst.MaxLength)
+	}
+	if c.Archive != nil {
+		out += fmt.Sprintf("Archive: %s, max age: %v", c.Archive.Root, c.Archive.MaxAge)
+		if c.Archive.Quota != nil {
+			out += fmt.Sprintf(", max bytes of all cameras: %d", c.Archive.Quota.MaxBytes)
+		}
+		out += "\n"
+	}
+	if c.Clip != nil {
+		out += fmt.Sprintf("Clip: %v before, %v after, max %v, max bytes: %d, width: %d, codec: %s\n", c.Clip.PreRoll, c.Clip.PostRoll, c.Clip.MaxLength, c.Clip.MaxBytes, c.Clip.MaxWidth, c.Clip.Codec)
+	}
 	return utils.MarkdownCode(out)
 }
 
@@ -169,14 +391,65 @@ func (c *Cam) TokensRemaining() string {
 	return utils.MarkdownCode(out)
 }
 
+func (c *Cam) TracksSummary() string {
+	tracks := c.Tracker.Tracks()
+	out := fmt.Sprintf("Tracks: %d\n", len(tracks))
+	for _, t := range tracks {
+		out += fmt.Sprintf("#%d %s: %v, seen %s, alerted: %v\n", t.ID, t.Label, utils.Round(t.Lifetime(), time.Second), t.LastSeen.In(c.Location()).Format("15:04:05"), t.Alerted)
+	}
+	return utils.MarkdownCode(out)
+}
+
+func (c *Cam) StationarySummary() string {
+	objects := c.Stationary.Objects()
+	out := fmt.Sprintf("Stationary: %d\n", len(objects))
+	for _, st := range objects {
+		out += fmt.Sprintf("#%d %s: %v, since %s\n", st.ID, st.Label, st.Rect, st.Since.In(c.Location()).Format("Jan 2 15:04"))
+	}
+	return utils.MarkdownCode(out)
+}
+
+// Location is the camera's time zone, the one its schedules are in.
+func (c *Cam) Location() *time.Location {
+	if c.Store == nil {
+		return time.Local
+	}
+	return c.Store.Location()
+}
+
+// FormatTime formats the time in the camera's zone, with the zone, for alerts.
+func (c *Cam) FormatTime(t time.Time) string {
+	return t.In(c.Location()).Format("Jan 2 15:04:05 MST")
+}
+
 func (c *Cam) SetActive(active bool) {
+	c.SetSchedActive(datastore.UploadSched, active)
+}
+
+func (c *Cam) IsActive() bool {
+	return c.SchedActive(datastore.UploadSched)
+}
+
+func (c *Cam) SetSchedActive(sched datastore.ScheduleName, active bool) {
 	c.activeLock.Lock()
 	defer c.activeLock.Unlock()
-	c.active = active
+	if c.active == nil {
+		c.active = map[datastore.ScheduleName]bool{}
+	}
+	c.active[sched] = active
 }
 
-func (c *Cam) IsActive() bool {
+// SchedActive returns whether the schedule was on at the last check.
+func (c *Cam) SchedActive(sched datastore.ScheduleName) bool {
+	active, _ := c.SchedState(sched)
+	return active
+}
+
+// SchedState returns whether the schedule was on at the last check, and whether it has
+// been checked yet.
+func (c *Cam) SchedState(sched datastore.ScheduleName) (active bool, checked bool) {
 	c.activeLock.Lock()
 	defer c.activeLock.Unlock()
-	return c.active
+	active, checked = c.active[sched]
+	return active, checked
 }
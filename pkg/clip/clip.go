@@ -0,0 +1,259 @@
+package clip
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/marktheunissen/watchbot/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"gocv.io/x/gocv"
+)
+
+var log = logrus.WithField("component", "clip")
+
+type Config struct {
+	// Frames kept from before the first hit.
+	PreRoll time.Duration
+
+	// Frames kept after the last hit, each hit extends the clip.
+	PostRoll time.Duration
+
+	// A clip is cut off at this length, or once its frames take up MaxBytes, even if
+	// the hits continue. The pre-roll is kept within MaxBytes as well.
+	MaxLength time.Duration
+	MaxBytes  int64
+
+	// Frames are scaled down to this width, and kept as JPEGs until the clip is encoded.
+	MaxWidth int
+
+	// FourCC of the video codec, must be H.264 (avc1) for Telegram to play it inline.
+	Codec string
+}
+
+// Frame is one JPEG of the clip.
+type Frame struct {
+	JPEG []byte
+	Time time.Time
+}
+
+// Buffer keeps the last PreRoll of a camera's frames in a ring, and on a hit turns them
+// into a clip that collects frames until PostRoll after the last hit.
+type Buffer struct {
+	Config
+	ring      []Frame
+	ringBytes int64
+	clip      *Clip
+	small     gocv.Mat
+}
+
+func New(config Config) *Buffer {
+	if config.MaxLength == 0 {
+		config.MaxLength = time.Minute
+	}
+	if config.MaxBytes == 0 {
+		config.MaxBytes = 32 * 1024 * 1024
+	}
+	if config.MaxWidth == 0 {
+		config.MaxWidth = 640
+	}
+	if config.Codec == "" {
+		config.Codec = "avc1"
+	}
+	return &Buffer{Config: config, small: gocv.NewMat()}
+}
+
+// Push adds a scaled down copy of the frame, it returns the clip once the frame is past
+// its end.
+func (b *Buffer) Push(img gocv.Mat, t time.Time) *Clip {
+	jpegBytes, err := b.encode(img)
+	if err != nil {
+		log.Errorf("skipping clip frame: %s", err)
+		return nil
+	}
+	f := Frame{JPEG: jpegBytes, Time: t}
+	if b.clip == nil {
+		b.ring = append(b.ring, f)
+		b.ringBytes += int64(len(f.JPEG))
+		drop := 0
+		for drop < len(b.ring)-1 && (t.Sub(b.ring[drop].Time) > b.PreRoll || b.ringBytes > b.MaxBytes) {
+			b.ringBytes -= int64(len(b.ring[drop].JPEG))
+			drop++
+		}
+		b.ring = b.ring[drop:]
+		return nil
+	}
+	b.clip.Frames = append(b.clip.Frames, f)
+	b.clip.Bytes += int64(len(f.JPEG))
+	if t.After(b.clip.until) || t.Sub(b.clip.Start()) >= b.MaxLength || b.clip.Bytes >= b.MaxBytes {
+		c := b.clip
+		b.clip = nil
+		return c
+	}
+	return nil
+}
+
+// encode scales the frame down to MaxWidth, and compresses it.
+func (b *Buffer) encode(img gocv.Mat) ([]byte, error) {
+	if img.Cols() > b.MaxWidth {
+		size := image.Pt(b.MaxWidth, img.Rows()*b.MaxWidth/img.Cols())
+		err := gocv.Resize(img, &b.small, size, 0, 0, gocv.InterpolationArea)
+		if err != nil {
+			return nil, fmt.Errorf("Resize: %s", err)
+		}
+		img = b.small
+	}
+	buf, err := gocv.IMEncode(gocv.JPEGFileExt, img)
+	defer buf.Close()
+	if err != nil {
+		return nil, fmt.Errorf("IMEncode: %s", err)
+	}
+	return append([]byte(nil), buf.GetBytes()...), nil
+}
+
+// Trigger starts a clip from the frames in the ring, or extends the current one.
+func (b *Buffer) Trigger(t time.Time, label string) {
+	if b.clip == nil {
+		b.clip = &Clip{
+			Frames: b.ring,
+			Bytes:  b.ringBytes,
+			Codec:  b.Codec,
+		}
+		b.ring = nil
+		b.ringBytes = 0
+	}
+	b.Extend(t, label)
+}
+
+// Extend moves the end of the current clip, if there is one, to PostRoll after t.
+func (b *Buffer) Extend(t time.Time, label string) {
+	if b.clip == nil {
+		return
+	}
+	if until := t.Add(b.PostRoll); until.After(b.clip.until) {
+		b.clip.until = until
+	}
+	for _, l := range b.clip.Labels {
+		if l == label {
+			return
+		}
+	}
+	b.clip.Labels = append(b.clip.Labels, label)
+}
+
+// Recording returns true while a clip is being collected.
+func (b *Buffer) Recording() bool {
+	return b.clip != nil
+}
+
+func (b *Buffer) Close() {
+	b.ring = nil
+	b.ringBytes = 0
+	b.clip = nil
+	b.small.Close()
+}
+
+// Clip is the frames around one or more hits, in the order they were taken.
+type Clip struct {
+	Frames []Frame
+	Bytes  int64
+	Labels []string
+	Codec  string
+	until  time.Time
+}
+
+func (c *Clip) Start() time.Time {
+	if len(c.Frames) == 0 {
+		return c.until
+	}
+	return c.Frames[0].Time
+}
+
+func (c *Clip) Duration() time.Duration {
+	if len(c.Frames) == 0 {
+		return 0
+	}
+	return c.Frames[len(c.Frames)-1].Time.Sub(c.Frames[0].Time)
+}
+
+// FPS is the average rate the frames were taken at, so the clip plays in real time.
+func (c *Clip) FPS() float64 {
+	secs := c.Duration().Seconds()
+	if len(c.Frames) < 2 || secs <= 0 {
+		return 1
+	}
+	return float64(len(c.Frames)-1) / secs
+}
+
+func (c *Clip) Caption() string {
+	labels := []string{}
+	for _, l := range c.Labels {
+		labels = append(labels, strings.Title(l))
+	}
+	return fmt.Sprintf("%s: %v clip", strings.Join(labels, ", "), utils.Round(c.Duration(), time.Second))
+}
+
+// WriteFile encodes the clip, frames of a different size than the first are skipped.
+func (c *Clip) WriteFile(filename string) error {
+	if len(c.Frames) == 0 {
+		return errors.New("empty clip")
+	}
+	var vw *gocv.VideoWriter
+	var width, height int
+	for i, f := range c.Frames {
+		img, err := gocv.IMDecode(f.JPEG, gocv.IMReadColor)
+		if err != nil {
+			return fmt.Errorf("frame %d: IMDecode: %s", i, err)
+		}
+		if img.Empty() {
+			img.Close()
+			return fmt.Errorf("frame %d: could not decode", i)
+		}
+		if vw == nil {
+			width, height = img.Cols(), img.Rows()
+			vw, err = gocv.VideoWriterFile(filename, c.Codec, c.FPS(), width, height, true)
+			if err != nil {
+				img.Close()
+				return fmt.Errorf("VideoWriterFile: %s", err)
+			}
+			defer vw.Close()
+		}
+		if img.Cols() != width || img.Rows() != height {
+			log.Debugf("skipping %dx%d frame in %dx%d clip", img.Cols(), img.Rows(), width, height)
+			img.Close()
+			continue
+		}
+		err = vw.Write(img)
+		img.Close()
+		if err != nil {
+			return fmt.Errorf("VideoWriter.Write: %s", err)
+		}
+	}
+	return nil
+}
+
+// MP4 returns the encoded clip, written through a temporary file.
+func (c *Clip) MP4() ([]byte, error) {
+	f, err := ioutil.TempFile("", "watchbot-clip")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	filename := f.Name() + ".mp4"
+	defer os.Remove(f.Name())
+	defer os.Remove(filename)
+	err = c.WriteFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filename)
+}
+
+func (c *Clip) Close() {
+	c.Frames = nil
+	c.Bytes = 0
+}
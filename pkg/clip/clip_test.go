@@ -0,0 +1,108 @@
+package clip
+
+import (
+	"bytes"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+func TestBuffer(t *testing.T) {
+	b := New(Config{
+		PreRoll:   2 * time.Second,
+		PostRoll:  3 * time.Second,
+		MaxLength: 20 * time.Second,
+	})
+	defer b.Close()
+	img := gocv.NewMatWithSize(32, 64, gocv.MatTypeCV8UC3)
+	defer img.Close()
+	start := time.Now()
+	at := func(secs int) time.Time { return start.Add(time.Duration(secs) * time.Second) }
+
+	for i := 0; i <= 10; i++ {
+		if c := b.Push(img, at(i)); c != nil {
+			t.Fatal("want no clip without a hit")
+		}
+	}
+	if len(b.ring) != 3 {
+		t.Fatalf("want 3 frames of pre-roll, got: %d", len(b.ring))
+	}
+
+	b.Trigger(at(10), "person")
+	for i := 11; i <= 12; i++ {
+		b.Push(img, at(i))
+	}
+	// Still in view, extends the clip to 15s.
+	b.Extend(at(12), "car")
+	var got *Clip
+	for i := 13; i <= 16 && got == nil; i++ {
+		got = b.Push(img, at(i))
+	}
+	if got == nil {
+		t.Fatal("want the clip to end after the post-roll")
+	}
+	defer got.Close()
+	if got.Start() != at(8) || got.Frames[len(got.Frames)-1].Time != at(16) {
+		t.Fatalf("want clip from 8s to 16s, got: %v to %v", got.Start().Sub(start), got.Frames[len(got.Frames)-1].Time.Sub(start))
+	}
+	if got.FPS() != 1 {
+		t.Fatalf("want 1 fps, got: %f", got.FPS())
+	}
+	if got.Caption() != "Person, Car: 8s clip" {
+		t.Fatalf("unexpected caption: %s", got.Caption())
+	}
+	if b.Recording() || len(b.ring) != 0 {
+		t.Fatal("want the buffer empty after the clip")
+	}
+}
+
+func TestBufferMaxLength(t *testing.T) {
+	b := New(Config{PostRoll: time.Minute, MaxLength: 5 * time.Second})
+	defer b.Close()
+	img := gocv.NewMatWithSize(32, 64, gocv.MatTypeCV8UC3)
+	defer img.Close()
+	start := time.Now()
+	b.Push(img, start)
+	b.Trigger(start, "person")
+	var got *Clip
+	i := 1
+	for ; i < 100 && got == nil; i++ {
+		got = b.Push(img, start.Add(time.Duration(i)*time.Second))
+	}
+	if got == nil || got.Duration() != 5*time.Second {
+		t.Fatalf("want the clip cut off at 5s, got: %v", got)
+	}
+	got.Close()
+}
+
+func TestBufferMaxBytes(t *testing.T) {
+	img := gocv.NewMatWithSize(480, 1280, gocv.MatTypeCV8UC3)
+	defer img.Close()
+	start := time.Now()
+	at := func(secs int) time.Time { return start.Add(time.Duration(secs) * time.Second) }
+
+	one := New(Config{})
+	defer one.Close()
+	one.Push(img, start)
+	size := one.ringBytes
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(one.ring[0].JPEG))
+	if err != nil || cfg.Width != 640 || cfg.Height != 240 {
+		t.Fatalf("want the frame scaled down to 640x240, got: %+v, %v", cfg, err)
+	}
+
+	b := New(Config{PreRoll: time.Minute, PostRoll: time.Minute, MaxBytes: 3 * size})
+	defer b.Close()
+	for i := 0; i < 10; i++ {
+		b.Push(img, at(i))
+	}
+	if len(b.ring) != 3 || b.ring[0].Time != at(7) {
+		t.Fatalf("want the last 3 frames of pre-roll, got: %d", len(b.ring))
+	}
+	b.Trigger(at(9), "person")
+	got := b.Push(img, at(10))
+	if got == nil || len(got.Frames) != 4 {
+		t.Fatalf("want the clip cut off at 4 frames, got: %v", got)
+	}
+}
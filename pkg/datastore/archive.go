@@ -0,0 +1,121 @@
+package datastore
+
+import (
+	"strings"
+	"time"
+)
+
+// ArchiveEntry indexes one hit written to the local archive.
+type ArchiveEntry struct {
+	ID     int64
+	Time   time.Time
+	Dir    string
+	Labels []string
+	Boxes  int
+	Bytes  int64
+}
+
+// ArchiveQuery selects archive entries, zero values match everything.
+type ArchiveQuery struct {
+	From  time.Time
+	To    time.Time
+	Label string
+	Limit int
+}
+
+// ArchiveAdd indexes the entry, and returns its ID.
+func (s *Store) ArchiveAdd(e ArchiveEntry) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result, err := s.db.Exec("INSERT INTO archive (time, dir, labels, boxes, bytes) VALUES (?, ?, ?, ?, ?)", e.Time.UnixNano(), e.Dir, joinLabels(e.Labels), e.Boxes, e.Bytes)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// ArchiveList returns the matching entries, newest first.
+func (s *Store) ArchiveList(q ArchiveQuery) ([]ArchiveEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	query := "SELECT id, time, dir, labels, boxes, bytes FROM archive WHERE 1=1"
+	args := []interface{}{}
+	if !q.From.IsZero() {
+		query += " AND time >= ?"
+		args = append(args, q.From.UnixNano())
+	}
+	if !q.To.IsZero() {
+		query += " AND time < ?"
+		args = append(args, q.To.UnixNano())
+	}
+	if q.Label != "" {
+		query += " AND labels LIKE ?"
+		args = append(args, "%,"+q.Label+",%")
+	}
+	query += " ORDER BY time DESC, id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+	return s.archiveQuery(query, args...)
+}
+
+// ArchiveOldest returns up to n entries, oldest first.
+func (s *Store) ArchiveOldest(n int) ([]ArchiveEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.archiveQuery("SELECT id, time, dir, labels, boxes, bytes FROM archive ORDER BY time, id LIMIT ?", n)
+}
+
+// ArchiveSize returns the number of entries and their total bytes.
+func (s *Store) ArchiveSize() (int, int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var count int
+	var bytes int64
+	err := s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(bytes), 0) FROM archive").Scan(&count, &bytes)
+	return count, bytes, err
+}
+
+func (s *Store) ArchiveRemove(id int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := s.db.Exec("DELETE FROM archive WHERE id = ?", id)
+	return err
+}
+
+func (s *Store) archiveQuery(query string, args ...interface{}) ([]ArchiveEntry, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []ArchiveEntry{}
+	for rows.Next() {
+		var e ArchiveEntry
+		var t int64
+		var labels string
+		err = rows.Scan(&e.ID, &t, &e.Dir, &labels, &e.Boxes, &e.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, t)
+		e.Labels = splitLabels(labels)
+		ret = append(ret, e)
+	}
+	return ret, rows.Err()
+}
+
+// joinLabels stores the labels with a comma on each side, so that one can be matched
+// with LIKE '%,label,%'.
+func joinLabels(labels []string) string {
+	return "," + strings.Join(labels, ",") + ","
+}
+
+func splitLabels(s string) []string {
+	s = strings.Trim(s, ",")
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
@@ -3,9 +3,13 @@ package datastore
 import (
 	"bytes"
 	"database/sql"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/marktheunissen/watchbot/pkg/render"
+	"github.com/marktheunissen/watchbot/pkg/sun"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
 )
@@ -15,16 +19,62 @@ var log = logrus.WithField("component", "datastore")
 type ScheduleName int8
 
 const (
+	// UploadSched turns the camera's capture and detection on and off.
 	UploadSched ScheduleName = iota
+	// AlertSched is when detections are sent to the alert group.
+	AlertSched
+	// RecordSched is when detections are written to the archive.
+	RecordSched
+	// QuietSched is when alerts are sent without a notification sound.
+	QuietSched
 )
 
+// Schedules lists the schedules every store has, in the order they're shown.
+var Schedules = []ScheduleName{UploadSched, AlertSched, RecordSched, QuietSched}
+
+func (n ScheduleName) String() string {
+	switch n {
+	case UploadSched:
+		return "detect"
+	case AlertSched:
+		return "alert"
+	case RecordSched:
+		return "record"
+	case QuietSched:
+		return "quiet"
+	}
+	return ""
+}
+
+// StrScheduleName parses a schedule name as used in the bot commands, false if there is
+// no such schedule.
+func StrScheduleName(name string) (ScheduleName, bool) {
+	switch strings.ToLower(name) {
+	case "detect", "detection":
+		return UploadSched, true
+	case "alert", "alerting", "alerts":
+		return AlertSched, true
+	case "record", "recording":
+		return RecordSched, true
+	case "quiet":
+		return QuietSched, true
+	}
+	return 0, false
+}
+
 type Config struct {
 	Filename string
+
+	// Location of the camera, for the sun rules of its schedules.
+	Coords *sun.Coords
+
+	// Time zone the schedules and event counts are in, time.Local if nil.
+	Location *time.Location
 }
 
 type Store struct {
-	db          *sql.DB
-	uploadSched *Schedule
+	db  *sql.DB
+	loc *time.Location
 
 	schedules map[ScheduleName]*Schedule
 
@@ -37,28 +87,61 @@ func New(config Config) (*Store, error) {
 	if err != nil {
 		return nil, err
 	}
-	sqlStmt := `CREATE TABLE upload_sched (dayhour TEXT NOT NULL PRIMARY KEY);`
-	db.Exec(sqlStmt)
-	sqlStmt = `CREATE TABLE sched_mode (sched TEXT NOT NULL PRIMARY KEY, mode TEXT NOT NULL);`
-	db.Exec(sqlStmt)
+	err = migrate(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %s", config.Filename, err)
+	}
+	if config.Location == nil {
+		config.Location = time.Local
+	}
 	s := &Store{
-		db: db,
-		uploadSched: &Schedule{
-			Table: "upload_sched",
-			Db:    db,
-		},
+		db:        db,
+		loc:       config.Location,
 		schedules: map[ScheduleName]*Schedule{},
 	}
-	s.schedules[UploadSched] = s.uploadSched
+	// Detection keeps the table name it had when it was the only schedule. Alerts and
+	// recording follow detection until they're given schedules of their own.
+	defaults := map[ScheduleName]struct {
+		table string
+		mode  ScheduleMode
+	}{
+		UploadSched: {"upload_sched", ModeSched},
+		AlertSched:  {"alert_sched", ModeOn},
+		RecordSched: {"record_sched", ModeOn},
+		QuietSched:  {"quiet_sched", ModeSched},
+	}
+	for name, d := range defaults {
+		s.schedules[name] = &Schedule{
+			Table:       d.table,
+			Db:          db,
+			Coords:      config.Coords,
+			Location:    config.Location,
+			DefaultMode: d.mode,
+		}
+	}
 	return s, nil
 }
 
+// Location is the time zone of the store's schedules.
+func (s *Store) Location() *time.Location {
+	return s.loc
+}
+
 func (s *Store) SchedActiveNow(sched ScheduleName) (bool, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	return s.schedules[sched].IsActiveNow()
 }
 
+// SchedActiveAt returns whether the schedule is active at the time, taking its mode
+// into account.
+func (s *Store) SchedActiveAt(sched ScheduleName, t time.Time) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.schedules[sched].IsActiveAt(t)
+}
+
 func (s *Store) SchedActivateAll(sched ScheduleName) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -93,36 +176,112 @@ func (s *Store) SchedGetTable(sched ScheduleName) (*bytes.Buffer, error) {
 	return filebytes, nil
 }
 
-func (s *Store) SchedActivate(sched ScheduleName, dayhour string) error {
+func (s *Store) SchedActivate(sched ScheduleName, spec string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	err := s.schedules[sched].Activate(dayhour)
+	err := s.schedules[sched].Activate(spec)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *Store) SchedDeactivate(sched ScheduleName, dayhour string) error {
+func (s *Store) SchedDeactivate(sched ScheduleName, spec string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	err := s.schedules[sched].Deactivate(dayhour)
+	err := s.schedules[sched].Deactivate(spec)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (s *Store) SchedRanges(sched ScheduleName) ([]Range, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.schedules[sched].Ranges()
+}
+
+func (s *Store) SchedAddSunRule(sched ScheduleName, r SunRule) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.schedules[sched].AddSunRule(r)
+}
+
+func (s *Store) SchedSunRules(sched ScheduleName) ([]SunRule, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.schedules[sched].SunRules()
+}
+
+func (s *Store) SchedRemoveSunRule(sched ScheduleName, id int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.schedules[sched].RemoveSunRule(id)
+}
+
+// SunTimes returns today's sunrise and sunset, false if the location isn't configured.
+func (s *Store) SunTimes(now time.Time) (sun.Day, bool) {
+	coords := s.schedules[UploadSched].Coords
+	if coords == nil {
+		return sun.Day{}, false
+	}
+	return sun.Times(now.In(s.loc), *coords), true
+}
+
+// SchedSetMode sets the mode, and ends any timed override.
 func (s *Store) SchedSetMode(sched ScheduleName, mode ScheduleMode) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	err := s.schedules[sched].SetMode(mode)
+	err := s.schedules[sched].ClearOverride()
+	if err != nil {
+		return err
+	}
+	err = s.schedules[sched].SetMode(mode)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (s *Store) SchedSetOverride(sched ScheduleName, o Override) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.schedules[sched].SetOverride(o)
+}
+
+// SchedGetOverride returns the override in effect now, nil if there is none.
+func (s *Store) SchedGetOverride(sched ScheduleName) (*Override, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.schedules[sched].GetOverride(time.Now())
+}
+
+func (s *Store) SchedClearOverride(sched ScheduleName) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.schedules[sched].ClearOverride()
+}
+
+func (s *Store) SchedAddException(sched ScheduleName, e Exception) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.schedules[sched].AddException(e)
+}
+
+// SchedExceptions returns the exceptions that haven't ended yet.
+func (s *Store) SchedExceptions(sched ScheduleName) ([]Exception, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.schedules[sched].Exceptions(time.Now().In(s.loc).Format(dateLayout))
+}
+
+func (s *Store) SchedRemoveException(sched ScheduleName, id int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.schedules[sched].RemoveException(id)
+}
+
 func (s *Store) SchedGetMode(sched ScheduleName) (ScheduleMode, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -1,12 +1,19 @@
 package datastore_test
 
 import (
+	"database/sql"
+	"fmt"
+	"image"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/marktheunissen/watchbot/pkg/datastore"
+	"github.com/marktheunissen/watchbot/pkg/sun"
 	h "github.com/marktheunissen/watchbot/pkg/test/helpers"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func getDS(t *testing.T) (*datastore.Store, func()) {
@@ -76,3 +83,642 @@ func TestSchedule(t *testing.T) {
 		t.Fatal("expected inactive schedule, found active")
 	}
 }
+
+func TestScheduleRanges(t *testing.T) {
+	d, cleanup := getDS(t)
+	defer cleanup()
+
+	for _, spec := range []string{"mon-fri 22:30-06:15", "sat 9-17", "sun 23:00-24:00"} {
+		h.FatalIfErr(t, d.SchedActivate(datastore.UploadSched, spec))
+	}
+	ranges, err := d.SchedRanges(datastore.UploadSched)
+	h.FatalIfErr(t, err)
+	if fmt.Sprint(ranges) != "[sat 09:00-17:00 mon-fri 22:30-06:15 sun 23:00-24:00]" {
+		t.Fatalf("unexpected ranges: %v", ranges)
+	}
+
+	// 2018-09-03 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2018, 9, 2+day, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		t      time.Time
+		active bool
+	}{
+		{at(1, 22, 29), false},
+		{at(1, 22, 30), true},
+		{at(2, 6, 14), true},
+		{at(2, 6, 15), false},
+		{at(1, 3, 0), false}, // Sunday night isn't on
+		{at(6, 3, 0), true},  // Friday night runs into Saturday
+		{at(6, 12, 0), true},
+		{at(7, 12, 0), false},
+		{at(7, 23, 59), true},
+		{at(8, 0, 0), false},
+	}
+	for _, test := range tests {
+		active, err := d.SchedActiveAt(datastore.UploadSched, test.t)
+		h.FatalIfErr(t, err)
+		if active != test.active {
+			t.Errorf("want active %v at %s", test.active, test.t.Format("Mon 15:04"))
+		}
+	}
+
+	// Turning off part of a range splits it, and the old hour cells still work.
+	h.FatalIfErr(t, d.SchedDeactivate(datastore.UploadSched, "wed 00:00-24:00"))
+	h.FatalIfErr(t, d.SchedDeactivate(datastore.UploadSched, "sat"))
+	h.FatalIfErr(t, d.SchedActivate(datastore.UploadSched, "sun-9"))
+	ranges, err = d.SchedRanges(datastore.UploadSched)
+	h.FatalIfErr(t, err)
+	if fmt.Sprint(ranges) != "[thu 00:00-06:15 sun 09:00-10:00 mon+thu 22:30-06:15 tue+fri 22:30-24:00 sun 23:00-24:00]" {
+		t.Fatalf("unexpected ranges after changes: %v", ranges)
+	}
+
+	for _, spec := range []string{"", "mon 10:00", "mon 10:00-10:00", "mon 25:00-26:00", "xyz", "mon-24", "mon-fri-sat 1-2"} {
+		_, err := datastore.ParseRange(spec)
+		if err == nil {
+			t.Errorf("want error parsing %q", spec)
+		}
+	}
+}
+
+func TestScheduleOverrides(t *testing.T) {
+	d, cleanup := getDS(t)
+	defer cleanup()
+
+	h.FatalIfErr(t, d.SchedActivate(datastore.UploadSched, "mon-fri 9-17"))
+	for _, spec := range []string{"off 2099-12-24..2099-12-25 christmas", "on 2099-12-26 10:00-12:00"} {
+		e, err := datastore.ParseException(spec)
+		h.FatalIfErr(t, err)
+		_, err = d.SchedAddException(datastore.UploadSched, e)
+		h.FatalIfErr(t, err)
+	}
+	exceptions, err := d.SchedExceptions(datastore.UploadSched)
+	h.FatalIfErr(t, err)
+	if len(exceptions) != 2 || exceptions[0].String() != fmt.Sprintf("%d: off 2099-12-24..2099-12-25 christmas", exceptions[0].ID) {
+		t.Fatalf("unexpected exceptions: %v", exceptions)
+	}
+
+	at := func(day, hour int) time.Time {
+		return time.Date(2099, 12, day, hour, 0, 0, 0, time.Local)
+	}
+	check := func(when time.Time, want bool) {
+		t.Helper()
+		active, err := d.SchedActiveAt(datastore.UploadSched, when)
+		h.FatalIfErr(t, err)
+		if active != want {
+			t.Fatalf("want active %v at %s", want, when.Format("Mon Jan 2 15:04"))
+		}
+	}
+	check(at(23, 10), true)
+	check(at(24, 10), false) // holiday
+	check(at(26, 11), true)  // one-off Saturday
+	check(at(26, 12), false)
+
+	// A timed override wins over the exception, and then expires.
+	o, err := datastore.ParseOverride("on until 2099-12-24 12:00", at(24, 8))
+	h.FatalIfErr(t, err)
+	h.FatalIfErr(t, d.SchedSetOverride(datastore.UploadSched, o))
+	check(at(24, 10), true)
+	check(at(24, 13), false)
+
+	// Setting the mode ends the override.
+	h.FatalIfErr(t, d.SchedSetMode(datastore.UploadSched, datastore.ModeSched))
+	check(at(24, 10), false)
+
+	h.FatalIfErr(t, d.SchedRemoveException(datastore.UploadSched, exceptions[0].ID))
+	check(at(24, 10), true)
+	if d.SchedRemoveException(datastore.UploadSched, exceptions[0].ID) == nil {
+		t.Fatal("want error removing a missing exception")
+	}
+
+	now := time.Now()
+	o, err = datastore.ParseOverride("off for 3h", now)
+	h.FatalIfErr(t, err)
+	if o.Mode != datastore.ModeOff || !o.Until.Equal(now.Add(3*time.Hour)) {
+		t.Fatalf("unexpected override: %v", o)
+	}
+	o, err = datastore.ParseOverride("on for 2d", now)
+	h.FatalIfErr(t, err)
+	if !o.Until.Equal(now.Add(48 * time.Hour)) {
+		t.Fatalf("unexpected override: %v", o)
+	}
+	for _, spec := range []string{"on", "on for", "maybe for 3h", "off until 2020-01-01", "off until tomorrow", "on for 3h please"} {
+		_, err := datastore.ParseOverride(spec, now)
+		if err == nil {
+			t.Errorf("want error parsing override %q", spec)
+		}
+	}
+	for _, spec := range []string{"off", "maybe 2099-12-24", "off 2099-13-01", "off 2099-12-26..2099-12-24", "on 2099-12-31 22:00-02:00"} {
+		_, err := datastore.ParseException(spec)
+		if err == nil {
+			t.Errorf("want error parsing exception %q", spec)
+		}
+	}
+}
+
+func TestSunRules(t *testing.T) {
+	d, cleanup := getDS(t)
+	r, err := datastore.ParseSunRule("from sunset+30m to sunrise-15m")
+	h.FatalIfErr(t, err)
+	_, err = d.SchedAddSunRule(datastore.UploadSched, r)
+	cleanup()
+	if err == nil {
+		t.Fatal("want error adding a sun rule without coordinates")
+	}
+
+	tmpfile, err := ioutil.TempFile("", "test-dbs")
+	h.FatalIfErr(t, err)
+	defer os.Remove(tmpfile.Name())
+	london := &sun.Coords{Lat: 51.5074, Lon: -0.1278}
+	d, err = datastore.New(datastore.Config{Filename: tmpfile.Name(), Coords: london})
+	h.FatalIfErr(t, err)
+
+	_, err = d.SchedAddSunRule(datastore.UploadSched, r)
+	h.FatalIfErr(t, err)
+	r, err = datastore.ParseSunRule("sat sunrise+1h30m sunset")
+	h.FatalIfErr(t, err)
+	_, err = d.SchedAddSunRule(datastore.UploadSched, r)
+	h.FatalIfErr(t, err)
+	rules, err := d.SchedSunRules(datastore.UploadSched)
+	h.FatalIfErr(t, err)
+	if fmt.Sprint(rules) != "[mon-sun from sunset+30m to sunrise-15m sat from sunrise+1h30m to sunset]" {
+		t.Fatalf("unexpected sun rules: %v", rules)
+	}
+
+	// Sunrise 03:43 and sunset 20:21 UTC, around midsummer 2018 in London.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2018, 6, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		t      time.Time
+		active bool
+	}{
+		{at(21, 20, 40), false},
+		{at(21, 21, 0), true},
+		{at(22, 3, 0), true},
+		{at(22, 3, 40), false},
+		{at(22, 12, 0), false},
+		{at(23, 12, 0), true}, // Saturday
+		{at(24, 12, 0), false},
+	}
+	for _, test := range tests {
+		active, err := d.SchedActiveAt(datastore.UploadSched, test.t)
+		h.FatalIfErr(t, err)
+		if active != test.active {
+			t.Errorf("want active %v at %s", test.active, test.t.Format("Mon Jan 2 15:04"))
+		}
+	}
+
+	h.FatalIfErr(t, d.SchedRemoveSunRule(datastore.UploadSched, rules[1].ID))
+	active, err := d.SchedActiveAt(datastore.UploadSched, at(23, 12, 0))
+	h.FatalIfErr(t, err)
+	if active {
+		t.Fatal("want the removed rule off")
+	}
+
+	for _, spec := range []string{"sunset", "from noon to sunrise", "sunset+x sunrise", "xyz sunset sunrise"} {
+		_, err := datastore.ParseSunRule(spec)
+		if err == nil {
+			t.Errorf("want error parsing %q", spec)
+		}
+	}
+}
+
+func TestNamedSchedules(t *testing.T) {
+	d, cleanup := getDS(t)
+	defer cleanup()
+
+	// Alerts and recording follow detection until they're set, quiet hours are empty.
+	wants := map[datastore.ScheduleName]datastore.ScheduleMode{
+		datastore.UploadSched: datastore.ModeSched,
+		datastore.AlertSched:  datastore.ModeOn,
+		datastore.RecordSched: datastore.ModeOn,
+		datastore.QuietSched:  datastore.ModeSched,
+	}
+	for sched, want := range wants {
+		mode, err := d.SchedGetMode(sched)
+		h.FatalIfErr(t, err)
+		if mode != want {
+			t.Errorf("%s: want mode %s, got %s", sched, datastore.ModeStr(want), datastore.ModeStr(mode))
+		}
+	}
+	active, err := d.SchedActiveNow(datastore.QuietSched)
+	h.FatalIfErr(t, err)
+	if active {
+		t.Fatal("want quiet hours off until they're set")
+	}
+
+	h.FatalIfErr(t, d.SchedSetMode(datastore.AlertSched, datastore.ModeSched))
+	h.FatalIfErr(t, d.SchedActivate(datastore.AlertSched, "mon-fri 18:00-08:00"))
+	h.FatalIfErr(t, d.SchedActivate(datastore.QuietSched, "23:00-07:00"))
+	monday := func(hour int) time.Time {
+		return time.Date(2018, 9, 3, hour, 0, 0, 0, time.Local)
+	}
+	tests := []struct {
+		sched  datastore.ScheduleName
+		t      time.Time
+		active bool
+	}{
+		{datastore.UploadSched, monday(12), false},
+		{datastore.AlertSched, monday(12), false},
+		{datastore.AlertSched, monday(19), true},
+		{datastore.QuietSched, monday(19), false},
+		{datastore.QuietSched, monday(23), true},
+		{datastore.RecordSched, monday(12), true},
+	}
+	for _, test := range tests {
+		active, err := d.SchedActiveAt(test.sched, test.t)
+		h.FatalIfErr(t, err)
+		if active != test.active {
+			t.Errorf("%s: want active %v at %s", test.sched, test.active, test.t.Format("Mon 15:04"))
+		}
+	}
+	ranges, err := d.SchedRanges(datastore.UploadSched)
+	h.FatalIfErr(t, err)
+	if len(ranges) != 0 {
+		t.Fatalf("want the detection schedule untouched, got %v", ranges)
+	}
+
+	for _, name := range []string{"detect", "Alerting", "record", "quiet"} {
+		if _, ok := datastore.StrScheduleName(name); !ok {
+			t.Errorf("want %q to be a schedule", name)
+		}
+	}
+	if _, ok := datastore.StrScheduleName("on"); ok {
+		t.Error("want on not to be a schedule")
+	}
+}
+
+func TestScheduleTimezone(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "test-dbs")
+	h.FatalIfErr(t, err)
+	defer os.Remove(tmpfile.Name())
+	newYork, err := time.LoadLocation("America/New_York")
+	h.FatalIfErr(t, err)
+	d, err := datastore.New(datastore.Config{Filename: tmpfile.Name(), Location: newYork})
+	h.FatalIfErr(t, err)
+	defer d.Close()
+
+	h.FatalIfErr(t, d.SchedDeactivateAll(datastore.UploadSched))
+	for _, spec := range []string{"mon 09:00-10:00", "sun 02:15-02:45", "sun 01:00-01:30"} {
+		h.FatalIfErr(t, d.SchedActivate(datastore.UploadSched, spec))
+	}
+
+	// The clocks went forward at 02:00 on 11 March 2018 and back at 02:00 on 4 November.
+	at := func(month, day, hour, minute int) time.Time {
+		return time.Date(2018, time.Month(month), day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		t      time.Time
+		active bool
+	}{
+		{at(1, 8, 14, 30), true},  // 09:30 EST
+		{at(1, 8, 9, 30), false},  // 04:30 EST
+		{at(7, 9, 13, 30), true},  // 09:30 EDT
+		{at(7, 9, 14, 30), false}, // 10:30 EDT
+		{at(11, 4, 5, 15), true},  // 01:15 EDT
+		{at(11, 4, 6, 15), true},  // 01:15 EST, the second time round
+		{at(11, 4, 6, 45), false}, // 01:45 EST
+	}
+	for _, test := range tests {
+		active, err := d.SchedActiveAt(datastore.UploadSched, test.t)
+		h.FatalIfErr(t, err)
+		if active != test.active {
+			t.Errorf("want active %v at %s", test.active, test.t.In(newYork).Format("Mon Jan 2 15:04 MST"))
+		}
+	}
+	// 02:15-02:45 never happens on the night the clocks go forward.
+	for m := at(3, 11, 6, 30); m.Before(at(3, 11, 8, 0)); m = m.Add(time.Minute) {
+		active, err := d.SchedActiveAt(datastore.UploadSched, m)
+		h.FatalIfErr(t, err)
+		if active {
+			t.Fatalf("want off at %s", m.In(newYork).Format("Mon Jan 2 15:04 MST"))
+		}
+	}
+
+	// 03:30 UTC on 5 September is the evening of the 4th in New York.
+	err = d.EventAdd(datastore.Event{Camera: "driveway", Time: at(9, 5, 3, 30), Label: "person", Status: datastore.EventSent})
+	h.FatalIfErr(t, err)
+	daily, err := d.EventCounts(datastore.EventQuery{}, datastore.Daily)
+	h.FatalIfErr(t, err)
+	if len(daily) != 1 || !daily[0].Start.Equal(time.Date(2018, 9, 4, 0, 0, 0, 0, newYork)) {
+		t.Fatalf("unexpected daily counts: %+v", daily)
+	}
+}
+
+func TestScheduleExport(t *testing.T) {
+	london := &sun.Coords{Lat: 51.5074, Lon: -0.1278}
+	files := []string{}
+	defer func() {
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+	newStore := func() *datastore.Store {
+		tmpfile, err := ioutil.TempFile("", "test-dbs")
+		h.FatalIfErr(t, err)
+		files = append(files, tmpfile.Name())
+		d, err := datastore.New(datastore.Config{Filename: tmpfile.Name(), Coords: london, Location: time.UTC})
+		h.FatalIfErr(t, err)
+		return d
+	}
+	src := newStore()
+	h.FatalIfErr(t, src.SchedActivate(datastore.UploadSched, "mon-fri 22:30-06:15"))
+	h.FatalIfErr(t, src.SchedActivate(datastore.UploadSched, "sat 9-17"))
+	r, err := datastore.ParseSunRule("sat+sun from sunset+30m to sunrise-15m")
+	h.FatalIfErr(t, err)
+	_, err = src.SchedAddSunRule(datastore.UploadSched, r)
+	h.FatalIfErr(t, err)
+	e, err := datastore.ParseException("off 2099-12-24..2099-12-26 christmas")
+	h.FatalIfErr(t, err)
+	_, err = src.SchedAddException(datastore.UploadSched, e)
+	h.FatalIfErr(t, err)
+	h.FatalIfErr(t, src.SchedSetOverride(datastore.AlertSched, datastore.Override{Mode: datastore.ModeOff, Until: time.Date(2099, 1, 2, 7, 0, 0, 0, time.UTC)}))
+	h.FatalIfErr(t, src.SchedActivate(datastore.QuietSched, "23:00-07:00"))
+
+	export, err := src.SchedExport()
+	h.FatalIfErr(t, err)
+	yamlBytes, err := export.YAML()
+	h.FatalIfErr(t, err)
+	want := `time_zone: UTC
+schedules:
+  alert:
+    mode: "on"
+    override:
+      mode: "off"
+      until: "2099-01-02T07:00:00Z"
+  detect:
+    mode: sched
+    ranges:
+    - sat 09:00-17:00
+    - mon-fri 22:30-06:15
+    sun_rules:
+    - sat+sun from sunset+30m to sunrise-15m
+    exceptions:
+    - off 2099-12-24..2099-12-26 christmas
+  quiet:
+    mode: sched
+    ranges:
+    - mon-sun 23:00-07:00
+  record:
+    mode: "on"
+`
+	if string(yamlBytes) != want {
+		t.Fatalf("unexpected export:\n%s", yamlBytes)
+	}
+
+	for _, format := range []string{"yaml", "json"} {
+		data := yamlBytes
+		if format == "json" {
+			data, err = export.JSON()
+			h.FatalIfErr(t, err)
+		}
+		parsed, err := datastore.ParseExport(data)
+		h.FatalIfErr(t, err)
+		dst := newStore()
+		h.FatalIfErr(t, dst.SchedImport(parsed))
+		again, err := dst.SchedExport()
+		h.FatalIfErr(t, err)
+		if !reflect.DeepEqual(again, export) {
+			t.Fatalf("%s: want the import to export the same, got %+v", format, again.Schedules)
+		}
+	}
+
+	// Nothing is written if any of the schedules is invalid.
+	dst := newStore()
+	h.FatalIfErr(t, dst.SchedActivate(datastore.UploadSched, "sun"))
+	bad, err := datastore.ParseExport([]byte(`{"schedules": {"detect": {"ranges": ["mon 9-17"]}, "quiet": {"ranges": ["mon 25-26"]}}}`))
+	h.FatalIfErr(t, err)
+	if dst.SchedImport(bad) == nil {
+		t.Fatal("want error importing an invalid range")
+	}
+	ranges, err := dst.SchedRanges(datastore.UploadSched)
+	h.FatalIfErr(t, err)
+	if fmt.Sprint(ranges) != "[sun 00:00-24:00]" {
+		t.Fatalf("want the schedule unchanged, got %v", ranges)
+	}
+	for _, data := range []string{"", "schedules: {}", `{"schedules": {"nightly": {}}}`} {
+		e, err := datastore.ParseExport([]byte(data))
+		if err == nil {
+			err = dst.SchedImport(e)
+		}
+		if err == nil {
+			t.Errorf("want error importing %q", data)
+		}
+	}
+
+	// Copying one schedule leaves the others alone.
+	h.FatalIfErr(t, dst.SchedCopy(src, datastore.QuietSched))
+	ranges, err = dst.SchedRanges(datastore.QuietSched)
+	h.FatalIfErr(t, err)
+	if fmt.Sprint(ranges) != "[mon-sun 23:00-07:00]" {
+		t.Fatalf("unexpected copied ranges: %v", ranges)
+	}
+	ranges, err = dst.SchedRanges(datastore.UploadSched)
+	h.FatalIfErr(t, err)
+	if fmt.Sprint(ranges) != "[sun 00:00-24:00]" {
+		t.Fatalf("want the detection schedule unchanged, got %v", ranges)
+	}
+}
+
+func TestStationary(t *testing.T) {
+	d, cleanup := getDS(t)
+	defer cleanup()
+
+	since := time.Unix(1500000000, 0)
+	id, err := d.StationaryAdd(datastore.Stationary{Label: "car", Rect: image.Rect(10, 20, 110, 80), Since: since})
+	h.FatalIfErr(t, err)
+	_, err = d.StationaryAdd(datastore.Stationary{Label: "car", Rect: image.Rect(200, 20, 300, 80), Since: since})
+	h.FatalIfErr(t, err)
+
+	list, err := d.StationaryList()
+	h.FatalIfErr(t, err)
+	if len(list) != 2 || list[0].ID != id || list[0].Rect != image.Rect(10, 20, 110, 80) || !list[0].Since.Equal(since) {
+		t.Fatalf("unexpected stationary list: %+v", list)
+	}
+
+	err = d.StationaryRemove(id)
+	h.FatalIfErr(t, err)
+	list, err = d.StationaryList()
+	h.FatalIfErr(t, err)
+	if len(list) != 1 {
+		t.Fatalf("expected 1 stationary after remove, got: %+v", list)
+	}
+
+	err = d.StationaryClear()
+	h.FatalIfErr(t, err)
+	list, err = d.StationaryList()
+	h.FatalIfErr(t, err)
+	if len(list) != 0 {
+		t.Fatalf("expected no stationary after clear, got: %+v", list)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	d, cleanup := getDS(t)
+	defer cleanup()
+
+	base := time.Unix(1500000000, 0)
+	entries := []datastore.ArchiveEntry{
+		{Time: base, Dir: "a", Labels: []string{"person"}, Boxes: 1, Bytes: 100},
+		{Time: base.Add(time.Hour), Dir: "b", Labels: []string{"car", "person"}, Boxes: 2, Bytes: 200},
+		{Time: base.Add(2 * time.Hour), Dir: "c", Labels: []string{"car"}, Boxes: 1, Bytes: 300},
+	}
+	for _, e := range entries {
+		_, err := d.ArchiveAdd(e)
+		h.FatalIfErr(t, err)
+	}
+
+	list, err := d.ArchiveList(datastore.ArchiveQuery{Label: "person"})
+	h.FatalIfErr(t, err)
+	if len(list) != 2 || list[0].Dir != "b" || list[1].Dir != "a" || len(list[0].Labels) != 2 {
+		t.Fatalf("want person entries newest first, got: %+v", list)
+	}
+	list, err = d.ArchiveList(datastore.ArchiveQuery{From: base.Add(time.Hour), Limit: 1})
+	h.FatalIfErr(t, err)
+	if len(list) != 1 || list[0].Dir != "c" || !list[0].Time.Equal(base.Add(2*time.Hour)) {
+		t.Fatalf("want the newest entry only, got: %+v", list)
+	}
+
+	count, size, err := d.ArchiveSize()
+	h.FatalIfErr(t, err)
+	if count != 3 || size != 600 {
+		t.Fatalf("want 3 entries of 600 bytes, got: %d, %d", count, size)
+	}
+	oldest, err := d.ArchiveOldest(1)
+	h.FatalIfErr(t, err)
+	if len(oldest) != 1 || oldest[0].Dir != "a" {
+		t.Fatalf("want the oldest entry, got: %+v", oldest)
+	}
+	h.FatalIfErr(t, d.ArchiveRemove(oldest[0].ID))
+	count, size, err = d.ArchiveSize()
+	h.FatalIfErr(t, err)
+	if count != 2 || size != 500 {
+		t.Fatalf("want 2 entries of 500 bytes after remove, got: %d, %d", count, size)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	d, cleanup := getDS(t)
+	defer cleanup()
+
+	base := time.Date(2018, 9, 5, 22, 15, 0, 0, time.Local)
+	err := d.EventAdd(
+		datastore.Event{Camera: "driveway", Time: base, Label: "person", Confidence: 80, Rect: image.Rect(1, 2, 3, 4), Status: datastore.EventSent, Archive: "2018/09/05/221500.000"},
+		datastore.Event{Camera: "driveway", Time: base.Add(10 * time.Minute), Label: "person", Confidence: 40, Status: datastore.EventDropped},
+		datastore.Event{Camera: "driveway", Time: base.Add(time.Hour), Label: "car", Confidence: 30, Status: datastore.EventRejected, Reason: "too small"},
+		datastore.Event{Camera: "garden", Time: base.Add(2 * time.Hour), Label: "person", Confidence: 90, Status: datastore.EventSent},
+	)
+	h.FatalIfErr(t, err)
+
+	list, err := d.EventList(datastore.EventQuery{Camera: "driveway", Label: "person"})
+	h.FatalIfErr(t, err)
+	if len(list) != 2 || list[0].Status != datastore.EventDropped || list[1].Rect != image.Rect(1, 2, 3, 4) || list[1].Archive == "" {
+		t.Fatalf("unexpected driveway person events: %+v", list)
+	}
+	list, err = d.EventList(datastore.EventQuery{From: base.Add(30 * time.Minute), To: base.Add(90 * time.Minute)})
+	h.FatalIfErr(t, err)
+	if len(list) != 1 || list[0].Reason != "too small" || !list[0].Time.Equal(base.Add(time.Hour)) {
+		t.Fatalf("unexpected events in range: %+v", list)
+	}
+
+	hourly, err := d.EventCounts(datastore.EventQuery{Status: datastore.EventSent}, datastore.Hourly)
+	h.FatalIfErr(t, err)
+	if len(hourly) != 2 || !hourly[0].Start.Equal(time.Date(2018, 9, 5, 22, 0, 0, 0, time.Local)) || hourly[1].Count != 1 {
+		t.Fatalf("unexpected hourly counts: %+v", hourly)
+	}
+	daily, err := d.EventCounts(datastore.EventQuery{Label: "person"}, datastore.Daily)
+	h.FatalIfErr(t, err)
+	want := []datastore.EventCount{
+		{Start: time.Date(2018, 9, 5, 0, 0, 0, 0, time.Local), Label: "person", Status: datastore.EventDropped, Count: 1},
+		{Start: time.Date(2018, 9, 5, 0, 0, 0, 0, time.Local), Label: "person", Status: datastore.EventSent, Count: 1},
+		{Start: time.Date(2018, 9, 6, 0, 0, 0, 0, time.Local), Label: "person", Status: datastore.EventSent, Count: 1},
+	}
+	if len(daily) != len(want) {
+		t.Fatalf("want daily counts %+v, got: %+v", want, daily)
+	}
+	for i := range want {
+		if !daily[i].Start.Equal(want[i].Start) || daily[i].Status != want[i].Status || daily[i].Count != want[i].Count {
+			t.Fatalf("want daily counts %+v, got: %+v", want, daily)
+		}
+	}
+
+	n, err := d.EventPrune(base.Add(90 * time.Minute))
+	h.FatalIfErr(t, err)
+	list, err = d.EventList(datastore.EventQuery{})
+	h.FatalIfErr(t, err)
+	if n != 3 || len(list) != 1 || list[0].Camera != "garden" {
+		t.Fatalf("want 3 events pruned leaving the garden one, got: %d, %+v", n, list)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "test-dbs")
+	h.FatalIfErr(t, err)
+	defer os.Remove(tmpfile.Name())
+
+	// A database from before migrations, with a schedule set.
+	db, err := sql.Open("sqlite3", tmpfile.Name())
+	h.FatalIfErr(t, err)
+	_, err = db.Exec(`CREATE TABLE upload_sched (dayhour TEXT NOT NULL PRIMARY KEY);`)
+	h.FatalIfErr(t, err)
+	_, err = db.Exec(`INSERT INTO upload_sched (dayhour) VALUES ('Monday-9'), ('Monday-10'), ('Friday-23'), ('Saturday-0');`)
+	h.FatalIfErr(t, err)
+	db.Close()
+
+	d, err := datastore.New(datastore.Config{Filename: tmpfile.Name()})
+	h.FatalIfErr(t, err)
+	version, err := d.SchemaVersion()
+	h.FatalIfErr(t, err)
+	if version != datastore.LatestSchemaVersion() {
+		t.Fatalf("want version %d, got: %d", datastore.LatestSchemaVersion(), version)
+	}
+	ranges, err := d.SchedRanges(datastore.UploadSched)
+	h.FatalIfErr(t, err)
+	if fmt.Sprint(ranges) != "[mon 09:00-11:00 fri 23:00-01:00]" {
+		t.Fatalf("want the hour cells migrated to ranges, got: %v", ranges)
+	}
+	h.FatalIfErr(t, d.SchedActivate(datastore.UploadSched, "tue-10"))
+	d.Close()
+
+	// Opening again runs nothing, and keeps the data.
+	d, err = datastore.New(datastore.Config{Filename: tmpfile.Name()})
+	h.FatalIfErr(t, err)
+	version, err = d.SchemaVersion()
+	h.FatalIfErr(t, err)
+	if version != datastore.LatestSchemaVersion() {
+		t.Fatalf("want version %d after reopening, got: %d", datastore.LatestSchemaVersion(), version)
+	}
+	ranges, err = d.SchedRanges(datastore.UploadSched)
+	h.FatalIfErr(t, err)
+	if len(ranges) != 3 {
+		t.Fatalf("want the ranges kept, got: %v", ranges)
+	}
+	d.Close()
+	db, err = sql.Open("sqlite3", tmpfile.Name())
+	h.FatalIfErr(t, err)
+
+	// A newer build has migrated it.
+	_, err = db.Exec("INSERT INTO schema_version (version, name, applied) VALUES (?, 'future', 0)", datastore.LatestSchemaVersion()+1)
+	h.FatalIfErr(t, err)
+	db.Close()
+	_, err = datastore.New(datastore.Config{Filename: tmpfile.Name()})
+	if err == nil {
+		t.Fatal("want error for a newer schema version")
+	}
+}
+
+func TestNewError(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "test-dbs")
+	h.FatalIfErr(t, err)
+	defer os.Remove(tmpfile.Name())
+	_, err = tmpfile.WriteString("this is not a database, but long enough to look like a header to sqlite")
+	h.FatalIfErr(t, err)
+	tmpfile.Close()
+
+	_, err = datastore.New(datastore.Config{Filename: tmpfile.Name()})
+	if err == nil {
+		t.Fatal("want error opening a file that isn't a database")
+	}
+}
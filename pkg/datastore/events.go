@@ -0,0 +1,203 @@
+package datastore
+
+import (
+	"image"
+	"sort"
+	"time"
+)
+
+const (
+	EventSent     = "sent"
+	EventDropped  = "dropped"
+	EventRejected = "rejected"
+	EventSkipped  = "skipped"
+)
+
+// Event is one detection, what happened to it and why.
+type Event struct {
+	ID         int64
+	Camera     string
+	Time       time.Time
+	Label      string
+	Confidence int
+	Rect       image.Rectangle
+	TrackID    int
+
+	// One of the Event* statuses, with the reject or skip reason.
+	Status string
+	Reason string
+
+	// Directory of the hit in the archive, if it was archived.
+	Archive string
+}
+
+// EventQuery selects events, zero values match everything.
+type EventQuery struct {
+	From   time.Time
+	To     time.Time
+	Camera string
+	Label  string
+	Status string
+	Limit  int
+}
+
+type EventPeriod int8
+
+const (
+	Hourly EventPeriod = iota
+	Daily
+)
+
+// EventCount is the number of events of a label and status in one period.
+type EventCount struct {
+	Start  time.Time
+	Label  string
+	Status string
+	Count  int
+}
+
+// EventAdd stores the events in one transaction.
+func (s *Store) EventAdd(events ...Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO events (camera, time, label, confidence, x1, y1, x2, y2, track, status, reason, archive) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range events {
+		r := e.Rect
+		_, err = stmt.Exec(e.Camera, e.Time.UnixNano(), e.Label, e.Confidence, r.Min.X, r.Min.Y, r.Max.X, r.Max.Y, e.TrackID, e.Status, e.Reason, e.Archive)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// EventPrune deletes the events from before the time, and returns how many it deleted.
+func (s *Store) EventPrune(before time.Time) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res, err := s.db.Exec("DELETE FROM events WHERE time < ?", before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// EventList returns the matching events, newest first.
+func (s *Store) EventList(q EventQuery) ([]Event, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	where, args := q.where()
+	query := "SELECT id, camera, time, label, confidence, x1, y1, x2, y2, track, status, reason, archive FROM events" + where + " ORDER BY time DESC, id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []Event{}
+	for rows.Next() {
+		var e Event
+		var t int64
+		err = rows.Scan(&e.ID, &e.Camera, &t, &e.Label, &e.Confidence, &e.Rect.Min.X, &e.Rect.Min.Y, &e.Rect.Max.X, &e.Rect.Max.Y, &e.TrackID, &e.Status, &e.Reason, &e.Archive)
+		if err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(0, t)
+		ret = append(ret, e)
+	}
+	return ret, rows.Err()
+}
+
+// EventCounts returns the number of matching events per period, label and status, in
+// the store's time zone, oldest period first.
+func (s *Store) EventCounts(q EventQuery, period EventPeriod) ([]EventCount, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// SQLite only knows UTC and the system zone, so count per quarter hour, and add
+	// those up into local periods. Zones are offset by whole quarter hours.
+	where, args := q.where()
+	query := "SELECT time / 900000000000 AS quarter, label, status, COUNT(*) FROM events" + where + " GROUP BY quarter, label, status"
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[EventCount]int{}
+	for rows.Next() {
+		var c EventCount
+		var quarter int64
+		var count int
+		err = rows.Scan(&quarter, &c.Label, &c.Status, &count)
+		if err != nil {
+			return nil, err
+		}
+		t := time.Unix(quarter*900, 0).In(s.loc)
+		if period == Daily {
+			c.Start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+		} else {
+			c.Start = t.Truncate(time.Hour)
+			if _, offset := t.Zone(); offset%3600 != 0 {
+				c.Start = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, s.loc)
+			}
+		}
+		counts[c] += count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	ret := []EventCount{}
+	for c, count := range counts {
+		c.Count = count
+		ret = append(ret, c)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if !ret[i].Start.Equal(ret[j].Start) {
+			return ret[i].Start.Before(ret[j].Start)
+		}
+		if ret[i].Label != ret[j].Label {
+			return ret[i].Label < ret[j].Label
+		}
+		return ret[i].Status < ret[j].Status
+	})
+	return ret, nil
+}
+
+func (q EventQuery) where() (string, []interface{}) {
+	where := " WHERE 1=1"
+	args := []interface{}{}
+	if !q.From.IsZero() {
+		where += " AND time >= ?"
+		args = append(args, q.From.UnixNano())
+	}
+	if !q.To.IsZero() {
+		where += " AND time < ?"
+		args = append(args, q.To.UnixNano())
+	}
+	if q.Camera != "" {
+		where += " AND camera = ?"
+		args = append(args, q.Camera)
+	}
+	if q.Label != "" {
+		where += " AND label = ?"
+		args = append(args, q.Label)
+	}
+	if q.Status != "" {
+		where += " AND status = ?"
+		args = append(args, q.Status)
+	}
+	return where, args
+}
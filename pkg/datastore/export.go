@@ -0,0 +1,275 @@
+package datastore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Export is a store's schedules, by name, as YAML or JSON. Ranges, sun rules and
+// exceptions are written the way the bot commands take them, so that the file can be
+// edited by hand.
+type Export struct {
+	// Time zone the schedules were exported from. Their times are wall clock times, so
+	// they're imported as they are, whatever the zone of the store.
+	TimeZone  string                     `json:"time_zone,omitempty" yaml:"time_zone,omitempty"`
+	Schedules map[string]*ScheduleExport `json:"schedules" yaml:"schedules"`
+}
+
+type ScheduleExport struct {
+	Mode       string          `json:"mode" yaml:"mode"`
+	Override   *OverrideExport `json:"override,omitempty" yaml:"override,omitempty"`
+	Ranges     []string        `json:"ranges,omitempty" yaml:"ranges,omitempty"`
+	SunRules   []string        `json:"sun_rules,omitempty" yaml:"sun_rules,omitempty"`
+	Exceptions []string        `json:"exceptions,omitempty" yaml:"exceptions,omitempty"`
+}
+
+type OverrideExport struct {
+	Mode  string `json:"mode" yaml:"mode"`
+	Until string `json:"until" yaml:"until"`
+}
+
+// YAML formats the export as YAML.
+func (e *Export) YAML() ([]byte, error) {
+	return yaml.Marshal(e)
+}
+
+// JSON formats the export as indented JSON.
+func (e *Export) JSON() ([]byte, error) {
+	return json.MarshalIndent(e, "", "  ")
+}
+
+// Names returns the names of the exported schedules, sorted.
+func (e *Export) Names() []string {
+	names := []string{}
+	for name := range e.Schedules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseExport reads an export from YAML or JSON, JSON being YAML as well.
+func ParseExport(data []byte) (*Export, error) {
+	e := &Export{}
+	err := yaml.Unmarshal(data, e)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid export: %s", err)
+	}
+	if len(e.Schedules) == 0 {
+		return nil, errors.New("Invalid export: no schedules")
+	}
+	return e, nil
+}
+
+// export reads the schedule as of the time: its ranges, sun rules, the exceptions that
+// haven't ended and the override if there is one.
+func (s *Schedule) export(now time.Time) (*ScheduleExport, error) {
+	now = now.In(s.location())
+	mode, err := s.GetMode()
+	if err != nil {
+		return nil, err
+	}
+	se := &ScheduleExport{Mode: strings.ToLower(ModeStr(mode))}
+	o, err := s.GetOverride(now)
+	if err != nil {
+		return nil, err
+	}
+	if o != nil {
+		se.Override = &OverrideExport{Mode: strings.ToLower(ModeStr(o.Mode)), Until: o.Until.Format(time.RFC3339)}
+	}
+	ranges, err := s.Ranges()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range ranges {
+		se.Ranges = append(se.Ranges, r.String())
+	}
+	rules, err := s.SunRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		se.SunRules = append(se.SunRules, r.String())
+	}
+	exceptions, err := s.Exceptions(now.Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range exceptions {
+		se.Exceptions = append(se.Exceptions, e.Spec())
+	}
+	return se, nil
+}
+
+// parsedSchedule is a ScheduleExport that has been checked, ready to be written.
+type parsedSchedule struct {
+	mode       ScheduleMode
+	override   *Override
+	ranges     []Range
+	sunRules   []SunRule
+	exceptions []Exception
+}
+
+// parse checks the exported schedule, dropping an override that has already ended. A
+// schedule without a mode is scheduled.
+func (se *ScheduleExport) parse(now time.Time) (*parsedSchedule, error) {
+	p := &parsedSchedule{mode: ModeSched}
+	if se.Mode != "" {
+		p.mode = StrMode(se.Mode)
+	}
+	if p.mode == ModeInvalid {
+		return nil, fmt.Errorf("Invalid mode: %s", se.Mode)
+	}
+	if se.Override != nil {
+		o := &Override{Mode: StrMode(se.Override.Mode)}
+		if o.Mode == ModeInvalid {
+			return nil, fmt.Errorf("Invalid override mode: %s", se.Override.Mode)
+		}
+		until, err := time.Parse(time.RFC3339, se.Override.Until)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid override end: %s, e.g. 2026-11-02T07:00:00Z", se.Override.Until)
+		}
+		o.Until = until
+		if o.Until.After(now) {
+			p.override = o
+		}
+	}
+	w := &week{}
+	for _, spec := range se.Ranges {
+		r, err := ParseRange(spec)
+		if err != nil {
+			return nil, err
+		}
+		w.set(r, true)
+	}
+	if len(se.Ranges) > 0 {
+		p.ranges = w.ranges()
+	}
+	for _, spec := range se.SunRules {
+		r, err := ParseSunRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		p.sunRules = append(p.sunRules, r)
+	}
+	for _, spec := range se.Exceptions {
+		e, err := ParseException(spec)
+		if err != nil {
+			return nil, err
+		}
+		p.exceptions = append(p.exceptions, e)
+	}
+	return p, nil
+}
+
+// replace writes the schedule over whatever it had.
+func (s *Schedule) replace(tx *sql.Tx, p *parsedSchedule) error {
+	if len(p.sunRules) > 0 && s.Coords == nil {
+		return errors.New("Sun rules need the camera's latitude and longitude to be configured")
+	}
+	for _, table := range []string{"sched_sun", "sched_exception", "sched_override"} {
+		_, err := tx.Exec("DELETE FROM "+table+" WHERE sched = $1", s.Table)
+		if err != nil {
+			return err
+		}
+	}
+	err := setRanges(tx, s.Table, p.ranges)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO sched_mode (sched, mode) VALUES ($1, $2)", s.Table, ModeStr(p.mode))
+	if err != nil {
+		return err
+	}
+	if p.override != nil {
+		_, err = tx.Exec("INSERT INTO sched_override (sched, mode, until) VALUES ($1, $2, $3)", s.Table, ModeStr(p.override.Mode), p.override.Until.UnixNano())
+		if err != nil {
+			return err
+		}
+	}
+	for _, r := range p.sunRules {
+		_, err = tx.Exec("INSERT INTO sched_sun (sched, days, from_event, from_offset, to_event, to_offset) VALUES ($1, $2, $3, $4, $5, $6)", s.Table, r.Days, r.From.Event, int64(r.From.Offset), r.To.Event, int64(r.To.Offset))
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range p.exceptions {
+		_, err = tx.Exec("INSERT INTO sched_exception (sched, from_date, to_date, start_min, end_min, active, note) VALUES ($1, $2, $3, $4, $5, $6, $7)", s.Table, e.From, e.To, e.Start, e.End, e.Active, e.Note)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchedExport exports the schedules, all of them if none are given.
+func (s *Store) SchedExport(scheds ...ScheduleName) (*Export, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(scheds) == 0 {
+		scheds = Schedules
+	}
+	e := &Export{TimeZone: s.loc.String(), Schedules: map[string]*ScheduleExport{}}
+	now := time.Now()
+	for _, sched := range scheds {
+		se, err := s.schedules[sched].export(now)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", sched, err)
+		}
+		e.Schedules[sched.String()] = se
+	}
+	return e, nil
+}
+
+// SchedImport replaces the schedules in the export, and leaves the others as they are.
+// Nothing is written unless all of them can be.
+func (s *Store) SchedImport(e *Export) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	parsed := map[ScheduleName]*parsedSchedule{}
+	for _, name := range e.Names() {
+		sched, ok := StrScheduleName(name)
+		if !ok {
+			return fmt.Errorf("Unknown schedule: %s", name)
+		}
+		se := e.Schedules[name]
+		if se == nil {
+			return fmt.Errorf("%s: empty schedule", name)
+		}
+		p, err := se.parse(now)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		parsed[sched] = p
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for sched, p := range parsed {
+		err = s.schedules[sched].replace(tx, p)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %s", sched, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// SchedCopy replaces the schedules with those of the other store, all of them if none
+// are given.
+func (s *Store) SchedCopy(from *Store, scheds ...ScheduleName) error {
+	e, err := from.SchedExport(scheds...)
+	if err != nil {
+		return err
+	}
+	return s.SchedImport(e)
+}
@@ -0,0 +1,182 @@
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migration upgrades the schema by one version.
+type migration struct {
+	name string
+	up   []string
+
+	// Run after the statements, for changes that SQL alone can't make.
+	fn func(tx *sql.Tx) error
+}
+
+// migrations are run in order at startup, each once and in its own transaction. The
+// version of a database is the number that have been run. Never change one that has
+// been released, append another instead.
+//
+// Databases from before schema_version existed already have some of the first tables,
+// so those are created only if they don't exist.
+var migrations = []migration{
+	{
+		name: "schedule",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS upload_sched (dayhour TEXT NOT NULL PRIMARY KEY);`,
+			`CREATE TABLE IF NOT EXISTS sched_mode (sched TEXT NOT NULL PRIMARY KEY, mode TEXT NOT NULL);`,
+		},
+	},
+	{
+		name: "stationary",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS stationary (id INTEGER PRIMARY KEY, label TEXT NOT NULL, x1 INTEGER NOT NULL, y1 INTEGER NOT NULL, x2 INTEGER NOT NULL, y2 INTEGER NOT NULL, since INTEGER NOT NULL);`,
+		},
+	},
+	{
+		name: "archive",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS archive (id INTEGER PRIMARY KEY, time INTEGER NOT NULL, dir TEXT NOT NULL, labels TEXT NOT NULL, boxes INTEGER NOT NULL, bytes INTEGER NOT NULL);`,
+			`CREATE INDEX IF NOT EXISTS archive_time ON archive (time);`,
+		},
+	},
+	{
+		name: "events",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS events (id INTEGER PRIMARY KEY, camera TEXT NOT NULL, time INTEGER NOT NULL, label TEXT NOT NULL, confidence INTEGER NOT NULL, x1 INTEGER NOT NULL, y1 INTEGER NOT NULL, x2 INTEGER NOT NULL, y2 INTEGER NOT NULL, track INTEGER NOT NULL, status TEXT NOT NULL, reason TEXT NOT NULL, archive TEXT NOT NULL);`,
+			`CREATE INDEX IF NOT EXISTS events_time ON events (time);`,
+		},
+	},
+	{
+		name: "schedule ranges",
+		up: []string{
+			`CREATE TABLE sched_range (id INTEGER PRIMARY KEY, sched TEXT NOT NULL, days INTEGER NOT NULL, start_min INTEGER NOT NULL, end_min INTEGER NOT NULL);`,
+		},
+		fn: migrateHourCells,
+	},
+	{
+		name: "schedule overrides",
+		up: []string{
+			`CREATE TABLE sched_override (sched TEXT NOT NULL PRIMARY KEY, mode TEXT NOT NULL, until INTEGER NOT NULL);`,
+			`CREATE TABLE sched_exception (id INTEGER PRIMARY KEY, sched TEXT NOT NULL, from_date TEXT NOT NULL, to_date TEXT NOT NULL, start_min INTEGER NOT NULL, end_min INTEGER NOT NULL, active INTEGER NOT NULL, note TEXT NOT NULL);`,
+		},
+	},
+	{
+		name: "sun rules",
+		up: []string{
+			`CREATE TABLE sched_sun (id INTEGER PRIMARY KEY, sched TEXT NOT NULL, days INTEGER NOT NULL, from_event TEXT NOT NULL, from_offset INTEGER NOT NULL, to_event TEXT NOT NULL, to_offset INTEGER NOT NULL);`,
+		},
+	},
+}
+
+// LatestSchemaVersion is the version that this build migrates databases to.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL, applied INTEGER NOT NULL);`)
+	if err != nil {
+		return err
+	}
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		m := migrations[i]
+		log.Infof("migrating schema to version %d: %s", i+1, m.name)
+		err = runMigration(db, i+1, m)
+		if err != nil {
+			return fmt.Errorf("schema migration %d (%s): %s", i+1, m.name, err)
+		}
+	}
+	return nil
+}
+
+func runMigration(db *sql.DB, version int, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range m.up {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if m.fn != nil {
+		err = m.fn(tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec("INSERT INTO schema_version (version, name, applied) VALUES (?, ?, ?)", version, m.name, time.Now().Unix())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateHourCells turns the Monday-13 cells of the upload_sched grid into ranges, and
+// drops it.
+func migrateHourCells(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT dayhour FROM upload_sched")
+	if err != nil {
+		return err
+	}
+	w := &week{}
+	for rows.Next() {
+		var dayhour string
+		err = rows.Scan(&dayhour)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		split := strings.Split(dayhour, "-")
+		hour, err := strconv.Atoi(split[len(split)-1])
+		if len(split) != 2 || err != nil || hour < 0 || hour > 23 {
+			log.Warnf("skipping invalid schedule cell: %s", dayhour)
+			continue
+		}
+		for _, day := range Weekdays {
+			if day.String() == split[0] {
+				w.set(Range{Days: Days(0).With(day), Start: hour * 60, End: hour*60 + 60}, true)
+			}
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	err = setRanges(tx, "upload_sched", w.ranges())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DROP TABLE upload_sched")
+	return err
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// SchemaVersion returns the version the database has been migrated to.
+func (s *Store) SchemaVersion() (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return schemaVersion(s.db)
+}
@@ -0,0 +1,273 @@
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Override is a mode that applies until a time, after which the schedule goes back to
+// its own mode.
+type Override struct {
+	Mode  ScheduleMode
+	Until time.Time
+}
+
+func (o *Override) String() string {
+	return fmt.Sprintf("%s until %s", ModeStr(o.Mode), o.Until.Format("2006-01-02 15:04 MST"))
+}
+
+// ParseOverride parses a timed mode, e.g. on for 3h, off for 2d or
+// off until 2026-11-02 07:00, in now's location.
+func ParseOverride(spec string, now time.Time) (Override, error) {
+	fields := strings.Fields(strings.ToLower(spec))
+	if len(fields) < 3 {
+		return Override{}, fmt.Errorf("Invalid override: %s, e.g. on for 3h", spec)
+	}
+	o := Override{Mode: StrMode(fields[0])}
+	if o.Mode == ModeInvalid {
+		return Override{}, fmt.Errorf("Invalid mode: %s", fields[0])
+	}
+	switch fields[1] {
+	case "for":
+		if len(fields) != 3 {
+			return Override{}, fmt.Errorf("Invalid duration: %s", strings.Join(fields[2:], " "))
+		}
+		d, err := parseDuration(fields[2])
+		if err != nil {
+			return Override{}, err
+		}
+		o.Until = now.Add(d)
+	case "until":
+		until, err := parseDateTime(fields[2:], now.Location())
+		if err != nil {
+			return Override{}, err
+		}
+		o.Until = until
+	default:
+		return Override{}, fmt.Errorf("Invalid override: %s, e.g. on for 3h", spec)
+	}
+	if !o.Until.After(now) {
+		return Override{}, fmt.Errorf("Override would end in the past: %s", o.Until.Format("2006-01-02 15:04"))
+	}
+	return o, nil
+}
+
+// parseDuration parses a time.Duration, or a number of days such as 2d.
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("Invalid duration: %s", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid duration: %s", s)
+	}
+	return d, nil
+}
+
+// parseDateTime parses a date and optional time, e.g. 2026-11-02 07:00, as midnight if
+// there is no time.
+func parseDateTime(fields []string, loc *time.Location) (time.Time, error) {
+	switch len(fields) {
+	case 1:
+		t, err := time.ParseInLocation(dateLayout, fields[0], loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Invalid date: %s, e.g. 2026-11-02", fields[0])
+		}
+		return t, nil
+	case 2:
+		t, err := time.ParseInLocation("2006-01-02 15:04", fields[0]+" "+fields[1], loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Invalid date and time: %s %s, e.g. 2026-11-02 07:00", fields[0], fields[1])
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid date and time: %s", strings.Join(fields, " "))
+}
+
+func (s *Schedule) SetOverride(o Override) error {
+	_, err := s.Db.Exec("INSERT OR REPLACE INTO sched_override (sched, mode, until) VALUES ($1, $2, $3)", s.Table, ModeStr(o.Mode), o.Until.UnixNano())
+	return err
+}
+
+// GetOverride returns the override in effect at the time, nil if there is none.
+func (s *Schedule) GetOverride(t time.Time) (*Override, error) {
+	var modeStr string
+	var until int64
+	err := s.Db.QueryRow("SELECT mode, until FROM sched_override WHERE sched = $1", s.Table).Scan(&modeStr, &until)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	o := &Override{Mode: StrMode(modeStr), Until: time.Unix(0, until).In(s.location())}
+	if !o.Until.After(t) {
+		return nil, nil
+	}
+	return o, nil
+}
+
+func (s *Schedule) ClearOverride() error {
+	_, err := s.Db.Exec("DELETE FROM sched_override WHERE sched = $1", s.Table)
+	return err
+}
+
+// Exception turns the schedule on or off for dates, such as holidays, whatever the
+// weekly ranges say. Dates are written as 2006-01-02, so that they're read in the
+// schedule's time zone.
+type Exception struct {
+	ID     int64
+	From   string
+	To     string
+	Start  int
+	End    int
+	Active bool
+	Note   string
+}
+
+// Covers returns true if the exception applies to the minute of t, in t's location.
+func (e Exception) Covers(t time.Time) bool {
+	date := t.Format(dateLayout)
+	if date < e.From || date > e.To {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	return m >= e.Start && m < e.End
+}
+
+func (e Exception) String() string {
+	return fmt.Sprintf("%d: %s", e.ID, e.Spec())
+}
+
+// Spec formats the exception the way ParseException reads it.
+func (e Exception) Spec() string {
+	state := "off"
+	if e.Active {
+		state = "on"
+	}
+	dates := e.From
+	if e.To != e.From {
+		dates += ".." + e.To
+	}
+	out := fmt.Sprintf("%s %s", state, dates)
+	if e.Start != 0 || e.End != minutesPerDay {
+		out += fmt.Sprintf(" %s-%s", clockStr(e.Start), clockStr(e.End))
+	}
+	if e.Note != "" {
+		out += " " + e.Note
+	}
+	return out
+}
+
+// ParseException parses <on|off> <date[..date]> [HH:MM-HH:MM] [note], e.g.
+// off 2026-12-24..2026-12-26 christmas, or on 2026-12-31 18:00-24:00.
+func ParseException(spec string) (Exception, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 {
+		return Exception{}, errors.New("Invalid exception, e.g. off 2026-12-24..2026-12-26 christmas")
+	}
+	e := Exception{Start: 0, End: minutesPerDay}
+	switch StrMode(fields[0]) {
+	case ModeOn:
+		e.Active = true
+	case ModeOff:
+	default:
+		return Exception{}, fmt.Errorf("Invalid exception state: %s, must be on or off", fields[0])
+	}
+	dates := strings.Split(fields[1], "..")
+	if len(dates) > 2 {
+		return Exception{}, fmt.Errorf("Invalid dates: %s", fields[1])
+	}
+	for _, d := range dates {
+		_, err := time.Parse(dateLayout, d)
+		if err != nil {
+			return Exception{}, fmt.Errorf("Invalid date: %s, e.g. 2026-12-24", d)
+		}
+	}
+	e.From, e.To = dates[0], dates[len(dates)-1]
+	if e.To < e.From {
+		return Exception{}, fmt.Errorf("Invalid dates: %s ends before it starts", fields[1])
+	}
+	rest := fields[2:]
+	if len(rest) > 0 && strings.Contains(rest[0], ":") {
+		start, end, err := parseTimes(rest[0])
+		if err != nil {
+			return Exception{}, err
+		}
+		if end < start {
+			return Exception{}, fmt.Errorf("Exception times can't cross midnight: %s", rest[0])
+		}
+		e.Start, e.End = start, end
+		rest = rest[1:]
+	}
+	e.Note = strings.Join(rest, " ")
+	return e, nil
+}
+
+func (s *Schedule) AddException(e Exception) (int64, error) {
+	result, err := s.Db.Exec("INSERT INTO sched_exception (sched, from_date, to_date, start_min, end_min, active, note) VALUES ($1, $2, $3, $4, $5, $6, $7)", s.Table, e.From, e.To, e.Start, e.End, e.Active, e.Note)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Exceptions returns the exceptions that haven't ended before the date, by date.
+func (s *Schedule) Exceptions(since string) ([]Exception, error) {
+	rows, err := s.Db.Query("SELECT id, from_date, to_date, start_min, end_min, active, note FROM sched_exception WHERE sched = $1 AND to_date >= $2 ORDER BY from_date, start_min, id", s.Table, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []Exception{}
+	for rows.Next() {
+		var e Exception
+		err = rows.Scan(&e.ID, &e.From, &e.To, &e.Start, &e.End, &e.Active, &e.Note)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, e)
+	}
+	return ret, rows.Err()
+}
+
+func (s *Schedule) RemoveException(id int64) error {
+	result, err := s.Db.Exec("DELETE FROM sched_exception WHERE sched = $1 AND id = $2", s.Table, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("No exception with ID %d", id)
+	}
+	return nil
+}
+
+// exceptionAt returns the exception that applies at the time, the latest added if more
+// than one does, or nil.
+func (s *Schedule) exceptionAt(t time.Time) (*Exception, error) {
+	exceptions, err := s.Exceptions(t.Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+	var found *Exception
+	for i, e := range exceptions {
+		if e.Covers(t) && (found == nil || e.ID > found.ID) {
+			found = &exceptions[i]
+		}
+	}
+	return found, nil
+}
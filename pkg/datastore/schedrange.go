@@ -0,0 +1,302 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// Days is a set of weekdays, a bit per time.Weekday.
+type Days uint8
+
+const AllDays Days = 1<<7 - 1
+
+func (d Days) Has(day time.Weekday) bool {
+	return d&(1<<uint(day)) != 0
+}
+
+func (d Days) With(day time.Weekday) Days {
+	return d | 1<<uint(day)
+}
+
+// String lists the days Monday first, with runs written as a range, e.g. mon-fri+sun.
+func (d Days) String() string {
+	parts := []string{}
+	for i := 0; i < len(Weekdays); i++ {
+		if !d.Has(Weekdays[i]) {
+			continue
+		}
+		j := i
+		for j+1 < len(Weekdays) && d.Has(Weekdays[j+1]) {
+			j++
+		}
+		switch j - i {
+		case 0:
+			parts = append(parts, dayStr(Weekdays[i]))
+		case 1:
+			parts = append(parts, dayStr(Weekdays[i]), dayStr(Weekdays[j]))
+		default:
+			parts = append(parts, dayStr(Weekdays[i])+"-"+dayStr(Weekdays[j]))
+		}
+		i = j
+	}
+	return strings.Join(parts, "+")
+}
+
+func dayStr(day time.Weekday) string {
+	return strings.ToLower(day.String()[:3])
+}
+
+// ParseDays parses days joined by +, where each is a day, a range of days such as
+// mon-fri or fri-mon, or all.
+func ParseDays(s string) (Days, error) {
+	var days Days
+	for _, part := range strings.Split(strings.ToLower(s), "+") {
+		if part == "all" || part == "daily" {
+			days = AllDays
+			continue
+		}
+		split := strings.Split(part, "-")
+		if len(split) > 2 {
+			return 0, fmt.Errorf("Invalid days: %s", part)
+		}
+		from := StrToWeekday(split[0])
+		if from == nil {
+			return 0, fmt.Errorf("Invalid day: %s", split[0])
+		}
+		to := from
+		if len(split) == 2 {
+			to = StrToWeekday(split[1])
+			if to == nil {
+				return 0, fmt.Errorf("Invalid day: %s", split[1])
+			}
+		}
+		for day := *from; ; day = (day + 1) % 7 {
+			days = days.With(day)
+			if day == *to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// Range is a time of day on a set of days, in minutes from midnight. A range whose
+// End is before its Start crosses midnight, and ends on the following day.
+type Range struct {
+	Days  Days
+	Start int
+	End   int
+}
+
+// String formats the range the way ParseRange reads it, e.g. mon-fri 22:30-06:15.
+func (r Range) String() string {
+	return fmt.Sprintf("%s %s-%s", r.Days, clockStr(r.Start), clockStr(r.End))
+}
+
+func clockStr(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// Active returns true if the range covers the minute of t.
+func (r Range) Active(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if r.Start < r.End {
+		return r.Days.Has(t.Weekday()) && m >= r.Start && m < r.End
+	}
+	if m >= r.Start {
+		return r.Days.Has(t.Weekday())
+	}
+	return m < r.End && r.Days.Has((t.Weekday()+6)%7)
+}
+
+// ParseRange parses a schedule range. Formats:
+// mon-fri 22:30-06:15
+// sat 9-17
+// 22:00-23:30, every day
+// mon-fri, the whole day
+// mon-0, mon or 0, the hour cells of the old schedule grid
+func ParseRange(spec string) (Range, error) {
+	fields := strings.Fields(strings.ToLower(spec))
+	switch len(fields) {
+	case 0:
+		return Range{}, errors.New("No input given")
+	case 2:
+		days, err := ParseDays(fields[0])
+		if err != nil {
+			return Range{}, err
+		}
+		start, end, err := parseTimes(fields[1])
+		if err != nil {
+			return Range{}, err
+		}
+		return Range{Days: days, Start: start, End: end}, nil
+	case 1:
+		if strings.Contains(fields[0], ":") {
+			start, end, err := parseTimes(fields[0])
+			if err != nil {
+				return Range{}, err
+			}
+			return Range{Days: AllDays, Start: start, End: end}, nil
+		}
+		if days, err := ParseDays(fields[0]); err == nil {
+			return Range{Days: days, Start: 0, End: minutesPerDay}, nil
+		}
+		day, hour, err := ParseDayHour(fields[0])
+		if err != nil {
+			return Range{}, err
+		}
+		if hour < 0 || hour > 23 {
+			return Range{}, errors.New("Invalid hour, only 0-23 allowed")
+		}
+		r := Range{Days: AllDays, Start: hour * 60, End: hour*60 + 60}
+		if day != nil {
+			r.Days = Days(0).With(*day)
+		}
+		return r, nil
+	}
+	return Range{}, fmt.Errorf("Invalid range: %s", spec)
+}
+
+func parseTimes(s string) (int, int, error) {
+	split := strings.Split(s, "-")
+	if len(split) != 2 {
+		return 0, 0, fmt.Errorf("Invalid times: %s, e.g. 22:30-06:15", s)
+	}
+	start, err := parseClock(split[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(split[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == minutesPerDay {
+		return 0, 0, fmt.Errorf("Invalid start time: %s", split[0])
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("Empty range: %s", s)
+	}
+	return start, end, nil
+}
+
+// parseClock parses 6, 06:15 or 24:00 as minutes from midnight.
+func parseClock(s string) (int, error) {
+	split := strings.Split(s, ":")
+	if len(split) > 2 {
+		return 0, fmt.Errorf("Invalid time: %s", s)
+	}
+	hour, err := strconv.Atoi(split[0])
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time: %s", s)
+	}
+	minute := 0
+	if len(split) == 2 {
+		minute, err = strconv.Atoi(split[1])
+		if err != nil || len(split[1]) != 2 {
+			return 0, fmt.Errorf("Invalid time: %s", s)
+		}
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour > 24 || (hour == 24 && minute > 0) {
+		return 0, fmt.Errorf("Invalid time: %s", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// week is a minute by minute schedule, from Monday 00:00. Ranges are set and cleared on
+// it, and it's turned back into as few ranges as it can be.
+type week [7 * minutesPerDay]bool
+
+func weekIndex(day time.Weekday) int {
+	return (int(day) + 6) % 7
+}
+
+func (w *week) set(r Range, active bool) {
+	span := func(day, from, to int) {
+		for m := from; m < to; m++ {
+			w[(day*minutesPerDay+m)%len(w)] = active
+		}
+	}
+	for _, day := range Weekdays {
+		if !r.Days.Has(day) {
+			continue
+		}
+		i := weekIndex(day)
+		if r.Start < r.End {
+			span(i, r.Start, r.End)
+		} else {
+			span(i, r.Start, minutesPerDay+r.End)
+		}
+	}
+}
+
+func (w *week) ranges() []Range {
+	off := -1
+	for i, active := range w {
+		if !active {
+			off = i
+			break
+		}
+	}
+	if off == -1 {
+		return []Range{{Days: AllDays, Start: 0, End: minutesPerDay}}
+	}
+
+	// Runs on days, grouped by their times, starting from a minute that's off so that
+	// none is cut in two by the end of the week.
+	type times struct{ start, end int }
+	days := map[times]Days{}
+	add := func(day, start, end int) {
+		k := times{start, end}
+		days[k] = days[k].With(Weekdays[day%7])
+	}
+	for n := 0; n < len(w); {
+		i := (off + n) % len(w)
+		if !w[i] {
+			n++
+			continue
+		}
+		length := 0
+		for n < len(w) && w[(off+n)%len(w)] {
+			length++
+			n++
+		}
+		day, start := i/minutesPerDay, i%minutesPerDay
+		switch {
+		case start+length <= minutesPerDay:
+			add(day, start, start+length)
+		case length < minutesPerDay:
+			add(day, start, start+length-minutesPerDay)
+		default:
+			add(day, start, minutesPerDay)
+			rest := length - (minutesPerDay - start)
+			for day++; rest > 0; day++ {
+				end := minutesPerDay
+				if rest < end {
+					end = rest
+				}
+				add(day, 0, end)
+				rest -= end
+			}
+		}
+	}
+	ret := []Range{}
+	for k, d := range days {
+		ret = append(ret, Range{Days: d, Start: k.start, End: k.end})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Start != ret[j].Start {
+			return ret[i].Start < ret[j].Start
+		}
+		if ret[i].End != ret[j].End {
+			return ret[i].End < ret[j].End
+		}
+		return ret[i].Days < ret[j].Days
+	})
+	return ret
+}
@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/marktheunissen/watchbot/pkg/sun"
 )
 
 type ScheduleMode int
@@ -52,21 +54,31 @@ var Weekdays = []time.Weekday{
 	time.Sunday,
 }
 
-func TimeToDayHourString(t time.Time) string {
-	return DayHourToString(t.Weekday(), t.Hour())
-}
-
-func DayHourToString(day time.Weekday, hour int) string {
-	return fmt.Sprintf("%s-%d", day.String(), hour)
-}
-
+// Schedule is a set of weekly ranges and sun rules, stored under its Table name, and a
+// mode to override them.
 type Schedule struct {
 	Table string
 	Db    *sql.DB
+
+	// Where the sun rules are worked out for, nil if unknown.
+	Coords *sun.Coords
+
+	// Time zone the ranges, exceptions and sun rules are in, time.Local if nil.
+	Location *time.Location
+
+	// Mode of a schedule that hasn't been set, ModeSched if invalid.
+	DefaultMode ScheduleMode
+}
+
+func (s *Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
 }
 
 func (s *Schedule) DeactivateAll() error {
-	_, err := s.Db.Exec("DELETE FROM " + s.Table)
+	_, err := s.Db.Exec("DELETE FROM sched_range WHERE sched = $1", s.Table)
 	if err != nil {
 		return err
 	}
@@ -74,35 +86,84 @@ func (s *Schedule) DeactivateAll() error {
 }
 
 func (s *Schedule) ActivateAll() error {
-	for _, day := range Weekdays {
-		for hour := 0; hour < 24; hour++ {
-			err := s.SetActive(day, hour)
-			if err != nil {
-				return err
-			}
+	return s.SetRanges([]Range{{Days: AllDays, Start: 0, End: minutesPerDay}})
+}
+
+// Ranges returns the schedule's ranges, by start time.
+func (s *Schedule) Ranges() ([]Range, error) {
+	rows, err := s.Db.Query("SELECT days, start_min, end_min FROM sched_range WHERE sched = $1 ORDER BY start_min, end_min, days", s.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []Range{}
+	for rows.Next() {
+		var r Range
+		err = rows.Scan(&r.Days, &r.Start, &r.End)
+		if err != nil {
+			return nil, err
 		}
+		ret = append(ret, r)
 	}
-	return nil
+	return ret, rows.Err()
 }
 
-func (s *Schedule) SetActive(day time.Weekday, hour int) error {
-	_, err := s.Db.Exec("INSERT OR REPLACE INTO "+s.Table+" (dayhour) VALUES ($1)", DayHourToString(day, hour))
+// SetRanges replaces the schedule's ranges.
+func (s *Schedule) SetRanges(ranges []Range) error {
+	tx, err := s.Db.Begin()
 	if err != nil {
 		return err
 	}
-	return nil
+	err = setRanges(tx, s.Table, ranges)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
-func (s *Schedule) SetDeactive(day time.Weekday, hour int) error {
-	_, err := s.Db.Exec("DELETE FROM "+s.Table+" WHERE dayhour = ($1)", DayHourToString(day, hour))
+func setRanges(tx *sql.Tx, sched string, ranges []Range) error {
+	_, err := tx.Exec("DELETE FROM sched_range WHERE sched = $1", sched)
 	if err != nil {
 		return err
 	}
+	for _, r := range ranges {
+		_, err = tx.Exec("INSERT INTO sched_range (sched, days, start_min, end_min) VALUES ($1, $2, $3, $4)", sched, r.Days, r.Start, r.End)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// update turns the range on or off, merged with the existing ranges.
+func (s *Schedule) update(r Range, active bool) error {
+	ranges, err := s.Ranges()
+	if err != nil {
+		return err
+	}
+	w := &week{}
+	for _, existing := range ranges {
+		w.set(existing, true)
+	}
+	w.set(r, active)
+	return s.SetRanges(w.ranges())
+}
+
 func (s *Schedule) IsActiveNow() (bool, error) {
-	sm, err := s.GetMode()
+	return s.IsActiveAt(time.Now())
+}
+
+// IsActiveAt evaluates the schedule at the time. A timed override wins over the mode,
+// and when scheduled, a date exception wins over the weekly ranges and sun rules, either
+// of which turns it on.
+//
+// Times are the wall clock in the schedule's zone. On the night the clocks go forward
+// the skipped times never come, so a range within them is off, and when they go back
+// the repeated times are evaluated the same both times round.
+func (s *Schedule) IsActiveAt(t time.Time) (bool, error) {
+	t = t.In(s.location())
+	sm, err := s.ModeAt(t)
 	if err != nil {
 		return false, err
 	}
@@ -112,29 +173,42 @@ func (s *Schedule) IsActiveNow() (bool, error) {
 	if sm == ModeOff {
 		return false, nil
 	}
-	return s.IsActiveTime(time.Now())
+	e, err := s.exceptionAt(t)
+	if err != nil {
+		return false, err
+	}
+	if e != nil {
+		return e.Active, nil
+	}
+	active, err := s.IsActiveTime(t)
+	if err != nil || active {
+		return active, err
+	}
+	return s.sunActive(t)
 }
 
-func (s *Schedule) IsActiveTime(t time.Time) (bool, error) {
-	var count int
-	err := s.Db.QueryRow("SELECT count(*) FROM "+s.Table+" WHERE dayhour = $1", TimeToDayHourString(t)).Scan(&count)
+// ModeAt returns the mode of the override in effect at the time, or else the mode.
+func (s *Schedule) ModeAt(t time.Time) (ScheduleMode, error) {
+	o, err := s.GetOverride(t)
 	if err != nil {
-		return false, err
+		return ModeInvalid, err
 	}
-	if count > 0 {
-		return true, nil
+	if o != nil {
+		return o.Mode, nil
 	}
-	return false, nil
+	return s.GetMode()
 }
 
-func (s *Schedule) IsActiveDayHour(day time.Weekday, hour int) (bool, error) {
-	var count int
-	err := s.Db.QueryRow("SELECT count(*) FROM "+s.Table+" WHERE dayhour = $1", DayHourToString(day, hour)).Scan(&count)
+func (s *Schedule) IsActiveTime(t time.Time) (bool, error) {
+	t = t.In(s.location())
+	ranges, err := s.Ranges()
 	if err != nil {
 		return false, err
 	}
-	if count > 0 {
-		return true, nil
+	for _, r := range ranges {
+		if r.Active(t) {
+			return true, nil
+		}
 	}
 	return false, nil
 }
@@ -152,11 +226,15 @@ func (s *Schedule) GetMode() (ScheduleMode, error) {
 	var modeStr string
 	err := s.Db.QueryRow("SELECT mode FROM sched_mode WHERE sched = $1", s.Table).Scan(&modeStr)
 	if err == sql.ErrNoRows {
-		smErr := s.SetMode(ModeSched)
+		mode := s.DefaultMode
+		if mode == ModeInvalid {
+			mode = ModeSched
+		}
+		smErr := s.SetMode(mode)
 		if smErr != nil {
 			return sm, smErr
 		}
-		return ModeSched, nil
+		return mode, nil
 	}
 	if err != nil {
 		return sm, err
@@ -227,79 +305,38 @@ func ParseDayHour(dayhour string) (*time.Weekday, int, error) {
 	return nil, 0, fmt.Errorf("Invalid day or hour: %s", dayhour)
 }
 
-func (s *Schedule) Deactivate(dayhour string) error {
-	day, hour, err := ParseDayHour(dayhour)
-	if err != nil {
-		return err
-	}
-	if hour == -1 && day == nil {
-		return errors.New("No day or hour found")
-	}
-	if hour < -1 || hour > 23 {
-		return errors.New("Invalid hour, only 0-23 allowed")
-	}
-	if hour == -1 {
-		for h := 0; h < 24; h++ {
-			err := s.SetDeactive(*day, h)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
-	}
-	if day == nil {
-		for _, d := range Weekdays {
-			err := s.SetDeactive(d, hour)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
-	}
-	err = s.SetDeactive(*day, hour)
+// Deactivate turns off the range, see ParseRange for the formats.
+func (s *Schedule) Deactivate(spec string) error {
+	r, err := ParseRange(spec)
 	if err != nil {
 		return err
 	}
-	return nil
+	return s.update(r, false)
 }
 
-func (s *Schedule) Activate(dayhour string) error {
-	day, hour, err := ParseDayHour(dayhour)
+// Activate turns on the range, see ParseRange for the formats.
+func (s *Schedule) Activate(spec string) error {
+	r, err := ParseRange(spec)
 	if err != nil {
 		return err
 	}
-	if hour == -1 && day == nil {
-		return errors.New("No day or hour found")
-	}
-	if hour == -1 {
-		for h := 0; h < 24; h++ {
-			err := s.SetActive(*day, h)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
-	}
-	if day == nil {
-		for _, d := range Weekdays {
-			err := s.SetActive(d, hour)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
-	}
-	err = s.SetActive(*day, hour)
-	if err != nil {
-		return err
-	}
-	return nil
+	return s.update(r, true)
 }
 
+// GetTable renders the schedule as an hour by day grid, headed by its time zone, with
+// the minutes of hours that are only partly on, e.g. :30- for on from half past.
 func (s *Schedule) GetTable() ([][]string, []string, error) {
-	header := []string{"Hour", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+	header := []string{s.location().String(), "Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
 	data := [][]string{}
 
+	ranges, err := s.Ranges()
+	if err != nil {
+		return nil, nil, err
+	}
+	w := &week{}
+	for _, r := range ranges {
+		w.set(r, true)
+	}
 	for hour := 0; hour < 24; hour++ {
 		hourStr := fmt.Sprintf("%d:00", hour)
 		if hour < 10 {
@@ -307,14 +344,26 @@ func (s *Schedule) GetTable() ([][]string, []string, error) {
 		}
 		row := []string{hourStr}
 		for _, day := range Weekdays {
-			active, err := s.IsActiveDayHour(day, hour)
-			if err != nil {
-				return nil, nil, err
+			first, last := -1, -1
+			for m := 0; m < 60; m++ {
+				if w[weekIndex(day)*minutesPerDay+hour*60+m] {
+					if first == -1 {
+						first = m
+					}
+					last = m
+				}
 			}
-			if active {
-				row = append(row, "✔")
-			} else {
+			switch {
+			case first == -1:
 				row = append(row, "")
+			case first == 0 && last == 59:
+				row = append(row, "✔")
+			case first == 0:
+				row = append(row, fmt.Sprintf("-:%02d", last+1))
+			case last == 59:
+				row = append(row, fmt.Sprintf(":%02d-", first))
+			default:
+				row = append(row, fmt.Sprintf(":%02d-:%02d", first, last+1))
 			}
 		}
 		data = append(data, row)
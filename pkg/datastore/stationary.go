@@ -0,0 +1,63 @@
+package datastore
+
+import (
+	"image"
+	"time"
+)
+
+// Stationary is a detection that stayed in the same place, e.g. a parked car, which
+// is remembered across restarts so that it doesn't alert again.
+type Stationary struct {
+	ID    int64
+	Label string
+	Rect  image.Rectangle
+	Since time.Time
+}
+
+func (s *Store) StationaryList() ([]Stationary, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rows, err := s.db.Query("SELECT id, label, x1, y1, x2, y2, since FROM stationary ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []Stationary{}
+	for rows.Next() {
+		var st Stationary
+		var since int64
+		err = rows.Scan(&st.ID, &st.Label, &st.Rect.Min.X, &st.Rect.Min.Y, &st.Rect.Max.X, &st.Rect.Max.Y, &since)
+		if err != nil {
+			return nil, err
+		}
+		st.Since = time.Unix(since, 0)
+		ret = append(ret, st)
+	}
+	return ret, rows.Err()
+}
+
+// StationaryAdd stores the detection, and returns its ID.
+func (s *Store) StationaryAdd(st Stationary) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r := st.Rect
+	result, err := s.db.Exec("INSERT INTO stationary (label, x1, y1, x2, y2, since) VALUES (?, ?, ?, ?, ?, ?)", st.Label, r.Min.X, r.Min.Y, r.Max.X, r.Max.Y, st.Since.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *Store) StationaryRemove(id int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := s.db.Exec("DELETE FROM stationary WHERE id = ?", id)
+	return err
+}
+
+func (s *Store) StationaryClear() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := s.db.Exec("DELETE FROM stationary")
+	return err
+}
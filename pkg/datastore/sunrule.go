@@ -0,0 +1,210 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/marktheunissen/watchbot/pkg/sun"
+)
+
+const (
+	Sunrise = "sunrise"
+	Sunset  = "sunset"
+)
+
+// SunTime is a time relative to sunrise or sunset, e.g. sunset+30m.
+type SunTime struct {
+	Event  string
+	Offset time.Duration
+}
+
+func (s SunTime) String() string {
+	switch {
+	case s.Offset > 0:
+		return fmt.Sprintf("%s+%s", s.Event, shortDuration(s.Offset))
+	case s.Offset < 0:
+		return fmt.Sprintf("%s-%s", s.Event, shortDuration(-s.Offset))
+	}
+	return s.Event
+}
+
+// shortDuration drops the zero units that time.Duration prints, e.g. 1h30m not 1h30m0s.
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// At returns the time on the date of t, false if the sun doesn't rise or set that day.
+func (s SunTime) At(t time.Time, c sun.Coords) (time.Time, bool) {
+	day := sun.Times(t, c)
+	if day.AlwaysUp || day.AlwaysDown {
+		return time.Time{}, false
+	}
+	if s.Event == Sunrise {
+		return day.Sunrise.Add(s.Offset), true
+	}
+	return day.Sunset.Add(s.Offset), true
+}
+
+func parseSunTime(s string) (SunTime, error) {
+	for _, event := range []string{Sunrise, Sunset} {
+		if !strings.HasPrefix(s, event) {
+			continue
+		}
+		st := SunTime{Event: event}
+		offset := strings.TrimPrefix(s, event)
+		if offset == "" {
+			return st, nil
+		}
+		d, err := time.ParseDuration(offset)
+		if err != nil {
+			return SunTime{}, fmt.Errorf("Invalid offset: %s, e.g. sunset+30m", s)
+		}
+		st.Offset = d
+		return st, nil
+	}
+	return SunTime{}, fmt.Errorf("Invalid sun time: %s, e.g. sunset+30m or sunrise-15m", s)
+}
+
+// SunRule is on from one sun time to the next, e.g. from sunset+30m to sunrise-15m, on
+// the days it starts.
+type SunRule struct {
+	ID   int64
+	Days Days
+	From SunTime
+	To   SunTime
+}
+
+func (r SunRule) String() string {
+	return fmt.Sprintf("%s from %s to %s", r.Days, r.From, r.To)
+}
+
+// Active returns true if t is between the rule's start on that day or the day before,
+// and its end after that. The rule is off on days the sun doesn't rise or set.
+func (r SunRule) Active(t time.Time, c sun.Coords) bool {
+	for back := 0; back < 2; back++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()-back, 12, 0, 0, 0, t.Location())
+		if !r.Days.Has(day.Weekday()) {
+			continue
+		}
+		start, ok := r.From.At(day, c)
+		if !ok {
+			continue
+		}
+		end, ok := r.To.At(day, c)
+		if ok && !end.After(start) {
+			end, ok = r.To.At(day.AddDate(0, 0, 1), c)
+		}
+		if ok && !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSunRule parses [days] [from] <sun time> [to] <sun time>, e.g.
+// from sunset+30m to sunrise-15m, or mon-fri sunset sunrise.
+func ParseSunRule(spec string) (SunRule, error) {
+	fields := []string{}
+	for _, f := range strings.Fields(strings.ToLower(spec)) {
+		if f != "from" && f != "to" {
+			fields = append(fields, f)
+		}
+	}
+	r := SunRule{Days: AllDays}
+	switch len(fields) {
+	case 3:
+		days, err := ParseDays(fields[0])
+		if err != nil {
+			return SunRule{}, err
+		}
+		r.Days = days
+		fields = fields[1:]
+	case 2:
+	default:
+		return SunRule{}, errors.New("Invalid sun rule, e.g. from sunset+30m to sunrise-15m")
+	}
+	var err error
+	r.From, err = parseSunTime(fields[0])
+	if err != nil {
+		return SunRule{}, err
+	}
+	r.To, err = parseSunTime(fields[1])
+	if err != nil {
+		return SunRule{}, err
+	}
+	return r, nil
+}
+
+func (s *Schedule) AddSunRule(r SunRule) (int64, error) {
+	if s.Coords == nil {
+		return 0, errors.New("Sun rules need the camera's latitude and longitude to be configured")
+	}
+	result, err := s.Db.Exec("INSERT INTO sched_sun (sched, days, from_event, from_offset, to_event, to_offset) VALUES ($1, $2, $3, $4, $5, $6)", s.Table, r.Days, r.From.Event, int64(r.From.Offset), r.To.Event, int64(r.To.Offset))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *Schedule) SunRules() ([]SunRule, error) {
+	rows, err := s.Db.Query("SELECT id, days, from_event, from_offset, to_event, to_offset FROM sched_sun WHERE sched = $1 ORDER BY id", s.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []SunRule{}
+	for rows.Next() {
+		var r SunRule
+		var fromOffset, toOffset int64
+		err = rows.Scan(&r.ID, &r.Days, &r.From.Event, &fromOffset, &r.To.Event, &toOffset)
+		if err != nil {
+			return nil, err
+		}
+		r.From.Offset = time.Duration(fromOffset)
+		r.To.Offset = time.Duration(toOffset)
+		ret = append(ret, r)
+	}
+	return ret, rows.Err()
+}
+
+func (s *Schedule) RemoveSunRule(id int64) error {
+	result, err := s.Db.Exec("DELETE FROM sched_sun WHERE sched = $1 AND id = $2", s.Table, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("No sun rule with ID %d", id)
+	}
+	return nil
+}
+
+// sunActive returns true if any sun rule is on at the time. Without coordinates
+// there's no sun to go by, so none are.
+func (s *Schedule) sunActive(t time.Time) (bool, error) {
+	if s.Coords == nil {
+		return false, nil
+	}
+	rules, err := s.SunRules()
+	if err != nil {
+		return false, err
+	}
+	for _, r := range rules {
+		if r.Active(t, *s.Coords) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,63 @@
+package detect
+
+import (
+	"fmt"
+
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	"gocv.io/x/gocv"
+)
+
+const (
+	// BackendNCS runs inference on an original Movidius Neural Compute Stick.
+	BackendNCS = "ncs"
+
+	// BackendOpenCV runs inference on the CPU using the OpenCV DNN module.
+	BackendOpenCV = "opencv"
+
+	// BackendReplay returns boxes from a recording instead of running a model.
+	BackendReplay = "replay"
+)
+
+// Backend runs inference on a single frame, and returns the boxes found with
+// coordinates relative to that frame. The frame number counts the frames read from
+// the camera since startup.
+type Backend interface {
+	Name() string
+	Infer(camID string, frameNum int, img gocv.Mat) ([]*frame.Box, error)
+	Close()
+}
+
+func newBackend(config Config) (Backend, error) {
+	switch config.Backend {
+	case "", BackendNCS:
+		return newNCSBackend(config)
+	case BackendOpenCV:
+		return newOpenCVBackend(config)
+	case BackendReplay:
+		return newReplayBackend(config)
+	}
+	return nil, fmt.Errorf("Unknown detector backend: %s", config.Backend)
+}
+
+// decoderName is the decoder given in config, or else the default for the backend.
+func decoderName(config Config, defaultName string) string {
+	if config.Decoder == "" {
+		return defaultName
+	}
+	return config.Decoder
+}
+
+// newConfigDecoder selects the decoder for the model's outputs, falling back to the
+// given default for the backend.
+func newConfigDecoder(config Config, defaultName string) (Decoder, error) {
+	name := decoderName(config, defaultName)
+	log.Infof("Using %s decoder for model outputs", name)
+	return NewDecoder(name, DecoderConfig{
+		Labels:       config.GraphLabels,
+		InputWidth:   config.GraphWidth,
+		InputHeight:  config.GraphHeight,
+		Anchors:      config.Anchors,
+		MinScore:     config.MinScore,
+		NMSThreshold: config.NMSThreshold,
+	})
+}
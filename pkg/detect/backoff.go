@@ -0,0 +1,38 @@
+package detect
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff is the delay between attempts to reconnect a camera, doubling from Min up to
+// Max, with up to Jitter (a fraction of the delay) added or removed so that cameras on
+// the same host don't all retry at once.
+type Backoff struct {
+	Min    time.Duration
+	Max    time.Duration
+	Jitter float64
+
+	// After this many failed attempts in a row, give up. 0 retries forever.
+	Attempts int
+}
+
+// Delay returns the wait before the given attempt, counting from 1.
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Min
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if b.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * b.Jitter * float64(d))
+	}
+	return d
+}
+
+// GiveUp returns true once the attempts have run out.
+func (b Backoff) GiveUp(attempt int) bool {
+	return b.Attempts > 0 && attempt >= b.Attempts
+}
@@ -0,0 +1,368 @@
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// NoNewFrame is returned when the camera hasn't produced a frame since the last one taken.
+var NoNewFrame = errors.New("No new frame")
+
+// CamStatusStalled is returned while a camera read has been blocked for too long.
+var CamStatusStalled = errors.New("Camera is stalled")
+
+// CamStatusConnecting is returned while the camera is being opened, or waiting to be
+// reopened after the stream dropped.
+var CamStatusConnecting = errors.New("Camera is connecting")
+
+// CamStatusFailed is returned once the camera has run out of reconnect attempts.
+var CamStatusFailed = errors.New("Camera connection failed")
+
+// ConnState is the state of a camera's connection.
+type ConnState string
+
+const (
+	ConnConnecting ConnState = "connecting"
+	ConnStreaming  ConnState = "streaming"
+	ConnBackoff    ConnState = "backoff"
+	ConnFailed     ConnState = "failed"
+)
+
+// ConnEvent is sent on every change of a camera's connection state.
+type ConnEvent struct {
+	CamID string
+	State ConnState
+
+	// Failed attempts in a row, when streaming it's the number it took to reconnect.
+	Attempt int
+
+	// Wait before the next attempt, when in backoff.
+	Delay time.Duration
+	Err   error
+}
+
+type CaptureConfig struct {
+	CamID string
+	URI   string
+
+	// Lossless waits for each frame to be taken before reading the next, for video
+	// files that would otherwise be read as fast as they can be decoded. Lossless
+	// captures aren't reopened, the end of the file fails the capture.
+	Lossless bool
+
+	// A read blocked for longer than StallTimeout marks the camera stalled, and it's
+	// reopened as if the stream dropped.
+	StallTimeout time.Duration
+
+	Backoff Backoff
+
+	// Connection state changes are sent here if set, and dropped if it's full.
+	Events chan<- ConnEvent
+}
+
+// frameSource is what a capture reads frames from, a gocv.VideoCapture outside of tests.
+type frameSource interface {
+	Read(m *gocv.Mat) bool
+	Close() error
+}
+
+func openVideoCapture(uri string) (frameSource, error) {
+	vc, err := gocv.VideoCaptureFile(uri)
+	if err != nil {
+		return nil, err
+	}
+	if !vc.IsOpened() {
+		vc.Close()
+		return nil, errors.New("could not open")
+	}
+	return vc, nil
+}
+
+// Capture reads the frames of one camera in its own goroutine, so that a slow or hung
+// stream can't hold up the other cameras. Only the latest frame is kept, frames that
+// are replaced before they were taken count as dropped. When the stream drops or
+// stalls, the camera is reopened with a backoff between attempts.
+type Capture struct {
+	CaptureConfig
+
+	open   func(uri string) (frameSource, error)
+	latest gocv.Mat
+	stop   chan struct{}
+
+	lock     sync.Mutex
+	cond     *sync.Cond
+	state    ConnState
+	attempt  int
+	connects int
+
+	// Generation of the reader goroutine, bumped when one is abandoned so that it
+	// leaves the capture alone if its read ever returns.
+	gen int
+
+	seq       int
+	taken     int
+	frameTime time.Time
+	readStart time.Time
+	stopped   bool
+	read      int64
+	dropped   int64
+}
+
+// CaptureStats are the counters of a capture since it was opened.
+type CaptureStats struct {
+	State     ConnState
+	Attempt   int
+	Connects  int
+	Read      int64
+	Dropped   int64
+	FrameTime time.Time
+}
+
+// OpenCapture starts the capture, the camera is opened in the background.
+func OpenCapture(config CaptureConfig) *Capture {
+	return startCapture(config, openVideoCapture)
+}
+
+func startCapture(config CaptureConfig, open func(uri string) (frameSource, error)) *Capture {
+	c := &Capture{
+		CaptureConfig: config,
+		open:          open,
+		latest:        gocv.NewMat(),
+		stop:          make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.lock)
+	go c.run()
+	return c
+}
+
+func (c *Capture) run() {
+	defer func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.stopped = true
+		c.gen++
+		c.latest.Close()
+		log.Debugf("camera %s: capture closed", c.CamID)
+	}()
+	for {
+		c.setState(ConnConnecting, 0, nil)
+		err := c.connect()
+		if err == nil {
+			return
+		}
+		log.Errorf("camera %s: %s", c.CamID, err)
+
+		c.lock.Lock()
+		c.attempt++
+		attempt := c.attempt
+		c.lock.Unlock()
+		if c.Lossless || c.Backoff.GiveUp(attempt) {
+			c.setState(ConnFailed, 0, err)
+			return
+		}
+		delay := c.Backoff.Delay(attempt)
+		c.setState(ConnBackoff, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// connect opens the camera and reads from it in a new goroutine, until a read fails or
+// stalls, returning nil only if the capture was stopped. Reads can't be interrupted, so
+// a stalled goroutine is abandoned, and closes its device if the read ever returns.
+func (c *Capture) connect() error {
+	c.lock.Lock()
+	c.gen++
+	gen := c.gen
+	c.lock.Unlock()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.stream(gen)
+	}()
+
+	var check <-chan time.Time
+	if c.StallTimeout > 0 {
+		t := time.NewTicker(c.StallTimeout / 4)
+		defer t.Stop()
+		check = t.C
+	}
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-c.stop:
+			return nil
+		case <-check:
+			c.lock.Lock()
+			stalled := c.stalled()
+			if stalled {
+				c.gen++
+				c.readStart = time.Time{}
+				c.cond.Broadcast()
+			}
+			c.lock.Unlock()
+			if stalled {
+				return fmt.Errorf("read stalled for over %v", c.StallTimeout)
+			}
+		}
+	}
+}
+
+// stream opens the camera and reads frames until a read fails, returning nil if the
+// capture was stopped or the goroutine abandoned.
+func (c *Capture) stream(gen int) error {
+	// Opening a dead stream can hang as well.
+	c.lock.Lock()
+	c.readStart = time.Now()
+	c.lock.Unlock()
+	src, err := c.open(c.URI)
+	if err != nil {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if c.gen != gen {
+			return nil
+		}
+		c.readStart = time.Time{}
+		return err
+	}
+	defer src.Close()
+
+	// Only this goroutine touches buf, so reads don't need the lock.
+	buf := gocv.NewMat()
+	defer buf.Close()
+	for {
+		c.lock.Lock()
+		for c.Lossless && c.seq > c.taken && c.gen == gen {
+			c.cond.Wait()
+		}
+		if c.gen != gen {
+			c.lock.Unlock()
+			return nil
+		}
+		c.readStart = time.Now()
+		c.lock.Unlock()
+
+		ok := src.Read(&buf)
+
+		c.lock.Lock()
+		if c.gen != gen {
+			c.lock.Unlock()
+			return nil
+		}
+		c.readStart = time.Time{}
+		if !ok || buf.Empty() {
+			c.lock.Unlock()
+			return errors.New("no frame")
+		}
+		if c.seq > c.taken {
+			c.dropped++
+		}
+		c.latest, buf = buf, c.latest
+		c.seq++
+		c.read++
+		c.frameTime = time.Now()
+		streaming := c.state == ConnStreaming
+		c.lock.Unlock()
+
+		// Opening may succeed on a dead stream, only a frame shows the camera is back.
+		if !streaming {
+			c.setState(ConnStreaming, 0, nil)
+		}
+	}
+}
+
+func (c *Capture) setState(state ConnState, delay time.Duration, err error) {
+	c.lock.Lock()
+	attempt := c.attempt
+	c.state = state
+	if state == ConnStreaming {
+		c.attempt = 0
+		c.connects++
+	}
+	c.lock.Unlock()
+
+	if c.Events == nil {
+		return
+	}
+	event := ConnEvent{
+		CamID:   c.CamID,
+		State:   state,
+		Attempt: attempt,
+		Delay:   delay,
+		Err:     err,
+	}
+	select {
+	case c.Events <- event:
+	default:
+		log.Warnf("camera %s: connection events full, dropped: %+v", c.CamID, event)
+	}
+}
+
+// Latest copies the latest frame into dst, and returns its sequence number and the
+// time it was read. With fresh, it returns NoNewFrame if the frame was already taken,
+// and marks it as taken.
+func (c *Capture) Latest(dst *gocv.Mat, fresh bool) (int, time.Time, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.state == ConnFailed {
+		return 0, time.Time{}, CamStatusFailed
+	}
+	if c.stopped {
+		return 0, time.Time{}, CamStatusInactive
+	}
+	if c.state != ConnStreaming {
+		return 0, time.Time{}, CamStatusConnecting
+	}
+	if c.stalled() {
+		return 0, time.Time{}, CamStatusStalled
+	}
+	if fresh && c.seq == c.taken {
+		return 0, time.Time{}, NoNewFrame
+	}
+	c.latest.CopyTo(dst)
+	if fresh {
+		c.taken = c.seq
+		c.cond.Signal()
+	}
+	return c.seq, c.frameTime, nil
+}
+
+// stalled returns true if the current read has been blocked for too long, the lock
+// must be held.
+func (c *Capture) stalled() bool {
+	return !c.readStart.IsZero() && c.StallTimeout > 0 && time.Since(c.readStart) > c.StallTimeout
+}
+
+func (c *Capture) Stats() CaptureStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return CaptureStats{
+		State:     c.state,
+		Attempt:   c.attempt,
+		Connects:  c.connects,
+		Read:      c.read,
+		Dropped:   c.dropped,
+		FrameTime: c.frameTime,
+	}
+}
+
+// Stop ends the capture without waiting, the reader goroutine closes the device once
+// its current read returns, which may be never for a hung stream.
+func (c *Capture) Stop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.stopped {
+		return
+	}
+	c.stopped = true
+	c.gen++
+	close(c.stop)
+	c.cond.Broadcast()
+}
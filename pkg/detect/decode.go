@@ -0,0 +1,375 @@
+package detect
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/marktheunissen/watchbot/pkg/frame"
+)
+
+const (
+	// DecoderSSDNCS is the NCS SDK 1 SSD MobileNet layout: a count, 6 unused values,
+	// then groups of 7 values per detection.
+	DecoderSSDNCS = "ssd-ncs"
+
+	// DecoderSSD is the standard float32 SSD DetectionOutput layout [1, 1, N, 7].
+	DecoderSSD = "ssd"
+
+	DecoderYOLOv3 = "yolov3"
+	DecoderYOLOv5 = "yolov5"
+	DecoderYOLOv8 = "yolov8"
+)
+
+// Tensor is a model output, flattened in row-major order.
+type Tensor struct {
+	Shape []int
+	Data  []float32
+}
+
+// Decoder turns the raw output tensors of a model into boxes, with coordinates in
+// pixels of a frame of the given size.
+type Decoder interface {
+	Decode(outputs []Tensor, cols, rows int) ([]*frame.Box, error)
+}
+
+type DecoderConfig struct {
+	Labels      []string
+	InputWidth  int
+	InputHeight int
+
+	// Anchors for each YOLO grid output, in order of the outputs, as pairs of width
+	// and height in input pixels.
+	Anchors [][]float64
+
+	// Candidates below MinScore are dropped, and overlapping candidates of the same
+	// label above NMSThreshold IoU are suppressed. Only used by the YOLO decoders.
+	MinScore     float64
+	NMSThreshold float64
+}
+
+type DecoderFactory func(config DecoderConfig) Decoder
+
+var decoders = map[string]DecoderFactory{
+	DecoderSSDNCS: func(config DecoderConfig) Decoder { return &ssdDecoder{config: config, header: true} },
+	DecoderSSD:    func(config DecoderConfig) Decoder { return &ssdDecoder{config: config} },
+	DecoderYOLOv3: func(config DecoderConfig) Decoder { return &yoloDecoder{config: config, version: 3} },
+	DecoderYOLOv5: func(config DecoderConfig) Decoder { return &yoloDecoder{config: config, version: 5} },
+	DecoderYOLOv8: func(config DecoderConfig) Decoder { return &yoloDecoder{config: config, version: 8} },
+}
+
+// RegisterDecoder makes a decoder available by name, to be selected in config.
+func RegisterDecoder(name string, factory DecoderFactory) {
+	decoders[name] = factory
+}
+
+func NewDecoder(name string, config DecoderConfig) (Decoder, error) {
+	factory, ok := decoders[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown decoder: %s", name)
+	}
+	if config.MinScore == 0 {
+		config.MinScore = 0.25
+	}
+	if config.NMSThreshold == 0 {
+		config.NMSThreshold = 0.45
+	}
+	return factory(config), nil
+}
+
+// ParseAnchors reads anchors given in config, one string of comma separated
+// width,height pairs per YOLO output, e.g. "10,13, 16,30, 33,23".
+func ParseAnchors(heads []string) ([][]float64, error) {
+	ret := [][]float64{}
+	for _, head := range heads {
+		anchors := []float64{}
+		for _, a := range strings.Split(head, ",") {
+			f, err := strconv.ParseFloat(strings.TrimSpace(a), 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid anchor: %s", a)
+			}
+			anchors = append(anchors, f)
+		}
+		if len(anchors)%2 != 0 {
+			return nil, fmt.Errorf("Anchors must be width,height pairs: %s", head)
+		}
+		ret = append(ret, anchors)
+	}
+	return ret, nil
+}
+
+type ssdDecoder struct {
+	config DecoderConfig
+	header bool
+}
+
+//	a.	First value holds the number of valid detections = num_valid.
+//	b.	The next 6 values are unused.
+//	c.	The next (7 * num_valid) values contain the valid detections data
+//	    Each group of 7 values will describe an object/box These 7 values in order.
+//	    The values are:
+//	      0: image_id (always 0)
+//	      1: class_id (this is an index into labels)
+//	      2: score (this is the probability for the class)
+//	      3: box left location within image as number between 0.0 and 1.0
+//	      4: box top location within image as number between 0.0 and 1.0
+//	      5: box right location within image as number between 0.0 and 1.0
+//	      6: box bottom location within image as number between 0.0 and 1.0
+//
+// Without the header (a. and b.), all groups of 7 in the output are detections, and
+// those with an image_id of -1 mark the end of the valid detections.
+func (s *ssdDecoder) Decode(outputs []Tensor, cols, rows int) ([]*frame.Box, error) {
+	if len(outputs) != 1 {
+		return nil, fmt.Errorf("SSD expects 1 output, got: %d", len(outputs))
+	}
+	data := outputs[0].Data
+	numBoxes := len(data) / 7
+	if s.header {
+		if len(data) < 7 {
+			return nil, fmt.Errorf("SSD output too short: %d", len(data))
+		}
+		numBoxes = int(data[0])
+		data = data[7:]
+		if len(data) < numBoxes*7 {
+			return nil, fmt.Errorf("SSD output too short for %d boxes: %d", numBoxes, len(data))
+		}
+	}
+
+	retBoxes := []*frame.Box{}
+	for i := 0; i < numBoxes; i++ {
+		v := data[i*7 : i*7+7]
+		imageID := float64(v[0])
+		if invalidFloat(imageID) {
+			// According to NCS specs, it's desirable to continue here if any floats are invalid
+			continue
+		}
+		if imageID < 0 {
+			break
+		}
+		b := newBox(s.config.Labels, float64(v[1]), float64(v[2]), float64(v[3]), float64(v[4]), float64(v[5]), float64(v[6]), cols, rows)
+		if b == nil {
+			continue
+		}
+		log.Debugf("found box: %+v", b)
+		retBoxes = append(retBoxes, b)
+	}
+	return retBoxes, nil
+}
+
+// yoloDecoder handles the YOLO family outputs:
+//   - grid outputs [1, A*(5+C), H, W] of any version, decoded with the anchors
+//   - v3 as output by OpenCV's darknet region layer [N, 5+C], with normalized coordinates
+//   - v5 [1, N, 5+C] with coordinates in input pixels
+//   - v8 [1, 4+C, N] with coordinates in input pixels and no objectness
+type yoloDecoder struct {
+	config  DecoderConfig
+	version int
+}
+
+func (y *yoloDecoder) Decode(outputs []Tensor, cols, rows int) ([]*frame.Box, error) {
+	candidates := []*frame.Box{}
+	for i, out := range outputs {
+		var boxes []*frame.Box
+		var err error
+		switch {
+		case len(out.Shape) == 4:
+			if i >= len(y.config.Anchors) {
+				return nil, fmt.Errorf("YOLO grid output %d has no anchors configured", i)
+			}
+			boxes, err = y.decodeGrid(out, y.config.Anchors[i], cols, rows)
+		case y.version == 8:
+			boxes, err = y.decodeTransposed(out, cols, rows)
+		default:
+			boxes, err = y.decodeRows(out, cols, rows)
+		}
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, boxes...)
+	}
+	boxes, _ := frame.NMS(candidates, frame.NMSConfig{Threshold: y.config.NMSThreshold})
+	return boxes, nil
+}
+
+func (y *yoloDecoder) decodeGrid(out Tensor, anchors []float64, cols, rows int) ([]*frame.Box, error) {
+	numAnchors := len(anchors) / 2
+	channels, gridH, gridW := out.Shape[1], out.Shape[2], out.Shape[3]
+	if numAnchors == 0 || channels%numAnchors != 0 {
+		return nil, fmt.Errorf("YOLO grid channels %d do not match %d anchors", channels, numAnchors)
+	}
+	attrs := channels / numAnchors
+	if attrs-5 != len(y.config.Labels) {
+		return nil, fmt.Errorf("YOLO grid has %d classes, but %d labels", attrs-5, len(y.config.Labels))
+	}
+	if len(out.Data) < channels*gridH*gridW {
+		return nil, fmt.Errorf("YOLO grid output too short: %d", len(out.Data))
+	}
+	at := func(a, k, gy, gx int) float64 {
+		return float64(out.Data[((a*attrs+k)*gridH+gy)*gridW+gx])
+	}
+
+	ret := []*frame.Box{}
+	for a := 0; a < numAnchors; a++ {
+		for gy := 0; gy < gridH; gy++ {
+			for gx := 0; gx < gridW; gx++ {
+				obj := sigmoid(at(a, 4, gy, gx))
+				if obj < y.config.MinScore {
+					continue
+				}
+				classID, classScore := -1, 0.0
+				for c := 0; c < attrs-5; c++ {
+					if s := sigmoid(at(a, 5+c, gy, gx)); s > classScore {
+						classID, classScore = c, s
+					}
+				}
+				score := obj * classScore
+				if score < y.config.MinScore {
+					continue
+				}
+				var cx, cy, w, h float64
+				if y.version == 3 {
+					cx = (sigmoid(at(a, 0, gy, gx)) + float64(gx)) / float64(gridW)
+					cy = (sigmoid(at(a, 1, gy, gx)) + float64(gy)) / float64(gridH)
+					w = math.Exp(at(a, 2, gy, gx)) * anchors[a*2] / float64(y.config.InputWidth)
+					h = math.Exp(at(a, 3, gy, gx)) * anchors[a*2+1] / float64(y.config.InputHeight)
+				} else {
+					cx = (sigmoid(at(a, 0, gy, gx))*2 - 0.5 + float64(gx)) / float64(gridW)
+					cy = (sigmoid(at(a, 1, gy, gx))*2 - 0.5 + float64(gy)) / float64(gridH)
+					w = math.Pow(sigmoid(at(a, 2, gy, gx))*2, 2) * anchors[a*2] / float64(y.config.InputWidth)
+					h = math.Pow(sigmoid(at(a, 3, gy, gx))*2, 2) * anchors[a*2+1] / float64(y.config.InputHeight)
+				}
+				if b := y.newCenterBox(classID, score, cx, cy, w, h, cols, rows); b != nil {
+					ret = append(ret, b)
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (y *yoloDecoder) decodeRows(out Tensor, cols, rows int) ([]*frame.Box, error) {
+	attrs := out.Shape[len(out.Shape)-1]
+	if attrs-5 != len(y.config.Labels) {
+		return nil, fmt.Errorf("YOLO output has %d classes, but %d labels", attrs-5, len(y.config.Labels))
+	}
+	// OpenCV's darknet region layer gives coordinates as fractions, exported v5 models
+	// in input pixels.
+	scaleX, scaleY := 1.0, 1.0
+	if y.version != 3 {
+		scaleX, scaleY = float64(y.config.InputWidth), float64(y.config.InputHeight)
+	}
+	ret := []*frame.Box{}
+	for i := 0; i+attrs <= len(out.Data); i += attrs {
+		v := out.Data[i : i+attrs]
+		obj := float64(v[4])
+		if obj < y.config.MinScore {
+			continue
+		}
+		classID, classScore := argmax(v[5:])
+		score := classScore
+		if y.version != 3 {
+			score = obj * classScore
+		}
+		if score < y.config.MinScore {
+			continue
+		}
+		b := y.newCenterBox(classID, score, float64(v[0])/scaleX, float64(v[1])/scaleY, float64(v[2])/scaleX, float64(v[3])/scaleY, cols, rows)
+		if b != nil {
+			ret = append(ret, b)
+		}
+	}
+	return ret, nil
+}
+
+func (y *yoloDecoder) decodeTransposed(out Tensor, cols, rows int) ([]*frame.Box, error) {
+	if len(out.Shape) != 3 {
+		return nil, fmt.Errorf("YOLOv8 expects output [1, 4+C, N], got: %v", out.Shape)
+	}
+	attrs, n := out.Shape[1], out.Shape[2]
+	if attrs-4 != len(y.config.Labels) {
+		return nil, fmt.Errorf("YOLOv8 output has %d classes, but %d labels", attrs-4, len(y.config.Labels))
+	}
+	if len(out.Data) < attrs*n {
+		return nil, fmt.Errorf("YOLOv8 output too short: %d", len(out.Data))
+	}
+	at := func(k, i int) float64 {
+		return float64(out.Data[k*n+i])
+	}
+	ret := []*frame.Box{}
+	for i := 0; i < n; i++ {
+		classID, score := -1, 0.0
+		for c := 0; c < attrs-4; c++ {
+			if s := at(4+c, i); s > score {
+				classID, score = c, s
+			}
+		}
+		if score < y.config.MinScore {
+			continue
+		}
+		w, h := float64(y.config.InputWidth), float64(y.config.InputHeight)
+		b := y.newCenterBox(classID, score, at(0, i)/w, at(1, i)/h, at(2, i)/w, at(3, i)/h, cols, rows)
+		if b != nil {
+			ret = append(ret, b)
+		}
+	}
+	return ret, nil
+}
+
+// newCenterBox takes a box as center and size, as fractions of the frame.
+func (y *yoloDecoder) newCenterBox(classID int, score, cx, cy, w, h float64, cols, rows int) *frame.Box {
+	return newBox(y.config.Labels, float64(classID), score, cx-w/2, cy-h/2, cx+w/2, cy+h/2, cols, rows)
+}
+
+func argmax(v []float32) (int, float64) {
+	idx, max := -1, 0.0
+	for i, f := range v {
+		if float64(f) > max {
+			idx, max = i, float64(f)
+		}
+	}
+	return idx, max
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+func invalidFloat(f float64) bool {
+	return math.IsInf(f, 0) || math.IsNaN(f)
+}
+
+func actualPos(frac float64, d int) int {
+	// Boxes can be negative, so set them to border.
+	p := int(frac * float64(d))
+	if p < 0 {
+		p = 0
+	}
+	if p > d {
+		p = d
+	}
+	return p
+}
+
+// newBox converts a single detection, with coordinates given as fractions of the
+// frame, into a box in pixel coordinates. Returns nil if the detection is not usable.
+func newBox(labels []string, classID, score, left, top, right, bottom float64, cols, rows int) *frame.Box {
+	if invalidFloat(classID) || invalidFloat(score) || invalidFloat(left) || invalidFloat(top) || invalidFloat(right) || invalidFloat(bottom) {
+		return nil
+	}
+	if int(classID) > len(labels)-1 || int(classID) < 0 {
+		// Label value returned in the result doesn't match anything we know about,
+		// it's out of range
+		return nil
+	}
+	X1 := actualPos(left, cols)
+	Y1 := actualPos(top, rows)
+	X2 := actualPos(right, cols)
+	Y2 := actualPos(bottom, rows)
+	return &frame.Box{
+		Label:      labels[int(classID)],
+		Confidence: int(score * 100),
+		Coords:     image.Rect(X1, Y1, X2, Y2),
+	}
+}
@@ -0,0 +1,12 @@
+//go:build noncs
+// +build noncs
+
+package detect
+
+import "errors"
+
+// Built with the noncs tag, so that hosts without the Movidius SDK can still build
+// and run the other backends.
+func newNCSBackend(config Config) (Backend, error) {
+	return nil, errors.New("NCS backend is not available, binary was built with the noncs tag")
+}
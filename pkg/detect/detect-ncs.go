@@ -1,5 +1,138 @@
+//go:build !noncs
+// +build !noncs
+
 package detect
 
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"strings"
+
+	ncs "github.com/hybridgroup/go-ncs"
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	"github.com/mewmew/floats/binary16"
+	"gocv.io/x/gocv"
+)
+
+// ncsBackend runs a compiled graph on the original Movidius Neural Compute Stick.
+type ncsBackend struct {
+	Graph        *ncs.Graph
+	NCSDevice    *ncs.Stick
+	Decoder      Decoder
+	GraphWidth   int
+	GraphHeight  int
+	FrameResized gocv.Mat
+	FrameFP32    gocv.Mat
+	SubMat       gocv.Mat
+	MulMat       gocv.Mat
+}
+
+func newNCSBackend(config Config) (*ncsBackend, error) {
+	// Connect to Neural Compute Stick, load graph file
+	result, name := ncs.GetDeviceName(config.StickDeviceNum)
+	if result != ncs.StatusOK {
+		return nil, fmt.Errorf("NCS GetDeviceName #%d, status: %v=%s", config.StickDeviceNum, int(result), ncsStatusToText(int(result)))
+	}
+	log.Infof("Opened Neural Compute Stick: %s", strings.TrimRight(name, "\x00"))
+
+	status, ncsDevice := ncs.OpenDevice(name)
+	if status != ncs.StatusOK {
+		return nil, fmt.Errorf("NCS OpenDevice %s: %v", name, status)
+	}
+
+	data, err := ioutil.ReadFile(config.GraphFileName)
+	if err != nil {
+		return nil, fmt.Errorf("Open Graph File: %s", err)
+	}
+
+	allocateStatus, graph := ncsDevice.AllocateGraph(data)
+	if allocateStatus != ncs.StatusOK {
+		return nil, fmt.Errorf("NCS AllocateGraph: %v", allocateStatus)
+	}
+
+	decoder, err := newConfigDecoder(config, DecoderSSDNCS)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &ncsBackend{
+		Graph:        graph,
+		NCSDevice:    ncsDevice,
+		Decoder:      decoder,
+		GraphWidth:   config.GraphWidth,
+		GraphHeight:  config.GraphHeight,
+		FrameResized: gocv.NewMat(),
+		FrameFP32:    gocv.NewMat(),
+
+		// 21 is the mat.Type(), which matches what was read from the type of Mat that resulted
+		// from converting to 32bit float (d.FrameFP32.Type())
+		SubMat: gocv.NewMatWithSizeFromScalar(gocv.NewScalar(127, 127, 127, 127), config.GraphWidth, config.GraphHeight, 21),
+		MulMat: gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0.007843, 0.007843, 0.007843, 0.007843), config.GraphWidth, config.GraphHeight, 21),
+	}
+	return n, nil
+}
+
+func (n *ncsBackend) Name() string {
+	return BackendNCS
+}
+
+func (n *ncsBackend) Infer(camID string, frameNum int, img gocv.Mat) ([]*frame.Box, error) {
+	// Convert image to format needed by NCS
+	// This model requires us to resize the 300x300 expected, then
+	// transform pixel values from range (0-255) to (-1.0 - 1.0)
+	// In Python this is simple due to overloaded operator:
+	//    resized_image = resized_image - 127.5
+	//    resized_image = resized_image * 0.007843
+	gocv.Resize(img, &n.FrameResized, image.Pt(n.GraphWidth, n.GraphHeight), 0, 0, gocv.InterpolationArea)
+	n.FrameResized.ConvertTo(&n.FrameFP32, gocv.MatTypeCV32F)
+	gocv.Subtract(n.FrameFP32, n.SubMat, &n.FrameFP32)
+	gocv.Multiply(n.FrameFP32, n.MulMat, &n.FrameFP32)
+	fp16Blob := n.FrameFP32.ConvertFp16()
+	defer fp16Blob.Close()
+
+	// Load image tensor into graph on NCS stick
+	loadStatus := n.Graph.LoadTensor(fp16Blob.ToBytes())
+	if loadStatus != ncs.StatusOK {
+		return nil, fmt.Errorf("LoadTensor: %v", loadStatus)
+	}
+
+	// Get result from NCS stick in fp16 format
+	resultStatus, data := n.Graph.GetResult()
+	if resultStatus != ncs.StatusOK {
+		return nil, fmt.Errorf("GetResult: %v", resultStatus)
+	}
+
+	boxes, err := n.Decoder.Decode([]Tensor{fp16Tensor(data)}, img.Cols(), img.Rows())
+	if err != nil {
+		return nil, fmt.Errorf("Decode: %s", err)
+	}
+	return boxes, nil
+}
+
+func (n *ncsBackend) Close() {
+	n.Graph.DeallocateGraph()
+	n.NCSDevice.CloseDevice()
+}
+
+// fp16Tensor converts the raw fp16 result from the stick into floats.
+func fp16Tensor(data []byte) Tensor {
+	t := Tensor{
+		Shape: []int{len(data) / 2},
+		Data:  make([]float32, len(data)/2),
+	}
+	for i := range t.Data {
+		t.Data[i] = float32(floatFromBits(binary.LittleEndian.Uint16(data[i*2:])))
+	}
+	return t
+}
+
+func floatFromBits(bits uint16) float64 {
+	f := binary16.NewFromBits(bits)
+	return f.Float64()
+}
+
 // ncsStatusToText does what should be done in the go-ncs package
 func ncsStatusToText(s int) string {
 	statuses := map[int]string{
@@ -0,0 +1,143 @@
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	"gocv.io/x/gocv"
+)
+
+// opencvBackend runs models (e.g. MobileNet SSD from Caffe or TensorFlow, or YOLO)
+// on the CPU using the OpenCV DNN module.
+type opencvBackend struct {
+	Net         gocv.Net
+	Decoder     Decoder
+	OutputNames []string
+	GraphWidth  int
+	GraphHeight int
+	Scale       float64
+	Mean        gocv.Scalar
+	SwapRB      bool
+}
+
+func newOpenCVBackend(config Config) (*opencvBackend, error) {
+	if config.GraphFileName == "" {
+		return nil, errors.New("Graph file is required")
+	}
+	// ReadNet picks the framework from the file extensions, e.g. .caffemodel and .prototxt,
+	// or .pb and .pbtxt.
+	net := gocv.ReadNet(config.GraphFileName, config.GraphConfigFile)
+	if net.Empty() {
+		return nil, fmt.Errorf("ReadNet could not load: %s, %s", config.GraphFileName, config.GraphConfigFile)
+	}
+	log.Infof("Loaded OpenCV DNN model: %s", config.GraphFileName)
+
+	decoder, err := newConfigDecoder(config, DecoderSSD)
+	if err != nil {
+		return nil, err
+	}
+
+	scale, mean := inputNorm(config, decoderName(config, DecoderSSD))
+	b := &opencvBackend{
+		Net:         net,
+		Decoder:     decoder,
+		OutputNames: config.GraphOutputNames,
+		GraphWidth:  config.GraphWidth,
+		GraphHeight: config.GraphHeight,
+		Scale:       scale,
+		Mean:        gocv.NewScalar(mean, mean, mean, 0),
+		SwapRB:      config.InputSwapRB,
+	}
+	return b, nil
+}
+
+type inputDefault struct {
+	scale float64
+	mean  float64
+}
+
+// inputDefaults are the input scale and mean the models of each decoder usually
+// expect. Decoders not listed get the pixel values as they are.
+var inputDefaults = map[string]inputDefault{
+	// Caffe SSD MobileNet, pixel values from range (0-255) to (-1.0 - 1.0).
+	DecoderSSD:    {scale: 0.007843, mean: 127.5},
+	DecoderSSDNCS: {scale: 0.007843, mean: 127.5},
+	// YOLO, pixel values from range (0-255) to (0.0 - 1.0).
+	DecoderYOLOv3: {scale: 1.0 / 255},
+	DecoderYOLOv5: {scale: 1.0 / 255},
+	DecoderYOLOv8: {scale: 1.0 / 255},
+}
+
+// inputNorm returns the input scale and mean given in config, each falling back to
+// the default for the decoder when unset.
+func inputNorm(config Config, decoder string) (float64, float64) {
+	d, ok := inputDefaults[decoder]
+	if !ok {
+		d = inputDefault{scale: 1}
+	}
+	if config.InputScale != nil {
+		d.scale = *config.InputScale
+	}
+	if config.InputMean != nil {
+		d.mean = *config.InputMean
+	}
+	return d.scale, d.mean
+}
+
+func (b *opencvBackend) Name() string {
+	return BackendOpenCV
+}
+
+func (b *opencvBackend) Infer(camID string, frameNum int, img gocv.Mat) ([]*frame.Box, error) {
+	blob := gocv.BlobFromImage(img, b.Scale, image.Pt(b.GraphWidth, b.GraphHeight), b.Mean, b.SwapRB, false)
+	defer blob.Close()
+
+	b.Net.SetInput(blob, "")
+	var outputs []Tensor
+	if len(b.OutputNames) == 0 {
+		prob := b.Net.Forward("")
+		defer prob.Close()
+		t, err := matTensor(prob)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, t)
+	} else {
+		// Models with several outputs, e.g. the YOLO grid heads.
+		probs := b.Net.ForwardLayers(b.OutputNames)
+		for i := range probs {
+			defer probs[i].Close()
+			t, err := matTensor(probs[i])
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, t)
+		}
+	}
+
+	boxes, err := b.Decoder.Decode(outputs, img.Cols(), img.Rows())
+	if err != nil {
+		return nil, fmt.Errorf("Decode: %s", err)
+	}
+	return boxes, nil
+}
+
+// matTensor copies an output blob out of OpenCV memory.
+func matTensor(m gocv.Mat) (Tensor, error) {
+	data, err := m.DataPtrFloat32()
+	if err != nil {
+		return Tensor{}, fmt.Errorf("output blob: %s", err)
+	}
+	t := Tensor{
+		Shape: m.Size(),
+		Data:  make([]float32, len(data)),
+	}
+	copy(t.Data, data)
+	return t, nil
+}
+
+func (b *opencvBackend) Close() {
+	b.Net.Close()
+}
@@ -0,0 +1,73 @@
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	"gocv.io/x/gocv"
+)
+
+// ReplayFinished is returned once every recorded frame has been replayed.
+var ReplayFinished = errors.New("Replay finished")
+
+// replayBackend returns the boxes from a recording made with the Recorder, instead
+// of running a model. The camera frames are still read, but only used for the box
+// crops and overview, so the camera can point at any local video file.
+type replayBackend struct {
+	Records   map[string]map[int]*Record
+	LastFrame int
+}
+
+func newReplayBackend(config Config) (*replayBackend, error) {
+	if config.ReplayFileName == "" {
+		return nil, errors.New("Replay file is required")
+	}
+	records, err := ReadRecording(config.ReplayFileName)
+	if err != nil {
+		return nil, fmt.Errorf("ReadRecording: %s", err)
+	}
+	b := &replayBackend{
+		Records: records,
+	}
+	for camID, frames := range records {
+		for frameNum := range frames {
+			if frameNum > b.LastFrame {
+				b.LastFrame = frameNum
+			}
+		}
+		log.Infof("Replaying camera %s: %d frames with boxes", camID, len(frames))
+	}
+	return b, nil
+}
+
+func (b *replayBackend) Name() string {
+	return BackendReplay
+}
+
+func (b *replayBackend) Infer(camID string, frameNum int, img gocv.Mat) ([]*frame.Box, error) {
+	if frameNum > b.LastFrame {
+		return nil, ReplayFinished
+	}
+	rec, ok := b.Records[camID][frameNum]
+	if !ok {
+		return []*frame.Box{}, nil
+	}
+
+	// The replayed video may be smaller than the recorded one, keep the boxes inside
+	// the frame so that the crops can still be made.
+	bounds := image.Rect(0, 0, img.Cols(), img.Rows())
+	retBoxes := []*frame.Box{}
+	for _, box := range rec.FrameBoxes() {
+		box.Coords = box.Coords.Intersect(bounds)
+		if box.Coords.Empty() {
+			continue
+		}
+		log.Debugf("replay box: %+v", box)
+		retBoxes = append(retBoxes, box)
+	}
+	return retBoxes, nil
+}
+
+func (b *replayBackend) Close() {}
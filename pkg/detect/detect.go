@@ -1,22 +1,18 @@
 package detect
 
 import (
-	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"io/ioutil"
-	"math"
-	"strings"
+	"os"
 	"sync"
 	"time"
 
-	ncs "github.com/hybridgroup/go-ncs"
 	"github.com/marktheunissen/watchbot/pkg/camera"
 	"github.com/marktheunissen/watchbot/pkg/frame"
-	"github.com/mewmew/floats/binary16"
+	"github.com/marktheunissen/watchbot/pkg/motion"
+	"github.com/marktheunissen/watchbot/pkg/zone"
 	"github.com/sirupsen/logrus"
 	"gocv.io/x/gocv"
 )
@@ -25,116 +21,168 @@ var log = logrus.WithField("component", "detect")
 
 var CamStatusInactive = errors.New("Camera is inactive")
 
+// NoMotion is returned when the motion prefilter skipped inference on a frame.
+var NoMotion = errors.New("No motion in frame")
+
 var bgColor = color.RGBA{0, 215, 0, 0}
+var motionColor = color.RGBA{255, 215, 0, 0}
 
 type Config struct {
-	Cameras        []*camera.Cam
-	StickDeviceNum int
-	GraphFileName  string
-	GraphLabels    []string
-	AlertLabels    []string
-	GraphWidth     int
-	GraphHeight    int
+	Cameras          []*camera.Cam
+	Backend          string
+	StickDeviceNum   int
+	GraphFileName    string
+	GraphConfigFile  string
+	GraphOutputNames []string
+	GraphLabels      []string
+	GraphWidth       int
+	GraphHeight      int
+	InputScale       *float64
+	InputMean        *float64
+	InputSwapRB      bool
+	Decoder          string
+	Anchors          [][]float64
+	MinScore         float64
+	NMSThreshold     float64
+	ReplayFileName   string
+	RecordFileName   string
+
+	// Camera reads blocked for longer than this mark the camera stalled, and reopen it.
+	StallTimeout time.Duration
+
+	// Delay between attempts to reopen a dropped camera, doubling from the min to the
+	// max. After the given number of failed attempts in a row, 0 for unlimited, the
+	// camera is failed until it's reconnected by command or the schedule.
+	ReconnectMin      time.Duration
+	ReconnectMax      time.Duration
+	ReconnectAttempts int
 }
 
 type Detector struct {
 	Cameras      []*camera.Cam
-	Captures     []*gocv.VideoCapture
+	Captures     map[string]*Capture
 	CapturesLock sync.Mutex
-	Graph        *ncs.Graph
+	StallTimeout time.Duration
+	Backoff      Backoff
+	Motion       map[string]*motion.Detector
+	Backend      Backend
+	Recorder     *Recorder
 	GraphLabels  []string
-	AlertLabels  []string
-	NCSDevice    *ncs.Stick
 	Frame        gocv.Mat
 	FrameRaw     gocv.Mat
-	FrameResized gocv.Mat
-	FrameFP32    gocv.Mat
-	GraphWidth   int
-	GraphHeight  int
-	SubMat       gocv.Mat
-	MulMat       gocv.Mat
+
+	// Time the last frame was read, FrameRaw is left as it was read.
+	FrameTime time.Time
+
+	// Copy of the frame the detections are drawn on.
+	annotated gocv.Mat
+
+	// Connection state changes of all the cameras.
+	Events chan ConnEvent
+
+	// Sequence number of the last frame taken from each camera, used to key recordings.
+	frameNums map[string]int
+
+	// Connection count of each camera when its motion detector was last reset.
+	connects map[string]int
+
+	cams map[string]*camera.Cam
 }
 
 func New(config Config) (*Detector, error) {
 	log.Infof("GoCV version: %s", gocv.Version())
 	log.Infof("OpenCV lib version: %s", gocv.OpenCVVersion())
 
-	// Connect to Neural Compute Stick, load graph file
-	result, name := ncs.GetDeviceName(config.StickDeviceNum)
-	if result != ncs.StatusOK {
-		return nil, fmt.Errorf("NCS GetDeviceName #%d, status: %v=%s", config.StickDeviceNum, int(result), ncsStatusToText(int(result)))
+	if config.GraphWidth == 0 || config.GraphHeight == 0 {
+		return nil, errors.New("Graph height and width must be > 0")
 	}
-	log.Infof("Opened Neural Compute Stick: %s", strings.TrimRight(name, "\x00"))
-
-	status, ncsDevice := ncs.OpenDevice(name)
-	if status != ncs.StatusOK {
-		return nil, fmt.Errorf("NCS OpenDevice %s: %v", name, status)
+	if len(config.GraphLabels) == 0 {
+		return nil, errors.New("Graph labels are required")
 	}
 
-	data, err := ioutil.ReadFile(config.GraphFileName)
+	backend, err := newBackend(config)
 	if err != nil {
-		return nil, fmt.Errorf("Open Graph File: %s", err)
-	}
-
-	allocateStatus, graph := ncsDevice.AllocateGraph(data)
-	if allocateStatus != ncs.StatusOK {
-		return nil, fmt.Errorf("NCS AllocateGraph: %v", allocateStatus)
+		return nil, err
 	}
 
-	if config.GraphWidth == 0 || config.GraphHeight == 0 {
-		return nil, errors.New("Graph height and width must be > 0")
+	if config.StallTimeout == 0 {
+		config.StallTimeout = 10 * time.Second
 	}
-	if len(config.AlertLabels) == 0 {
-		log.Warn("no alert labels given to detector, will not alert on anything")
+	if config.ReconnectMin == 0 {
+		config.ReconnectMin = time.Second
 	}
-	if len(config.GraphLabels) == 0 {
-		return nil, errors.New("Graph labels are required")
+	if config.ReconnectMax == 0 {
+		config.ReconnectMax = 5 * time.Minute
 	}
-
 	d := &Detector{
 		Cameras:      config.Cameras,
-		Captures:     make([]*gocv.VideoCapture, len(config.Cameras)),
-		Graph:        graph,
-		NCSDevice:    ncsDevice,
-		GraphLabels:  config.GraphLabels,
-		AlertLabels:  config.AlertLabels,
-		Frame:        gocv.NewMat(),
-		FrameRaw:     gocv.NewMat(),
-		FrameResized: gocv.NewMat(),
-		FrameFP32:    gocv.NewMat(),
-		GraphHeight:  config.GraphHeight,
-		GraphWidth:   config.GraphWidth,
-
-		// 21 is the mat.Type(), which matches what was read from the type of Mat that resulted
-		// from converting to 32bit float (d.FrameFP32.Type())
-		SubMat: gocv.NewMatWithSizeFromScalar(gocv.NewScalar(127, 127, 127, 127), config.GraphWidth, config.GraphHeight, 21),
-		MulMat: gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0.007843, 0.007843, 0.007843, 0.007843), config.GraphWidth, config.GraphHeight, 21),
+		Captures:     map[string]*Capture{},
+		StallTimeout: config.StallTimeout,
+		Backoff: Backoff{
+			Min:      config.ReconnectMin,
+			Max:      config.ReconnectMax,
+			Jitter:   0.2,
+			Attempts: config.ReconnectAttempts,
+		},
+		Motion:      map[string]*motion.Detector{},
+		Backend:     backend,
+		GraphLabels: config.GraphLabels,
+		Frame:       gocv.NewMat(),
+		FrameRaw:    gocv.NewMat(),
+		annotated:   gocv.NewMat(),
+		Events:      make(chan ConnEvent, 100),
+		frameNums:   map[string]int{},
+		connects:    map[string]int{},
+		cams:        map[string]*camera.Cam{},
+	}
+	for _, cam := range config.Cameras {
+		if _, ok := d.cams[cam.ID]; ok {
+			return nil, fmt.Errorf("Duplicate camera ID: %s", cam.ID)
+		}
+		d.cams[cam.ID] = cam
+		if cam.MotionMinArea > 0 {
+			d.Motion[cam.ID] = motion.New(motion.Config{
+				MinAreaPercent: cam.MotionMinArea,
+				Threshold:      cam.MotionThreshold,
+			})
+		}
+	}
+	if config.RecordFileName != "" {
+		d.Recorder, err = NewRecorder(config.RecordFileName)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return d, nil
 }
 
 func (d *Detector) Close() {
 	for _, c := range d.Captures {
-		if c != nil {
-			c.Close()
-		}
+		c.Stop()
+	}
+	for _, m := range d.Motion {
+		m.Close()
+	}
+	d.Backend.Close()
+	d.annotated.Close()
+	if d.Recorder != nil {
+		d.Recorder.Close()
 	}
-	d.Graph.DeallocateGraph()
-	d.NCSDevice.CloseDevice()
 }
 
 // Turn on and off camera feeds depending on whether they are active or not
 func (d *Detector) ToggleFeeds() error {
 	d.CapturesLock.Lock()
 	defer d.CapturesLock.Unlock()
-	for i, cam := range d.Cameras {
-		if cam.IsActive() && d.Captures[i] == nil {
-			err := d.startCamFeed(i)
+	for _, cam := range d.Cameras {
+		_, running := d.Captures[cam.ID]
+		if cam.IsActive() && !running {
+			err := d.startCamFeed(cam)
 			if err != nil {
 				return err
 			}
-		} else if !cam.IsActive() && d.Captures[i] != nil {
-			err := d.stopCamFeed(i)
+		} else if !cam.IsActive() && running {
+			err := d.stopCamFeed(cam)
 			if err != nil {
 				return err
 			}
@@ -148,74 +196,123 @@ func (d *Detector) ToggleFeeds() error {
 	return nil
 }
 
-func (d *Detector) startCamFeed(i int) error {
-	vc, err := gocv.VideoCaptureFile(d.Cameras[i].VideoCaptureURI)
-	if err != nil {
-		return fmt.Errorf("startCamFeed open camera%d: %s", i, err)
-	}
-	log.Infof("Opened video device camera%d: %s", i, d.Cameras[i].VideoCaptureURI)
-	d.Captures[i] = vc
+func (d *Detector) startCamFeed(cam *camera.Cam) error {
+	uri := cam.VideoCaptureURI
+
+	// Video files are read frame by frame, rather than at the speed they decode.
+	_, statErr := os.Stat(uri)
+	lossless := statErr == nil || d.Backend.Name() == BackendReplay
+
+	d.Captures[cam.ID] = OpenCapture(CaptureConfig{
+		CamID:        cam.ID,
+		URI:          uri,
+		Lossless:     lossless,
+		StallTimeout: d.StallTimeout,
+		Backoff:      d.Backoff,
+		Events:       d.Events,
+	})
+	log.Infof("Opening video device camera %s: %s", cam.ID, uri)
 	return nil
 }
 
-func (d *Detector) stopCamFeed(i int) error {
-	err := d.Captures[i].Close()
-	d.Captures[i] = nil
-	if err != nil {
-		return fmt.Errorf("stopCamFeed close camera%d: %s", i, err)
-	}
-	log.Infof("Closed video device camera%d: %s", i, d.Cameras[i].VideoCaptureURI)
+func (d *Detector) stopCamFeed(cam *camera.Cam) error {
+	d.Captures[cam.ID].Stop()
+	delete(d.Captures, cam.ID)
+	log.Infof("Closed video device camera %s: %s", cam.ID, cam.VideoCaptureURI)
 	return nil
 }
 
-func (d *Detector) CamRead(camIndex int, frame *gocv.Mat) error {
+// Reconnect reopens an active camera's feed, e.g. after it failed.
+func (d *Detector) Reconnect(camID string) error {
+	cam, err := d.camera(camID)
+	if err != nil {
+		return err
+	}
 	d.CapturesLock.Lock()
 	defer d.CapturesLock.Unlock()
-	if d.Captures[camIndex] == nil {
+	if _, ok := d.Captures[camID]; !ok {
 		return CamStatusInactive
 	}
-	ok := d.Captures[camIndex].Read(frame)
+	err = d.stopCamFeed(cam)
+	if err != nil {
+		return err
+	}
+	return d.startCamFeed(cam)
+}
+
+func (d *Detector) capture(camID string) *Capture {
+	d.CapturesLock.Lock()
+	defer d.CapturesLock.Unlock()
+	return d.Captures[camID]
+}
+
+// camera returns the camera with the ID, or an error if there is none.
+func (d *Detector) camera(camID string) (*camera.Cam, error) {
+	cam, ok := d.cams[camID]
 	if !ok {
-		return fmt.Errorf("Cam.Read camera%d: no frame", camIndex)
+		return nil, fmt.Errorf("Unknown camera: %s", camID)
 	}
-	if frame.Empty() {
-		return fmt.Errorf("Cam.Read camera%d: empty frame", camIndex)
+	return cam, nil
+}
+
+// CamRead copies the latest frame of the camera, whether or not it was already taken.
+func (d *Detector) CamRead(camID string, frame *gocv.Mat) error {
+	c := d.capture(camID)
+	if c == nil {
+		return CamStatusInactive
 	}
-	log.Debugf("camera%d: read a frame size: %d x %d", camIndex, frame.Cols(), frame.Rows())
-	return nil
+	_, _, err := c.Latest(frame, false)
+	return err
 }
 
-func (d *Detector) SnapshotNextFrame(camIndex int) ([]byte, error) {
+// CaptureStats returns the counters of the camera's capture, false if it is inactive.
+func (d *Detector) CaptureStats(camID string) (CaptureStats, bool) {
+	c := d.capture(camID)
+	if c == nil {
+		return CaptureStats{}, false
+	}
+	return c.Stats(), true
+}
+
+func (d *Detector) SnapshotNextFrame(camID string) ([]byte, error) {
 	frame := gocv.NewMat()
-	err := d.CamRead(camIndex, &frame)
+	err := d.CamRead(camID, &frame)
 	if err != nil {
 		return nil, err
 	}
 	defer frame.Close()
 
-	frameJPEG, err := gocv.IMEncode(".jpg", frame)
+	frameJPEG, err := encodeJPEG(frame)
 	if err != nil {
-		return nil, fmt.Errorf("IMEncode frame camera%d: %s", camIndex, err)
+		return nil, fmt.Errorf("IMEncode frame camera %s: %s", camID, err)
 	}
 	return frameJPEG, nil
 }
 
-func (d *Detector) AnnotateNextFrame(camIndex int) (string, []byte, error) {
-	roiRect := d.Cameras[camIndex].ROIRect
+func (d *Detector) AnnotateNextFrame(camID string) (string, []byte, error) {
+	cam, err := d.camera(camID)
+	if err != nil {
+		return "", nil, err
+	}
+	roiRect := cam.ROIRect
 	frame := gocv.NewMat()
-	err := d.CamRead(camIndex, &frame)
+	err = d.CamRead(camID, &frame)
 	if err != nil {
 		return "", nil, err
 	}
 	defer frame.Close()
 
 	overlay := gocv.NewMat()
+	defer overlay.Close()
 	frame.CopyTo(&overlay)
 
 	msgROI := "none"
 	msgCrop := "none"
-	cropRect := d.Cameras[camIndex].CropRect
+	cropRect := cam.CropRect
+	// Zones and tripwires are relative to the crop, or the whole frame without one.
+	offset := image.Point{}
 	if cropRect != nil {
+		offset = cropRect.Min
 		cropColor := color.RGBA{255, 0, 0, 0}
 		gocv.Rectangle(&overlay, *cropRect, cropColor, 2)
 		msgCrop = fmt.Sprintf("%v %dx%d", cropRect, cropRect.Dx(), cropRect.Dy())
@@ -227,25 +324,64 @@ func (d *Detector) AnnotateNextFrame(camIndex int) (string, []byte, error) {
 			msgROI = fmt.Sprintf("%v %dx%d", roiRect, roiRect.Dx(), roiRect.Dy())
 		}
 	}
+	for _, z := range cam.Zones {
+		zoneColor := color.RGBA{0, 255, 255, 0}
+		if z.Exclude {
+			zoneColor = color.RGBA{255, 0, 255, 0}
+		}
+		drawPolygon(&overlay, z.Polygon, offset, zoneColor)
+		gocv.PutText(&overlay, z.Name, offset.Add(z.Polygon.Bounds().Min).Add(image.Pt(5, 20)), gocv.FontHersheyPlain, 1.2, zoneColor, 2)
+	}
+	for _, t := range cam.Tripwires {
+		wireColor := color.RGBA{255, 255, 0, 0}
+		gocv.Line(&overlay, offset.Add(t.A), offset.Add(t.B), wireColor, 3)
+		gocv.PutText(&overlay, t.Name, offset.Add(t.A).Add(image.Pt(5, -10)), gocv.FontHersheyPlain, 1.2, wireColor, 2)
+	}
 	alpha := 0.5
 	gocv.AddWeighted(overlay, alpha, frame, 1-alpha, 0, &frame)
 
 	caption := fmt.Sprintf("Frame %dx%d, crop: %s, roi: %s", frame.Cols(), frame.Rows(), msgCrop, msgROI)
-	frameJPEG, err := gocv.IMEncode(".jpg", frame)
+	frameJPEG, err := encodeJPEG(frame)
 	if err != nil {
 		return "", nil, fmt.Errorf("IMEncode frame: %s", err)
 	}
 	return caption, frameJPEG, nil
 }
 
-func (d *Detector) DetectNextFrame(camIndex int) (*frame.FrameDetectResult, error) {
-	err := d.CamRead(camIndex, &d.FrameRaw)
+// drawPolygon outlines the polygon, offset by the given point.
+func drawPolygon(img *gocv.Mat, p zone.Polygon, offset image.Point, c color.RGBA) {
+	for i := range p {
+		gocv.Line(img, offset.Add(p[i]), offset.Add(p[(i+1)%len(p)]), c, 2)
+	}
+}
+
+func (d *Detector) DetectNextFrame(camID string) (*frame.FrameDetectResult, error) {
+	cam, err := d.camera(camID)
 	if err != nil {
 		return nil, err
 	}
+	c := d.capture(camID)
+	if c == nil {
+		return nil, CamStatusInactive
+	}
+	frameNum, frameTime, err := c.Latest(&d.FrameRaw, true)
+	if err != nil {
+		return nil, err
+	}
+	d.frameNums[camID] = frameNum
+	d.FrameTime = frameTime
+
+	// Reset the motion background whenever the camera has been reopened.
+	if cs := c.Stats(); cs.Connects != d.connects[camID] {
+		d.connects[camID] = cs.Connects
+		if m, ok := d.Motion[camID]; ok {
+			m.Reset()
+		}
+	}
+	log.Debugf("camera %s: frame %d size: %d x %d", camID, frameNum, d.FrameRaw.Cols(), d.FrameRaw.Rows())
 
 	// Crop image if directed, which can give a better detection result if the aspect ratio is 1:1
-	cropRect := d.Cameras[camIndex].CropRect
+	cropRect := cam.CropRect
 	if cropRect != nil {
 		// .Region creates a new object in C++, need to delete it. Cropping cannot be
 		// configured mid-execution so this should be safe as it will be recreated new iteration.
@@ -256,42 +392,46 @@ func (d *Detector) DetectNextFrame(camIndex int) (*frame.FrameDetectResult, erro
 	}
 	// Debugging tools
 	// gocv.IMWrite("test-run-image-a.jpg", d.Frame)
-	// log.Infof("1. frame: %v, frameresized: %v, framefp32: %v", d.Frame.Type(), d.FrameResized.Type(), d.FrameFP32.Type())
-
-	// Convert image to format needed by NCS
-	// This model requires us to resize the 300x300 expected, then
-	// transform pixel values from range (0-255) to (-1.0 - 1.0)
-	// In Python this is simple due to overloaded operator:
-	//    resized_image = resized_image - 127.5
-	//    resized_image = resized_image * 0.007843
-	gocv.Resize(d.Frame, &d.FrameResized, image.Pt(d.GraphWidth, d.GraphHeight), 0, 0, gocv.InterpolationArea)
-	d.FrameResized.ConvertTo(&d.FrameFP32, gocv.MatTypeCV32F)
-	gocv.Subtract(d.FrameFP32, d.SubMat, &d.FrameFP32)
-	gocv.Multiply(d.FrameFP32, d.MulMat, &d.FrameFP32)
-	fp16Blob := d.FrameFP32.ConvertFp16()
-	defer fp16Blob.Close()
-
-	// Load image tensor into graph on NCS stick
-	loadStatus := d.Graph.LoadTensor(fp16Blob.ToBytes())
-	if loadStatus != ncs.StatusOK {
-		return nil, fmt.Errorf("LoadTensor: %v", loadStatus)
-	}
-
-	// Get result from NCS stick in fp16 format
-	resultStatus, data := d.Graph.GetResult()
-	if resultStatus != ncs.StatusOK {
-		return nil, fmt.Errorf("LoadTensor: %v", resultStatus)
-	}
-
-	fdr := &frame.FrameDetectResult{}
-	fdr.Boxes, err = d.ParseResult(data)
+
+	fdr := &frame.FrameDetectResult{Time: frameTime}
+	if m, ok := d.Motion[camID]; ok {
+		fdr.Motion = m.Detect(d.Frame, cam.ROIRect)
+		// A recording only has boxes for the frames that got past the prefilter when
+		// it was made, and the replayed frames may not even be the same video, so replay
+		// every frame and use the motion only for motion alerts.
+		if fdr.Motion == nil && d.Backend.Name() != BackendReplay {
+			return nil, NoMotion
+		}
+	}
+
+	fdr.Boxes, err = d.Backend.Infer(camID, frameNum, d.Frame)
+	if err == ReplayFinished {
+		return nil, err
+	}
 	if err != nil {
-		return nil, fmt.Errorf("ParseResult: %s", err)
+		return nil, fmt.Errorf("%s Infer: %s", d.Backend.Name(), err)
+	}
+	if d.Recorder != nil {
+		err := d.Recorder.Write(camID, frameNum, fdr.Boxes)
+		if err != nil {
+			log.Errorf("Recorder.Write camera %s: %s", camID, err)
+		}
 	}
 
-	fdr.ParseAlerts(d.AlertLabels)
+	fdr.ParseAlerts(cam.AlertLabels)
+	fdr.SuppressOverlaps(cam.NMS)
 	if len(fdr.Boxes) == 0 {
-		return nil, nil
+		if fdr.Motion == nil || !cam.MotionAlert {
+			return nil, nil
+		}
+		// Motion without any detections, return the frame for a motion alert.
+		d.Frame.CopyTo(&d.annotated)
+		gocv.Rectangle(&d.annotated, fdr.Motion.Rect, motionColor, 2)
+		fdr.JPEGBytes, err = encodeJPEG(d.annotated)
+		if err != nil {
+			return nil, fmt.Errorf("IMEncode frame: %s", err)
+		}
+		return fdr, nil
 	}
 
 	for _, box := range fdr.Boxes {
@@ -300,12 +440,13 @@ func (d *Detector) DetectNextFrame(camIndex int) (*frame.FrameDetectResult, erro
 			return nil, fmt.Errorf("makeCrop: %s", err)
 		}
 	}
+	// Draw on a copy, so that the crops and the frame left for clips are clean.
+	d.Frame.CopyTo(&d.annotated)
 	for _, box := range fdr.Boxes {
-		// Do overlay after making the crop to avoid cropping intersecting box lines.
-		gocv.Rectangle(&d.Frame, box.Coords, bgColor, 2)
+		gocv.Rectangle(&d.annotated, box.Coords, bgColor, 2)
 	}
 
-	fdr.JPEGBytes, err = gocv.IMEncode(".jpg", d.Frame)
+	fdr.JPEGBytes, err = encodeJPEG(d.annotated)
 	if err != nil {
 		return nil, fmt.Errorf("IMEncode frame: %s", err)
 	}
@@ -316,101 +457,19 @@ func (d *Detector) makeCrop(box *frame.Box) error {
 	roi := d.Frame.Region(box.Coords)
 	defer roi.Close()
 	var err error
-	box.JPEGBytes, err = gocv.IMEncode(".jpg", roi)
+	box.JPEGBytes, err = encodeJPEG(roi)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-//   a.	First fp16 value holds the number of valid detections = num_valid.
-//   b.	The next 6 values are unused.
-//   c.	The next (7 * num_valid) values contain the valid detections data
-//       Each group of 7 values will describe an object/box These 7 values in order.
-//       The values are:
-//         0: image_id (always 0)
-//         1: class_id (this is an index into labels)
-//         2: score (this is the probability for the class)
-//         3: box left location within image as number between 0.0 and 1.0
-//         4: box top location within image as number between 0.0 and 1.0
-//         5: box right location within image as number between 0.0 and 1.0
-//         6: box bottom location within image as number between 0.0 and 1.0
-func (d *Detector) ParseResult(data []byte) ([]*frame.Box, error) {
-	var header = struct {
-		Count  uint16
-		Unused [6]uint16
-	}{}
-	buf := bytes.NewReader(data)
-	err := binary.Read(buf, binary.LittleEndian, &header)
+// encodeJPEG copies the encoded image out of the buffer OpenCV allocated for it.
+func encodeJPEG(img gocv.Mat) ([]byte, error) {
+	buf, err := gocv.IMEncode(gocv.JPEGFileExt, img)
+	defer buf.Close()
 	if err != nil {
-		return nil, fmt.Errorf("binary.Read: %s", err)
-	}
-	numBoxes := int(floatFromBits(header.Count))
-
-	retBoxes := []*frame.Box{}
-	var boxdata = struct {
-		ImageID uint16
-		ClassID uint16
-		Score   uint16
-		Left    uint16
-		Top     uint16
-		Right   uint16
-		Bottom  uint16
-	}{}
-	for i := 0; i < numBoxes; i++ {
-		err := binary.Read(buf, binary.LittleEndian, &boxdata)
-		if err != nil {
-			return nil, fmt.Errorf("binary.Read: %s", err)
-		}
-		imageID := floatFromBits(boxdata.ImageID)
-		classID := floatFromBits(boxdata.ClassID)
-		score := floatFromBits(boxdata.Score)
-		left := floatFromBits(boxdata.Left)
-		top := floatFromBits(boxdata.Top)
-		right := floatFromBits(boxdata.Right)
-		bottom := floatFromBits(boxdata.Bottom)
-		if invalidFloat(imageID) || invalidFloat(classID) || invalidFloat(score) || invalidFloat(left) || invalidFloat(top) || invalidFloat(right) || invalidFloat(bottom) {
-			// According to NCS specs, it's desirable to continue here if any floats are invalid
-			continue
-		}
-		if int(classID) > len(d.GraphLabels)-1 || int(classID) < 0 {
-			// Label value returned in the result doesn't match anything we know about,
-			// it's out of range
-			continue
-		}
-
-		X1 := actualPos(left, d.Frame.Cols())
-		Y1 := actualPos(top, d.Frame.Rows())
-		X2 := actualPos(right, d.Frame.Cols())
-		Y2 := actualPos(bottom, d.Frame.Rows())
-		b := &frame.Box{
-			Label:      d.GraphLabels[int(classID)],
-			Confidence: int(score * 100),
-			Coords:     image.Rect(X1, Y1, X2, Y2),
-		}
-		log.Debugf("found box: %+v", b)
-		retBoxes = append(retBoxes, b)
-	}
-	return retBoxes, nil
-}
-
-func invalidFloat(f float64) bool {
-	return math.IsInf(f, 0) || math.IsNaN(f)
-}
-
-func actualPos(frac float64, d int) int {
-	// Boxes can be negative, so set them to border.
-	p := int(frac * float64(d))
-	if p < 0 {
-		p = 0
-	}
-	if p > d {
-		p = d
+		return nil, err
 	}
-	return p
-}
-
-func floatFromBits(bits uint16) float64 {
-	f := binary16.NewFromBits(bits)
-	return f.Float64()
+	return append([]byte(nil), buf.GetBytes()...), nil
 }
@@ -1,17 +1,27 @@
 package detect
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/marktheunissen/watchbot/pkg/camera"
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	"github.com/marktheunissen/watchbot/pkg/motion"
 	h "github.com/marktheunissen/watchbot/pkg/test/helpers"
+	"github.com/marktheunissen/watchbot/pkg/utils"
+	"gocv.io/x/gocv"
 )
 
 func config() Config {
 	camConf := camera.Config{
 		Name:            "testCam",
-		Index:           0,
+		ID:              "test",
 		VideoCaptureURI: "./cartest.mp4",
+		AlertLabels:     []string{"person", "car"},
 	}
 	c, err := camera.New(camConf)
 	if err != nil {
@@ -43,7 +53,6 @@ func config() Config {
 			"train",
 			"tvmonitor",
 		},
-		AlertLabels: []string{"person", "car"},
 		GraphWidth:  300,
 		GraphHeight: 300,
 	}
@@ -52,7 +61,7 @@ func config() Config {
 func TestGrabFrame(t *testing.T) {
 	d, err := New(config())
 	h.FatalIfErr(t, err)
-	fdr, err := d.DetectNextFrame(0)
+	fdr, err := d.DetectNextFrame("test")
 	h.FatalIfErr(t, err)
 	if len(fdr.Boxes) < 2 {
 		t.Fatalf("Did not find 2 boxes in video frame")
@@ -64,3 +73,239 @@ func TestGrabFrame(t *testing.T) {
 	}
 	d.Close()
 }
+
+func TestRecordReplay(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "test-recording")
+	h.FatalIfErr(t, err)
+	defer os.Remove(tmpfile.Name())
+
+	r, err := NewRecorder(tmpfile.Name())
+	h.FatalIfErr(t, err)
+	boxes := []*frame.Box{
+		&frame.Box{Label: "person", Confidence: 60, Coords: *utils.GetRect(10, 20, 30, 40)},
+	}
+	h.FatalIfErr(t, r.Write("front", 5, boxes))
+	h.FatalIfErr(t, r.Write("front", 6, []*frame.Box{}))
+	h.FatalIfErr(t, r.Write("back", 7, boxes))
+	h.FatalIfErr(t, r.Close())
+
+	records, err := ReadRecording(tmpfile.Name())
+	h.FatalIfErr(t, err)
+	if _, ok := records["front"][6]; ok {
+		t.Fatal("frame without boxes should not be recorded")
+	}
+	got := records["back"][7].FrameBoxes()
+	if len(got) != 1 || got[0].Label != "person" || got[0].Coords != boxes[0].Coords {
+		t.Fatalf("want replayed box %+v, got: %+v", boxes[0], got)
+	}
+}
+
+func TestDecodeSSD(t *testing.T) {
+	labels := config().GraphLabels
+	d, err := NewDecoder(DecoderSSD, DecoderConfig{Labels: labels})
+	h.FatalIfErr(t, err)
+	out := Tensor{
+		Shape: []int{1, 1, 2, 7},
+		Data: []float32{
+			0, 15, 0.75, 0.1, 0.2, 0.3, 0.4,
+			0, 99, 0.9, 0.1, 0.2, 0.3, 0.4,
+		},
+	}
+	boxes, err := d.Decode([]Tensor{out}, 100, 200)
+	h.FatalIfErr(t, err)
+	if len(boxes) != 1 {
+		t.Fatalf("want 1 box, out of range class skipped, got: %d", len(boxes))
+	}
+	if boxes[0].Label != "person" || boxes[0].Confidence != 75 || boxes[0].Coords != *utils.GetRect(10, 40, 20, 40) {
+		t.Fatalf("unexpected box: %+v", boxes[0])
+	}
+}
+
+func TestDecodeYOLOv8(t *testing.T) {
+	d, err := NewDecoder(DecoderYOLOv8, DecoderConfig{
+		Labels:      []string{"person", "car"},
+		InputWidth:  100,
+		InputHeight: 100,
+	})
+	h.FatalIfErr(t, err)
+	// 3 candidates, rows are cx, cy, w, h, person score, car score. The second
+	// overlaps the first and is suppressed, the third is below the minimum score.
+	out := Tensor{
+		Shape: []int{1, 6, 3},
+		Data: []float32{
+			50, 52, 20,
+			50, 50, 20,
+			20, 20, 10,
+			40, 40, 10,
+			0.8, 0.6, 0.1,
+			0.1, 0.1, 0.1,
+		},
+	}
+	boxes, err := d.Decode([]Tensor{out}, 200, 200)
+	h.FatalIfErr(t, err)
+	if len(boxes) != 1 {
+		t.Fatalf("want 1 box after suppression, got: %d", len(boxes))
+	}
+	if boxes[0].Label != "person" || boxes[0].Coords != *utils.GetRect(80, 60, 40, 80) {
+		t.Fatalf("unexpected box: %+v", boxes[0])
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Min: time.Second, Max: 10 * time.Second, Jitter: 0.2, Attempts: 3}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, tc := range tests {
+		got := b.Delay(tc.attempt)
+		jitter := time.Duration(0.2 * float64(tc.want))
+		if got < tc.want-jitter || got > tc.want+jitter {
+			t.Errorf("attempt %d: want %v +/- %v, got: %v", tc.attempt, tc.want, jitter, got)
+		}
+	}
+	if b.GiveUp(2) || !b.GiveUp(3) {
+		t.Error("want to give up on the 3rd attempt")
+	}
+	if (Backoff{}).GiveUp(100) {
+		t.Error("want 0 attempts to retry forever")
+	}
+}
+
+// testSource gives frames frames, then blocks until hang is closed.
+type testSource struct {
+	frames int
+	hang   chan struct{}
+}
+
+func (s *testSource) Read(m *gocv.Mat) bool {
+	if s.frames == 0 {
+		<-s.hang
+		return false
+	}
+	s.frames--
+	img := gocv.NewMatWithSize(2, 2, gocv.MatTypeCV8UC3)
+	defer img.Close()
+	img.CopyTo(m)
+	return true
+}
+
+func (s *testSource) Close() error {
+	return nil
+}
+
+func TestCaptureStall(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	opens := 0
+	open := func(uri string) (frameSource, error) {
+		opens++
+		if opens > 1 {
+			return nil, errors.New("could not open")
+		}
+		return &testSource{frames: 2, hang: hang}, nil
+	}
+	events := make(chan ConnEvent, 10)
+	c := startCapture(CaptureConfig{
+		CamID:        "test",
+		StallTimeout: 40 * time.Millisecond,
+		Backoff:      Backoff{Min: 10 * time.Millisecond, Max: 10 * time.Millisecond, Attempts: 2},
+		Events:       events,
+	}, open)
+	defer c.Stop()
+
+	want := []ConnState{ConnConnecting, ConnStreaming, ConnBackoff, ConnConnecting, ConnFailed}
+	for i, state := range want {
+		select {
+		case ev := <-events:
+			if ev.State != state {
+				t.Fatalf("event %d: want %s, got: %+v", i, state, ev)
+			}
+			if ev.State == ConnBackoff && (ev.Attempt != 1 || ev.Err == nil || !strings.Contains(ev.Err.Error(), "stalled")) {
+				t.Fatalf("want the stall to back off on the 1st attempt, got: %+v", ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d: timed out waiting for %s", i, state)
+		}
+	}
+	dst := gocv.NewMat()
+	defer dst.Close()
+	_, _, err := c.Latest(&dst, false)
+	if err != CamStatusFailed {
+		t.Fatalf("want the capture failed, got: %v", err)
+	}
+}
+
+func TestReplayIgnoresMotion(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	open := func(uri string) (frameSource, error) {
+		return &testSource{frames: 3, hang: hang}, nil
+	}
+	c := startCapture(CaptureConfig{CamID: "test", Lossless: true}, open)
+	defer c.Stop()
+
+	// The frames are all the same, so only the first has motion.
+	m := motion.New(motion.Config{MinAreaPercent: 1})
+	defer m.Close()
+	d := &Detector{
+		Captures: map[string]*Capture{"test": c},
+		Motion:   map[string]*motion.Detector{"test": m},
+		Backend: &replayBackend{
+			Records: map[string]map[int]*Record{
+				"test": {2: {Camera: "test", Frame: 2, Boxes: []RecordBox{{Label: "person", Confidence: 80, X2: 1, Y2: 1}}}},
+			},
+			LastFrame: 2,
+		},
+		Frame:     gocv.NewMat(),
+		FrameRaw:  gocv.NewMat(),
+		annotated: gocv.NewMat(),
+		frameNums: map[string]int{},
+		connects:  map[string]int{},
+		cams:      map[string]*camera.Cam{"test": {ID: "test", AlertLabels: []string{"person"}}},
+	}
+	defer d.FrameRaw.Close()
+	defer d.annotated.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		fdr, err := d.DetectNextFrame("test")
+		if err == CamStatusConnecting || err == NoNewFrame {
+			time.Sleep(5 * time.Millisecond)
+			continue
+		}
+		h.FatalIfErr(t, err)
+		if fdr == nil {
+			continue
+		}
+		if len(fdr.Boxes) != 1 || fdr.Boxes[0].Label != "person" || d.frameNums["test"] != 2 {
+			t.Fatalf("want the box of frame 2 replayed, got: %+v", fdr.Boxes)
+		}
+		return
+	}
+	t.Fatal("timed out waiting for the replayed box")
+}
+
+func TestInputNorm(t *testing.T) {
+	c := config()
+	if scale, mean := inputNorm(c, DecoderSSD); scale != 0.007843 || mean != 127.5 {
+		t.Errorf("want SSD defaults, got scale %v, mean %v", scale, mean)
+	}
+	if scale, mean := inputNorm(c, DecoderYOLOv5); scale != 1.0/255 || mean != 0 {
+		t.Errorf("want YOLO defaults, got scale %v, mean %v", scale, mean)
+	}
+
+	// A mean given without a scale is kept, and a given 0 isn't taken as unset.
+	mean := 0.0
+	c.InputMean = &mean
+	if scale, mean := inputNorm(c, DecoderSSD); scale != 0.007843 || mean != 0 {
+		t.Errorf("want configured mean with default scale, got scale %v, mean %v", scale, mean)
+	}
+}
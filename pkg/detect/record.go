@@ -0,0 +1,130 @@
+package detect
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"sync"
+
+	"github.com/marktheunissen/watchbot/pkg/frame"
+)
+
+// Record is a single line of a recording, holding the boxes the backend found in
+// one frame of one camera. Frames without any boxes are not recorded.
+type Record struct {
+	Camera string      `json:"camera"`
+	Frame  int         `json:"frame"`
+	Boxes  []RecordBox `json:"boxes"`
+}
+
+type RecordBox struct {
+	Label      string `json:"label"`
+	Confidence int    `json:"confidence"`
+	X1         int    `json:"x1"`
+	Y1         int    `json:"y1"`
+	X2         int    `json:"x2"`
+	Y2         int    `json:"y2"`
+}
+
+func NewRecord(camID string, frameNum int, boxes []*frame.Box) *Record {
+	r := &Record{
+		Camera: camID,
+		Frame:  frameNum,
+		Boxes:  []RecordBox{},
+	}
+	for _, b := range boxes {
+		r.Boxes = append(r.Boxes, RecordBox{
+			Label:      b.Label,
+			Confidence: b.Confidence,
+			X1:         b.Coords.Min.X,
+			Y1:         b.Coords.Min.Y,
+			X2:         b.Coords.Max.X,
+			Y2:         b.Coords.Max.Y,
+		})
+	}
+	return r
+}
+
+// FrameBoxes returns new boxes for the recorded frame, safe to modify further down
+// the pipeline.
+func (r *Record) FrameBoxes() []*frame.Box {
+	ret := []*frame.Box{}
+	for _, b := range r.Boxes {
+		ret = append(ret, &frame.Box{
+			Label:      b.Label,
+			Confidence: b.Confidence,
+			Coords:     image.Rect(b.X1, b.Y1, b.X2, b.Y2),
+		})
+	}
+	return ret
+}
+
+// Recorder writes the live backend results to a file as JSON lines, which can be
+// used later with the replay backend.
+type Recorder struct {
+	file *os.File
+	enc  *json.Encoder
+	lock sync.Mutex
+}
+
+func NewRecorder(filename string) (*Recorder, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("NewRecorder: %s", err)
+	}
+	log.Infof("Recording detections to: %s", filename)
+	r := &Recorder{
+		file: f,
+		enc:  json.NewEncoder(f),
+	}
+	return r, nil
+}
+
+func (r *Recorder) Write(camID string, frameNum int, boxes []*frame.Box) error {
+	if len(boxes) == 0 {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.enc.Encode(NewRecord(camID, frameNum, boxes))
+}
+
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.file.Close()
+}
+
+// ReadRecording loads all the records in the file, keyed by camera and then frame.
+func ReadRecording(filename string) (map[string]map[int]*Record, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := map[string]map[int]*Record{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		rec := &Record{}
+		err := json.Unmarshal(scanner.Bytes(), rec)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", filename, line, err)
+		}
+		if _, ok := ret[rec.Camera]; !ok {
+			ret[rec.Camera] = map[int]*Record{}
+		}
+		ret[rec.Camera][rec.Frame] = rec
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
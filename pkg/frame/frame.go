@@ -3,8 +3,11 @@ package frame
 import (
 	"fmt"
 	"image"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/marktheunissen/watchbot/pkg/zone"
 	"github.com/sirupsen/logrus"
 )
 
@@ -13,19 +16,158 @@ var log = logrus.WithField("component", "frame")
 type FrameDetectResult struct {
 	Boxes     []*Box
 	JPEGBytes []byte
+
+	// When the frame was read from the camera.
+	Time time.Time
+
+	// Number of duplicate boxes removed by SuppressOverlaps.
+	Suppressed int
+
+	// Set when the motion prefilter found motion in the frame.
+	Motion *Motion
+}
+
+// Motion found in a frame by the motion prefilter.
+type Motion struct {
+	// Changed pixels, and as a percentage of the ROI.
+	Area    int
+	Percent float64
+
+	// Bounds of all the changed areas.
+	Rect image.Rectangle
+}
+
+// NMSConfig controls non-maximum suppression of overlapping boxes with the same label.
+// A threshold is the IoU above which the less confident box is suppressed, 0 disables
+// suppression.
+type NMSConfig struct {
+	Threshold       float64
+	LabelThresholds map[string]float64
+
+	// Merge grows the kept box to cover the boxes it suppressed.
+	Merge bool
+}
+
+func (c NMSConfig) ThresholdFor(label string) float64 {
+	if t, ok := c.LabelThresholds[label]; ok {
+		return t
+	}
+	return c.Threshold
+}
+
+// NMS keeps the most confident of any same label boxes that overlap by more than the
+// label's threshold, and returns the kept boxes and how many were suppressed.
+func NMS(boxes []*Box, config NMSConfig) ([]*Box, int) {
+	sorted := make([]*Box, len(boxes))
+	copy(sorted, boxes)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Confidence > sorted[j].Confidence })
+
+	kept := []*Box{}
+	merged := map[*Box]image.Rectangle{}
+	suppressed := 0
+	for _, b := range sorted {
+		threshold := config.ThresholdFor(b.Label)
+		var into *Box
+		if threshold > 0 {
+			for _, k := range kept {
+				if k.Label == b.Label && k.IoU(b) > threshold {
+					into = k
+					break
+				}
+			}
+		}
+		if into == nil {
+			kept = append(kept, b)
+			merged[b] = b.Coords
+			continue
+		}
+		log.Debugf("suppressed box: %+v, overlaps: %+v", b, into)
+		suppressed++
+		if config.Merge {
+			merged[into] = merged[into].Union(b.Coords)
+		}
+	}
+	// Merge after comparing, so that a growing box doesn't swallow further boxes.
+	for _, k := range kept {
+		k.Coords = merged[k]
+	}
+	return kept, suppressed
+}
+
+func (f *FrameDetectResult) SuppressOverlaps(config NMSConfig) {
+	var n int
+	f.Boxes, n = NMS(f.Boxes, config)
+	f.Suppressed += n
+}
+
+// Limits are the size and confidence limits a box must be within, 0 means no limit.
+type Limits struct {
+	MinConfidence int
+	MinWidth      int
+	MinHeight     int
+	MaxWidth      int
+	MaxHeight     int
+}
+
+// LabelLimits override the camera wide Limits for one label. Nil limits are inherited,
+// so that a label can also remove a limit by setting it to 0.
+type LabelLimits struct {
+	MinConfidence *int
+	MinWidth      *int
+	MinHeight     *int
+	MaxWidth      *int
+	MaxHeight     *int
+}
+
+func (o LabelLimits) String() string {
+	out := []string{}
+	add := func(name string, v *int) {
+		if v != nil {
+			out = append(out, fmt.Sprintf("%s: %d", name, *v))
+		}
+	}
+	add("MinConfidence", o.MinConfidence)
+	add("MinWidth", o.MinWidth)
+	add("MinHeight", o.MinHeight)
+	add("MaxWidth", o.MaxWidth)
+	add("MaxHeight", o.MaxHeight)
+	return "{" + strings.Join(out, " ") + "}"
+}
+
+// Override returns the limits with the ones set in o replacing these.
+func (l Limits) Override(o LabelLimits) Limits {
+	if o.MinConfidence != nil {
+		l.MinConfidence = *o.MinConfidence
+	}
+	if o.MinWidth != nil {
+		l.MinWidth = *o.MinWidth
+	}
+	if o.MinHeight != nil {
+		l.MinHeight = *o.MinHeight
+	}
+	if o.MaxWidth != nil {
+		l.MaxWidth = *o.MaxWidth
+	}
+	if o.MaxHeight != nil {
+		l.MaxHeight = *o.MaxHeight
+	}
+	return l
 }
 
 func (f *FrameDetectResult) RejectSize(minWidth int, minHeight int, maxWidth int, maxHeight int) {
+	limits := Limits{MinWidth: minWidth, MinHeight: minHeight, MaxWidth: maxWidth, MaxHeight: maxHeight}
 	for _, box := range f.Boxes {
-		if minWidth != 0 && box.GetWidth() < minWidth {
-			box.RejectReason = fmt.Sprintf("Rejected width: %d, %s", box.GetWidth(), box.LabelConfidence())
-		} else if minHeight != 0 && box.GetHeight() < minHeight {
-			box.RejectReason = fmt.Sprintf("Rejected height: %d, %s", box.GetWidth(), box.LabelConfidence())
-		} else if maxWidth != 0 && box.GetWidth() > maxWidth {
-			box.RejectReason = fmt.Sprintf("Rejected width: %d, %s", box.GetWidth(), box.LabelConfidence())
-		} else if maxHeight != 0 && box.GetHeight() > maxHeight {
-			box.RejectReason = fmt.Sprintf("Rejected height: %d, %s", box.GetHeight(), box.LabelConfidence())
-		}
+		box.rejectSize(limits)
+	}
+}
+
+// RejectLimits applies the camera wide limits to each box, overridden by any limits
+// given for the box's label.
+func (f *FrameDetectResult) RejectLimits(limits Limits, labelLimits map[string]LabelLimits) {
+	for _, box := range f.Boxes {
+		l := limits.Override(labelLimits[box.Label])
+		box.rejectSize(l)
+		box.rejectLowConfidence(l.MinConfidence)
 	}
 }
 
@@ -51,14 +193,40 @@ func (f *FrameDetectResult) RejectOutsideROI(roi *image.Rectangle) {
 	}
 }
 
-func (f *FrameDetectResult) RejectLowConfidence(minConfidence int) {
-	if minConfidence == 0 {
+// RejectZones rejects boxes inside any exclude zone, and when there are include zones,
+// boxes outside all of them. The include zones a box is in are added to the box.
+func (f *FrameDetectResult) RejectZones(zones []*zone.Zone) {
+	if len(zones) == 0 {
 		return
 	}
 	for _, box := range f.Boxes {
-		if box.Confidence < minConfidence {
-			box.RejectReason = fmt.Sprintf("Rejected confidence: %d below threshold: %d, %s", box.Confidence, minConfidence, box.LabelConfidence())
+		box.Zones = nil
+		includes := 0
+		excluded := ""
+		for _, z := range zones {
+			if !z.Exclude {
+				includes++
+			}
+			if !z.ContainsBox(box.Coords) {
+				continue
+			}
+			if z.Exclude {
+				excluded = z.Name
+				break
+			}
+			box.Zones = append(box.Zones, z.Name)
 		}
+		if excluded != "" {
+			box.RejectReason = fmt.Sprintf("Rejected zone: %v inside mask: %s, %s", box.Coords, excluded, box.LabelConfidence())
+		} else if includes > 0 && len(box.Zones) == 0 {
+			box.RejectReason = fmt.Sprintf("Rejected zone: %v outside zones, %s", box.Coords, box.LabelConfidence())
+		}
+	}
+}
+
+func (f *FrameDetectResult) RejectLowConfidence(minConfidence int) {
+	for _, box := range f.Boxes {
+		box.rejectLowConfidence(minConfidence)
 	}
 }
 
@@ -108,6 +276,30 @@ type Box struct {
 	Coords       image.Rectangle
 	JPEGBytes    []byte
 	RejectReason string
+
+	// Set by the tracker, the same object keeps the same ID across frames.
+	TrackID int
+
+	// Names of the include zones the box is in.
+	Zones []string
+}
+
+func (b *Box) rejectSize(l Limits) {
+	if l.MinWidth != 0 && b.GetWidth() < l.MinWidth {
+		b.RejectReason = fmt.Sprintf("Rejected width: %d, %s", b.GetWidth(), b.LabelConfidence())
+	} else if l.MinHeight != 0 && b.GetHeight() < l.MinHeight {
+		b.RejectReason = fmt.Sprintf("Rejected height: %d, %s", b.GetHeight(), b.LabelConfidence())
+	} else if l.MaxWidth != 0 && b.GetWidth() > l.MaxWidth {
+		b.RejectReason = fmt.Sprintf("Rejected width: %d, %s", b.GetWidth(), b.LabelConfidence())
+	} else if l.MaxHeight != 0 && b.GetHeight() > l.MaxHeight {
+		b.RejectReason = fmt.Sprintf("Rejected height: %d, %s", b.GetHeight(), b.LabelConfidence())
+	}
+}
+
+func (b *Box) rejectLowConfidence(minConfidence int) {
+	if minConfidence != 0 && b.Confidence < minConfidence {
+		b.RejectReason = fmt.Sprintf("Rejected confidence: %d below threshold: %d, %s", b.Confidence, minConfidence, b.LabelConfidence())
+	}
 }
 
 func (b *Box) GetWidth() int {
@@ -118,6 +310,21 @@ func (b *Box) GetHeight() int {
 	return b.Coords.Dy()
 }
 
+// IoU is the intersection over union of the two boxes, from 0 for no overlap up to 1
+// for identical boxes.
+func (b *Box) IoU(o *Box) float64 {
+	inter := b.Coords.Intersect(o.Coords)
+	if inter.Empty() {
+		return 0
+	}
+	interArea := float64(inter.Dx() * inter.Dy())
+	union := float64(b.GetWidth()*b.GetHeight()+o.GetWidth()*o.GetHeight()) - interArea
+	if union <= 0 {
+		return 0
+	}
+	return interArea / union
+}
+
 func (b *Box) IsPortrait() bool {
 	return b.GetWidth() < b.GetHeight()
 }
@@ -129,3 +336,12 @@ func (b *Box) LabelPretty() string {
 func (b *Box) LabelConfidence() string {
 	return fmt.Sprintf("%s: %d%%", b.LabelPretty(), b.Confidence)
 }
+
+// Caption is the label and confidence, and the zones the box is in, e.g.
+// "Person: 80% in driveway".
+func (b *Box) Caption() string {
+	if len(b.Zones) == 0 {
+		return b.LabelConfidence()
+	}
+	return fmt.Sprintf("%s in %s", b.LabelConfidence(), strings.Join(b.Zones, ", "))
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/marktheunissen/watchbot/pkg/utils"
+	"github.com/marktheunissen/watchbot/pkg/zone"
 )
 
 func TestRejectSize(t *testing.T) {
@@ -31,3 +32,102 @@ func TestRejectSize(t *testing.T) {
 		t.Errorf("want height rejectReason, got: %s", f.Boxes[0].RejectReason)
 	}
 }
+
+func TestSuppressOverlaps(t *testing.T) {
+	newResult := func() *FrameDetectResult {
+		return &FrameDetectResult{
+			Boxes: []*Box{
+				&Box{Label: "person", Confidence: 50, Coords: *utils.GetRect(10, 0, 100, 100)},
+				&Box{Label: "person", Confidence: 80, Coords: *utils.GetRect(0, 0, 100, 100)},
+				&Box{Label: "car", Confidence: 60, Coords: *utils.GetRect(0, 0, 100, 100)},
+				&Box{Label: "person", Confidence: 70, Coords: *utils.GetRect(300, 300, 50, 50)},
+			},
+		}
+	}
+
+	f := newResult()
+	f.SuppressOverlaps(NMSConfig{})
+	if len(f.Boxes) != 4 || f.Suppressed != 0 {
+		t.Fatalf("want no suppression when disabled, got: %d boxes, %d suppressed", len(f.Boxes), f.Suppressed)
+	}
+
+	f = newResult()
+	f.SuppressOverlaps(NMSConfig{Threshold: 0.5})
+	if len(f.Boxes) != 3 || f.Suppressed != 1 {
+		t.Fatalf("want 1 person suppressed, got: %d boxes, %d suppressed", len(f.Boxes), f.Suppressed)
+	}
+	if f.Boxes[0].Confidence != 80 || f.Boxes[0].Coords != *utils.GetRect(0, 0, 100, 100) {
+		t.Errorf("want most confident box kept unchanged, got: %+v", f.Boxes[0])
+	}
+
+	f = newResult()
+	f.SuppressOverlaps(NMSConfig{Threshold: 0.5, LabelThresholds: map[string]float64{"person": 0.95}, Merge: true})
+	if f.Suppressed != 0 {
+		t.Fatalf("want label threshold to override default, got: %d suppressed", f.Suppressed)
+	}
+
+	f = newResult()
+	f.SuppressOverlaps(NMSConfig{Threshold: 0.5, Merge: true})
+	if f.Boxes[0].Coords != *utils.GetRect(0, 0, 110, 100) {
+		t.Errorf("want merged box, got: %v", f.Boxes[0].Coords)
+	}
+}
+
+func TestRejectLimits(t *testing.T) {
+	f := &FrameDetectResult{
+		Boxes: []*Box{
+			&Box{Label: "person", Confidence: 30, Coords: *utils.GetRect(0, 0, 100, 100)},
+			&Box{Label: "car", Confidence: 30, Coords: *utils.GetRect(0, 0, 100, 100)},
+			&Box{Label: "car", Confidence: 80, Coords: *utils.GetRect(0, 0, 50, 50)},
+			&Box{Label: "dog", Confidence: 10, Coords: *utils.GetRect(0, 0, 50, 50)},
+			&Box{Label: "dog", Confidence: 10, Coords: *utils.GetRect(0, 0, 20, 20)},
+		},
+	}
+	limit := func(v int) *int { return &v }
+	labelLimits := map[string]LabelLimits{
+		"person": LabelLimits{MinConfidence: limit(20)},
+		"car":    LabelLimits{MinConfidence: limit(70), MinWidth: limit(60)},
+		"dog":    LabelLimits{MinConfidence: limit(0)},
+	}
+	f.RejectLimits(Limits{MinConfidence: 40, MinWidth: 30}, labelLimits)
+	if f.Boxes[0].RejectReason != "" {
+		t.Errorf("want person accepted by label confidence, got: %s", f.Boxes[0].RejectReason)
+	}
+	if !strings.Contains(f.Boxes[1].RejectReason, "confidence") {
+		t.Errorf("want car confidence rejectReason, got: %s", f.Boxes[1].RejectReason)
+	}
+	if !strings.Contains(f.Boxes[2].RejectReason, "width") {
+		t.Errorf("want car width rejectReason, got: %s", f.Boxes[2].RejectReason)
+	}
+	if f.Boxes[3].RejectReason != "" {
+		t.Errorf("want dog accepted with the min confidence set to 0, got: %s", f.Boxes[3].RejectReason)
+	}
+	if !strings.Contains(f.Boxes[4].RejectReason, "width") {
+		t.Errorf("want dog to inherit the camera min width, got: %s", f.Boxes[4].RejectReason)
+	}
+}
+
+func TestRejectZones(t *testing.T) {
+	driveway, _ := zone.New("driveway", "0,100 0,60 60,0 100,0", zone.AnchorBottomCentre, 0, false)
+	footpath, _ := zone.New("footpath", "0,90 100,90 100,100 0,100", zone.AnchorBottomCentre, 0, true)
+	f := &FrameDetectResult{
+		Boxes: []*Box{
+			&Box{Label: "person", Confidence: 80, Coords: *utils.GetRect(10, 0, 20, 50)},
+			&Box{Label: "person", Confidence: 80, Coords: *utils.GetRect(40, 0, 20, 95)},
+			&Box{Label: "person", Confidence: 80, Coords: *utils.GetRect(0, 0, 10, 20)},
+		},
+	}
+	f.RejectZones([]*zone.Zone{driveway, footpath})
+	if f.Boxes[0].RejectReason != "" {
+		t.Errorf("want box in driveway accepted, got: %s", f.Boxes[0].RejectReason)
+	}
+	if f.Boxes[0].Caption() != "Person: 80% in driveway" {
+		t.Errorf("want caption with zone, got: %s", f.Boxes[0].Caption())
+	}
+	if !strings.Contains(f.Boxes[1].RejectReason, "mask: footpath") {
+		t.Errorf("want box on footpath rejected, got: %s", f.Boxes[1].RejectReason)
+	}
+	if !strings.Contains(f.Boxes[2].RejectReason, "outside zones") {
+		t.Errorf("want box outside zones rejected, got: %s", f.Boxes[2].RejectReason)
+	}
+}
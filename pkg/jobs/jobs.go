@@ -1,16 +1,24 @@
 package jobs
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 type Cmd struct {
-	CamIndex int
-	Noun     string
-	Verb     string
-	Obj      string
+	CamID string
+	Noun  string
+	Verb  string
+	Obj   string
 }
 
 type UploadJob struct {
-	CamIndex int
-	Caption  string
-	Data     io.Reader
+	CamID   string
+	Caption string
+	Data    io.Reader
+	Video   bool
+	GIF     bool
+
+	// When it happened, added to the caption in the camera's time zone if set.
+	Time time.Time
 }
@@ -0,0 +1,132 @@
+package motion
+
+import (
+	"image"
+
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	"github.com/sirupsen/logrus"
+	"gocv.io/x/gocv"
+)
+
+var log = logrus.WithField("component", "motion")
+
+type Config struct {
+	// Percentage of the ROI (or whole frame without one) that has to change for the
+	// frame to count as having motion.
+	MinAreaPercent float64
+
+	// How much a pixel's brightness (0-255) has to change to count as changed.
+	Threshold int
+
+	// Frames are scaled down to this width before comparing, which is cheaper and
+	// filters out noise.
+	Width int
+}
+
+// Detector finds motion by comparing each frame of a camera with the previous one.
+type Detector struct {
+	config  Config
+	small   gocv.Mat
+	gray    gocv.Mat
+	prev    gocv.Mat
+	diff    gocv.Mat
+	kernel  gocv.Mat
+	hasPrev bool
+}
+
+func New(config Config) *Detector {
+	if config.Threshold == 0 {
+		config.Threshold = 25
+	}
+	if config.Width == 0 {
+		config.Width = 320
+	}
+	return &Detector{
+		config: config,
+		small:  gocv.NewMat(),
+		gray:   gocv.NewMat(),
+		prev:   gocv.NewMat(),
+		diff:   gocv.NewMat(),
+		kernel: gocv.GetStructuringElement(gocv.MorphRect, image.Pt(3, 3)),
+	}
+}
+
+// Detect compares the frame with the previous one, and returns the motion found inside
+// the ROI, or nil when it is below the minimum area. The first frame after a Reset
+// always counts as motion, as there is nothing to compare with.
+func (m *Detector) Detect(img gocv.Mat, roi *image.Rectangle) *frame.Motion {
+	scale := float64(m.config.Width) / float64(img.Cols())
+	if scale > 1 {
+		scale = 1
+	}
+	size := image.Pt(int(float64(img.Cols())*scale), int(float64(img.Rows())*scale))
+	gocv.Resize(img, &m.small, size, 0, 0, gocv.InterpolationArea)
+	gocv.CvtColor(m.small, &m.gray, gocv.ColorBGRToGray)
+	gocv.GaussianBlur(m.gray, &m.gray, image.Pt(5, 5), 0, 0, gocv.BorderDefault)
+
+	full := image.Rect(0, 0, img.Cols(), img.Rows())
+	if !m.hasPrev {
+		m.gray.CopyTo(&m.prev)
+		m.hasPrev = true
+		return &frame.Motion{Percent: 100, Rect: full}
+	}
+	gocv.AbsDiff(m.gray, m.prev, &m.diff)
+	m.gray.CopyTo(&m.prev)
+	gocv.Threshold(m.diff, &m.diff, float32(m.config.Threshold), 255, gocv.ThresholdBinary)
+	gocv.Dilate(m.diff, &m.diff, m.kernel)
+
+	area := full
+	if roi != nil {
+		area = roi.Intersect(full)
+	}
+	smallArea := image.Rect(
+		int(float64(area.Min.X)*scale),
+		int(float64(area.Min.Y)*scale),
+		int(float64(area.Max.X)*scale),
+		int(float64(area.Max.Y)*scale),
+	).Intersect(image.Rect(0, 0, size.X, size.Y))
+	if smallArea.Empty() {
+		return nil
+	}
+	region := m.diff.Region(smallArea)
+	defer region.Close()
+
+	changed := gocv.CountNonZero(region)
+	percent := 100 * float64(changed) / float64(smallArea.Dx()*smallArea.Dy())
+	log.Debugf("motion: %.2f%% changed", percent)
+	if percent < m.config.MinAreaPercent {
+		return nil
+	}
+
+	// Bounding box of all the changed areas, back in frame coordinates.
+	var rect image.Rectangle
+	contours := gocv.FindContours(region, gocv.RetrievalExternal, gocv.ChainApproxSimple)
+	defer contours.Close()
+	for i := 0; i < contours.Size(); i++ {
+		rect = rect.Union(gocv.BoundingRect(contours.At(i)))
+	}
+	rect = rect.Add(smallArea.Min)
+	return &frame.Motion{
+		Area:    int(float64(changed) / (scale * scale)),
+		Percent: percent,
+		Rect: image.Rect(
+			int(float64(rect.Min.X)/scale),
+			int(float64(rect.Min.Y)/scale),
+			int(float64(rect.Max.X)/scale),
+			int(float64(rect.Max.Y)/scale),
+		).Intersect(full),
+	}
+}
+
+// Reset forgets the previous frame, e.g. when the camera feed is reopened.
+func (m *Detector) Reset() {
+	m.hasPrev = false
+}
+
+func (m *Detector) Close() {
+	m.small.Close()
+	m.gray.Close()
+	m.prev.Close()
+	m.diff.Close()
+	m.kernel.Close()
+}
@@ -29,21 +29,31 @@ func TableJpeg(data [][]string, header []string) (*bytes.Buffer, error) {
 	table.SetBorder(false)
 	table.Render()
 
-	// Create an image context and print the text table into it.
-	dc := gg.NewContext(380, 380)
-	dc.SetRGB(1, 1, 1)
-	dc.Clear()
-	dc.SetRGB(0, 0, 0)
-
 	// Load the font
 	face, err := loadFont()
 	if err != nil {
 		return jpegBytes, err
 	}
+
+	// Create an image context big enough for the text table, and print it into it.
+	lines := strings.Split(textBytes.String(), "\n")
+	width, height := 380, 380
+	for _, s := range lines {
+		if w := font.MeasureString(face, s).Ceil() + 10; w > width {
+			width = w
+		}
+	}
+	if h := 20 + 14*len(lines); h > height {
+		height = h
+	}
+	dc := gg.NewContext(width, height)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0, 0, 0)
 	dc.SetFontFace(face)
 
 	// Draw using the font onto a new image.
-	for i, s := range strings.Split(textBytes.String(), "\n") {
+	for i, s := range lines {
 		dc.DrawString(s, 0, 20+(14*float64(i)))
 	}
 
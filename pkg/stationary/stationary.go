@@ -0,0 +1,214 @@
+package stationary
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/marktheunissen/watchbot/pkg/datastore"
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.WithField("component", "stationary")
+
+// Store persists the stationary objects, so that they survive restarts.
+type Store interface {
+	StationaryList() ([]datastore.Stationary, error)
+	StationaryAdd(st datastore.Stationary) (int64, error)
+	StationaryRemove(id int64) error
+	StationaryClear() error
+}
+
+type Config struct {
+	// A track whose box stays within MinIoU of where it started for After becomes
+	// stationary. 0 disables.
+	After  time.Duration
+	MinIoU float64
+
+	// Stationary objects not seen for Forget are removed, so that they alert again
+	// when they come back. Tracks on their way to becoming stationary get the same
+	// grace period, so a missed detection doesn't start their timing again.
+	Forget time.Duration
+}
+
+type object struct {
+	datastore.Stationary
+	lastSeen time.Time
+}
+
+type candidate struct {
+	box      *frame.Box
+	since    time.Time
+	lastSeen time.Time
+}
+
+// Filter learns the detections that don't move, e.g. parked cars, and rejects them.
+type Filter struct {
+	config     Config
+	store      Store
+	objects    []*object
+	candidates map[int]*candidate
+	lock       sync.Mutex
+}
+
+// New loads the stationary objects from the store. They count as just seen, so that
+// they have until Forget to be detected again.
+func New(config Config, store Store) (*Filter, error) {
+	if config.MinIoU == 0 {
+		config.MinIoU = 0.8
+	}
+	if config.Forget == 0 {
+		config.Forget = 5 * time.Minute
+	}
+	f := &Filter{
+		config:     config,
+		store:      store,
+		candidates: map[int]*candidate{},
+	}
+	if config.After == 0 {
+		return f, nil
+	}
+	list, err := store.StationaryList()
+	if err != nil {
+		return nil, fmt.Errorf("StationaryList: %s", err)
+	}
+	now := time.Now()
+	for _, st := range list {
+		f.objects = append(f.objects, &object{Stationary: st, lastSeen: now})
+	}
+	return f, nil
+}
+
+func (f *Filter) Enabled() bool {
+	return f.config.After > 0
+}
+
+// Reject rejects the boxes that match a stationary object, and learns new ones from
+// the tracked boxes. It returns the IDs of the tracks that were rejected, whose alert
+// state should be reset so that they alert again once they move.
+func (f *Filter) Reject(boxes []*frame.Box, now time.Time) []int {
+	if !f.Enabled() {
+		return nil
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	rejected := []int{}
+	for _, box := range boxes {
+		if box.RejectReason != "" {
+			// Still in view, e.g. just below the min confidence in this frame.
+			if c := f.candidates[box.TrackID]; c != nil && box.TrackID != 0 {
+				c.lastSeen = now
+			}
+			continue
+		}
+		if obj := f.match(box); obj != nil {
+			obj.lastSeen = now
+			box.RejectReason = fmt.Sprintf("Rejected stationary: since %s, %s", obj.Since.Format("Jan 2 15:04"), box.LabelConfidence())
+			rejected = append(rejected, box.TrackID)
+			continue
+		}
+		if box.TrackID == 0 {
+			continue
+		}
+		c := f.candidates[box.TrackID]
+		if c == nil || c.box.Label != box.Label || c.box.IoU(box) < f.config.MinIoU {
+			// New, or moved, so start timing again from here.
+			f.candidates[box.TrackID] = &candidate{box: &frame.Box{Label: box.Label, Coords: box.Coords}, since: now, lastSeen: now}
+			continue
+		}
+		c.lastSeen = now
+		if now.Sub(c.since) < f.config.After {
+			continue
+		}
+		f.add(c, now)
+		delete(f.candidates, box.TrackID)
+		box.RejectReason = fmt.Sprintf("Rejected stationary: since %s, %s", c.since.Format("Jan 2 15:04"), box.LabelConfidence())
+		rejected = append(rejected, box.TrackID)
+	}
+	for id, c := range f.candidates {
+		if now.Sub(c.lastSeen) > f.config.Forget {
+			delete(f.candidates, id)
+		}
+	}
+	f.forget(now)
+	return rejected
+}
+
+// Hold keeps the stationary objects for frames that were not inspected because
+// nothing changed.
+func (f *Filter) Hold(now time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, obj := range f.objects {
+		obj.lastSeen = now
+	}
+}
+
+func (f *Filter) match(box *frame.Box) *object {
+	for _, obj := range f.objects {
+		if obj.Label != box.Label {
+			continue
+		}
+		ob := &frame.Box{Coords: obj.Rect}
+		if ob.IoU(box) >= f.config.MinIoU {
+			return obj
+		}
+	}
+	return nil
+}
+
+func (f *Filter) add(c *candidate, now time.Time) {
+	obj := &object{
+		Stationary: datastore.Stationary{
+			Label: c.box.Label,
+			Rect:  c.box.Coords,
+			Since: c.since,
+		},
+		lastSeen: now,
+	}
+	id, err := f.store.StationaryAdd(obj.Stationary)
+	if err != nil {
+		log.Errorf("StationaryAdd: %s", err)
+	}
+	obj.ID = id
+	f.objects = append(f.objects, obj)
+	log.Infof("stationary %s at %v since %s", obj.Label, obj.Rect, obj.Since.Format("15:04:05"))
+}
+
+func (f *Filter) forget(now time.Time) {
+	kept := []*object{}
+	for _, obj := range f.objects {
+		if now.Sub(obj.lastSeen) <= f.config.Forget {
+			kept = append(kept, obj)
+			continue
+		}
+		log.Infof("stationary %s at %v gone", obj.Label, obj.Rect)
+		err := f.store.StationaryRemove(obj.ID)
+		if err != nil {
+			log.Errorf("StationaryRemove: %s", err)
+		}
+	}
+	f.objects = kept
+}
+
+// Objects returns a copy of the stationary objects.
+func (f *Filter) Objects() []datastore.Stationary {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ret := []datastore.Stationary{}
+	for _, obj := range f.objects {
+		ret = append(ret, obj.Stationary)
+	}
+	return ret
+}
+
+// Clear forgets all stationary objects, so that they alert again.
+func (f *Filter) Clear() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.objects = nil
+	f.candidates = map[int]*candidate{}
+	return f.store.StationaryClear()
+}
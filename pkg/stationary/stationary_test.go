@@ -0,0 +1,123 @@
+package stationary
+
+import (
+	"image"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marktheunissen/watchbot/pkg/datastore"
+	"github.com/marktheunissen/watchbot/pkg/frame"
+)
+
+type memStore struct {
+	list   []datastore.Stationary
+	nextID int64
+}
+
+func (m *memStore) StationaryList() ([]datastore.Stationary, error) {
+	return m.list, nil
+}
+
+func (m *memStore) StationaryAdd(st datastore.Stationary) (int64, error) {
+	m.nextID++
+	st.ID = m.nextID
+	m.list = append(m.list, st)
+	return st.ID, nil
+}
+
+func (m *memStore) StationaryRemove(id int64) error {
+	kept := []datastore.Stationary{}
+	for _, st := range m.list {
+		if st.ID != id {
+			kept = append(kept, st)
+		}
+	}
+	m.list = kept
+	return nil
+}
+
+func (m *memStore) StationaryClear() error {
+	m.list = nil
+	return nil
+}
+
+func car(x int) *frame.Box {
+	return &frame.Box{Label: "car", Confidence: 90, Coords: image.Rect(x, 100, x+200, 200), TrackID: 1}
+}
+
+func TestStationary(t *testing.T) {
+	store := &memStore{}
+	f, err := New(Config{After: 10 * time.Minute}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i <= 10; i++ {
+		box := car(100 + i%2)
+		f.Reject([]*frame.Box{box}, start.Add(time.Duration(i)*time.Minute))
+		if i < 10 && box.RejectReason != "" {
+			t.Fatalf("want car accepted before 10 minutes, got: %s", box.RejectReason)
+		}
+		if i == 10 && !strings.Contains(box.RejectReason, "stationary") {
+			t.Fatalf("want car stationary after 10 minutes, got: %q", box.RejectReason)
+		}
+	}
+	if len(store.list) != 1 {
+		t.Fatalf("want stationary car stored, got: %+v", store.list)
+	}
+
+	// A new track in the same place, e.g. after a restart, is still stationary.
+	f, _ = New(Config{After: 10 * time.Minute}, store)
+	box := car(100)
+	box.TrackID = 2
+	rejected := f.Reject([]*frame.Box{box}, time.Now())
+	if len(rejected) != 1 || rejected[0] != 2 {
+		t.Errorf("want track 2 rejected after reload, got: %v", rejected)
+	}
+
+	// Moving away alerts again.
+	box = car(300)
+	f.Reject([]*frame.Box{box}, time.Now())
+	if box.RejectReason != "" {
+		t.Errorf("want moved car accepted, got: %s", box.RejectReason)
+	}
+
+	// Gone for longer than Forget, it is removed.
+	f.Reject(nil, time.Now().Add(time.Hour))
+	if len(f.Objects()) != 0 || len(store.list) != 0 {
+		t.Errorf("want stationary car forgotten, got: %+v", f.Objects())
+	}
+}
+
+func TestStationaryMissedFrames(t *testing.T) {
+	store := &memStore{}
+	f, err := New(Config{After: 10 * time.Minute}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i <= 10; i++ {
+		now := start.Add(time.Duration(i) * time.Minute)
+		switch i {
+		case 3:
+			// Nothing detected in the frame.
+			f.Reject(nil, now)
+			continue
+		case 6:
+			// Detected, but rejected before it got here.
+			box := car(100)
+			box.RejectReason = "Rejected confidence"
+			f.Reject([]*frame.Box{box}, now)
+			continue
+		}
+		box := car(100)
+		f.Reject([]*frame.Box{box}, now)
+		if i < 10 && box.RejectReason != "" {
+			t.Fatalf("want car accepted before 10 minutes, got: %s", box.RejectReason)
+		}
+		if i == 10 && !strings.Contains(box.RejectReason, "stationary") {
+			t.Fatalf("want car stationary after 10 minutes despite missed frames, got: %q", box.RejectReason)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Package sun works out sunrise and sunset from a latitude and longitude, with the
+// sunrise equation, accurate to a minute or two away from the poles.
+package sun
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Coords are in degrees, north and east positive.
+type Coords struct {
+	Lat float64
+	Lon float64
+}
+
+func (c Coords) String() string {
+	return fmt.Sprintf("%v,%v", c.Lat, c.Lon)
+}
+
+func (c Coords) Validate() error {
+	if c.Lat < -90 || c.Lat > 90 {
+		return fmt.Errorf("Invalid latitude: %v", c.Lat)
+	}
+	if c.Lon < -180 || c.Lon > 180 {
+		return fmt.Errorf("Invalid longitude: %v", c.Lon)
+	}
+	return nil
+}
+
+// Day is the sunrise and sunset of one date. Inside the polar circles the sun can stay
+// up or down all day, and then the times are zero.
+type Day struct {
+	Sunrise    time.Time
+	Sunset     time.Time
+	AlwaysUp   bool
+	AlwaysDown bool
+}
+
+// Times returns the sunrise and sunset on the date of t, in t's location.
+func Times(t time.Time, c Coords) Day {
+	noon := time.Date(t.Year(), t.Month(), t.Day(), 12, 0, 0, 0, t.Location())
+
+	// Days since 2000-01-01 12:00 UTC, to the solar noon nearest the local one.
+	n := math.Floor(julian(noon) - 2451545.0 + c.Lon/360 + 0.5)
+	mean := n - c.Lon/360
+
+	anomaly := math.Mod(357.5291+0.98560028*mean, 360)
+	center := 1.9148*sin(anomaly) + 0.02*sin(2*anomaly) + 0.0003*sin(3*anomaly)
+	ecliptic := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := 2451545.0 + mean + 0.0053*sin(anomaly) - 0.0069*sin(2*ecliptic)
+
+	sinDecl := sin(ecliptic) * sin(23.4397)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	// -0.833 degrees allows for refraction and the size of the sun's disc.
+	cosHour := (sin(-0.833) - sin(c.Lat)*sinDecl) / (cos(c.Lat) * cosDecl)
+	if cosHour < -1 {
+		return Day{AlwaysUp: true}
+	}
+	if cosHour > 1 {
+		return Day{AlwaysDown: true}
+	}
+	hour := math.Acos(cosHour) * 180 / math.Pi
+	return Day{
+		Sunrise: fromJulian(transit-hour/360, t.Location()),
+		Sunset:  fromJulian(transit+hour/360, t.Location()),
+	}
+}
+
+func julian(t time.Time) float64 {
+	return float64(t.Unix())/86400 + 2440587.5
+}
+
+func fromJulian(j float64, loc *time.Location) time.Time {
+	secs := (j - 2440587.5) * 86400
+	return time.Unix(0, int64(secs*1e9)).Round(time.Second).In(loc)
+}
+
+func sin(deg float64) float64 {
+	return math.Sin(deg * math.Pi / 180)
+}
+
+func cos(deg float64) float64 {
+	return math.Cos(deg * math.Pi / 180)
+}
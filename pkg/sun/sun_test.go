@@ -0,0 +1,57 @@
+package sun
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimes(t *testing.T) {
+	tests := []struct {
+		name    string
+		coords  Coords
+		date    time.Time
+		sunrise time.Time
+		sunset  time.Time
+	}{
+		{
+			name:    "London midsummer",
+			coords:  Coords{Lat: 51.5074, Lon: -0.1278},
+			date:    time.Date(2018, 6, 21, 0, 0, 0, 0, time.UTC),
+			sunrise: time.Date(2018, 6, 21, 3, 43, 0, 0, time.UTC),
+			sunset:  time.Date(2018, 6, 21, 20, 21, 0, 0, time.UTC),
+		},
+		{
+			name:    "Cape Town midwinter",
+			coords:  Coords{Lat: -33.9249, Lon: 18.4241},
+			date:    time.Date(2018, 6, 21, 0, 0, 0, 0, time.FixedZone("SAST", 2*3600)),
+			sunrise: time.Date(2018, 6, 21, 7, 51, 0, 0, time.FixedZone("SAST", 2*3600)),
+			sunset:  time.Date(2018, 6, 21, 17, 44, 0, 0, time.FixedZone("SAST", 2*3600)),
+		},
+		{
+			name:    "San Francisco, west of its zone",
+			coords:  Coords{Lat: 37.7749, Lon: -122.4194},
+			date:    time.Date(2018, 12, 21, 0, 0, 0, 0, time.FixedZone("PST", -8*3600)),
+			sunrise: time.Date(2018, 12, 21, 7, 21, 0, 0, time.FixedZone("PST", -8*3600)),
+			sunset:  time.Date(2018, 12, 21, 16, 54, 0, 0, time.FixedZone("PST", -8*3600)),
+		},
+	}
+	for _, test := range tests {
+		day := Times(test.date, test.coords)
+		if diff := day.Sunrise.Sub(test.sunrise); diff < -2*time.Minute || diff > 2*time.Minute {
+			t.Errorf("%s: want sunrise %s, got: %s", test.name, test.sunrise, day.Sunrise)
+		}
+		if diff := day.Sunset.Sub(test.sunset); diff < -2*time.Minute || diff > 2*time.Minute {
+			t.Errorf("%s: want sunset %s, got: %s", test.name, test.sunset, day.Sunset)
+		}
+	}
+}
+
+func TestPolar(t *testing.T) {
+	tromso := Coords{Lat: 69.6492, Lon: 18.9553}
+	if day := Times(time.Date(2018, 6, 21, 0, 0, 0, 0, time.UTC), tromso); !day.AlwaysUp {
+		t.Fatalf("want midnight sun, got: %+v", day)
+	}
+	if day := Times(time.Date(2018, 12, 21, 0, 0, 0, 0, time.UTC), tromso); !day.AlwaysDown {
+		t.Fatalf("want polar night, got: %+v", day)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/marktheunissen/watchbot/pkg/jobs"
+	"github.com/marktheunissen/watchbot/pkg/utils"
 	"github.com/sirupsen/logrus"
 )
 
@@ -46,7 +47,7 @@ func New(config Config) (*Bot, error) {
 	return bot, nil
 }
 
-func (b *Bot) PollUpdatesToChan(camIndex int, c chan jobs.Cmd) {
+func (b *Bot) PollUpdatesToChan(camID string, c chan jobs.Cmd) {
 	config := tgbotapi.UpdateConfig{
 		Offset:  0,
 		Limit:   100,
@@ -69,7 +70,7 @@ func (b *Bot) PollUpdatesToChan(camIndex int, c chan jobs.Cmd) {
 			if update.UpdateID >= config.Offset {
 				config.Offset = update.UpdateID + 1
 				cmd := b.UpdateToCmd(update)
-				cmd.CamIndex = camIndex
+				cmd.CamID = camID
 				c <- cmd
 			}
 		}
@@ -85,27 +86,22 @@ func (b *Bot) UpdateToCmd(update tgbotapi.Update) jobs.Cmd {
 		log.Debugf("skipping message for chat ID %d since it's not in our chatroom", update.Message.Chat.ID)
 		return cmd
 	}
-	pieces := strings.SplitN(strings.TrimSpace(update.Message.Text), " ", 4)
-	if len(pieces) == 0 {
+	// The words can be split by new lines as well, so that the object can be pasted
+	// in on lines of its own.
+	prefix, rest := utils.CutWord(update.Message.Text)
+	if strings.ToLower(prefix) != "bot" && strings.ToLower(prefix) != "b" {
 		return cmd
 	}
-	if strings.ToLower(pieces[0]) != "bot" && strings.ToLower(pieces[0]) != "b" {
-		return cmd
-	}
-	if len(pieces) > 1 {
-		cmd.Noun = strings.ToLower(strings.TrimSpace(pieces[1]))
-	}
-	if len(pieces) > 2 {
-		cmd.Verb = strings.ToLower(strings.TrimSpace(pieces[2]))
-	}
-	if len(pieces) > 3 {
-		cmd.Obj = strings.TrimSpace(pieces[3])
-	}
+	noun, rest := utils.CutWord(rest)
+	verb, rest := utils.CutWord(rest)
+	cmd.Noun = strings.ToLower(noun)
+	cmd.Verb = strings.ToLower(verb)
+	cmd.Obj = rest
 	return cmd
 }
 
-// SendEvents sends pics to Telegram
-func (b *Bot) SendEvents(labels []string, data io.Reader, isAlert bool) error {
+// SendEvents sends pics to Telegram, without a notification sound if silent.
+func (b *Bot) SendEvents(labels []string, data io.Reader, isAlert bool, silent bool) error {
 	fr := tgbotapi.FileReader{
 		Name:   "Event",
 		Reader: data,
@@ -113,13 +109,7 @@ func (b *Bot) SendEvents(labels []string, data io.Reader, isAlert bool) error {
 	}
 	conf := tgbotapi.NewPhotoUpload(int64(0), fr)
 
-	// It actually only uses this conf.BaseChat.ChannelUsername. Secret channels
-	// start with `-`, and just using the int64 causes an error that it can't find it.
-	if isAlert {
-		conf.BaseChat.ChannelUsername = b.AlertGroupID
-	} else {
-		conf.BaseChat.ChannelUsername = b.CommandGroupID
-	}
+	b.setChat(&conf.BaseChat, isAlert, silent)
 	conf.Caption = ""
 	for _, l := range labels {
 		conf.Caption = conf.Caption + l + "\n"
@@ -134,6 +124,48 @@ func (b *Bot) SendEvents(labels []string, data io.Reader, isAlert bool) error {
 	return err
 }
 
+// setChat addresses the upload to the alert group or otherwise the command group.
+func (b *Bot) setChat(chat *tgbotapi.BaseChat, isAlert bool, silent bool) {
+	// It actually only uses this chat.ChannelUsername. Secret channels start with
+	// `-`, and just using the int64 causes an error that it can't find it.
+	if isAlert {
+		chat.ChannelUsername = b.AlertGroupID
+	} else {
+		chat.ChannelUsername = b.CommandGroupID
+	}
+	chat.DisableNotification = silent
+}
+
+// SendVideo uploads an MP4, to the alert group or otherwise the command group.
+func (b *Bot) SendVideo(caption string, data io.Reader, isAlert bool, silent bool) error {
+	fr := tgbotapi.FileReader{
+		Name:   "Event.mp4",
+		Reader: data,
+		Size:   -1,
+	}
+	conf := tgbotapi.NewVideoUpload(int64(0), fr)
+	b.setChat(&conf.BaseChat, isAlert, silent)
+	conf.Caption = caption
+	log.Infof("Sending video with caption: '%s'", caption)
+	_, err := b.tgBot.Send(conf)
+	return err
+}
+
+// SendGIF uploads an animated GIF as a document, which Telegram shows as an animation.
+func (b *Bot) SendGIF(caption string, data io.Reader, isAlert bool, silent bool) error {
+	fr := tgbotapi.FileReader{
+		Name:   "Event.gif",
+		Reader: data,
+		Size:   -1,
+	}
+	conf := tgbotapi.NewDocumentUpload(int64(0), fr)
+	b.setChat(&conf.BaseChat, isAlert, silent)
+	conf.Caption = caption
+	log.Infof("Sending GIF with caption: '%s'", caption)
+	_, err := b.tgBot.Send(conf)
+	return err
+}
+
 // SendMsg sends a message
 func (b *Bot) SendMsg(msg string) error {
 	conf := tgbotapi.NewMessage(int64(0), msg)
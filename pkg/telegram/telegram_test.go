@@ -20,11 +20,11 @@ func TestTelegram(t *testing.T) {
 	f, err := os.Open("../../test-fixtures/images/pika.jpg")
 	h.FatalIfErr(t, err)
 
-	err = bot.SendEvents([]string{"Alert!"}, f, true)
+	err = bot.SendEvents([]string{"Alert!"}, f, true, false)
 
 	f, err = os.Open("../../test-fixtures/images/pika.jpg")
 	h.FatalIfErr(t, err)
 
-	err = bot.SendEvents([]string{"Command response"}, f, false)
+	err = bot.SendEvents([]string{"Command response"}, f, false, false)
 	h.FatalIfErr(t, err)
 }
@@ -0,0 +1,63 @@
+package tracker
+
+// kalman is a constant velocity Kalman filter for a single coordinate, with
+// position and velocity as the state, and position as the measurement.
+type kalman struct {
+	x float64
+	v float64
+	p [2][2]float64
+
+	// Process noise, the variance of the acceleration per second.
+	q float64
+
+	// Measurement noise, the variance of the detected position.
+	r float64
+}
+
+func newKalman(x float64) *kalman {
+	k := &kalman{
+		x: x,
+		q: 200,
+		r: 50,
+	}
+	// Position is as good as the measurement, velocity is unknown.
+	k.p[0][0] = k.r
+	k.p[1][1] = 10000
+	return k
+}
+
+// predict moves the state forward by dt seconds.
+func (k *kalman) predict(dt float64) {
+	if dt <= 0 {
+		return
+	}
+	k.x += k.v * dt
+
+	// P = F P F' + Q, with F = [1 dt; 0 1]
+	p00 := k.p[0][0] + dt*(k.p[1][0]+k.p[0][1]) + dt*dt*k.p[1][1]
+	p01 := k.p[0][1] + dt*k.p[1][1]
+	p10 := k.p[1][0] + dt*k.p[1][1]
+	p11 := k.p[1][1]
+	dt2 := dt * dt
+	k.p[0][0] = p00 + k.q*dt2*dt2/4
+	k.p[0][1] = p01 + k.q*dt2*dt/2
+	k.p[1][0] = p10 + k.q*dt2*dt/2
+	k.p[1][1] = p11 + k.q*dt2
+}
+
+// update corrects the state with a measured position.
+func (k *kalman) update(z float64) {
+	s := k.p[0][0] + k.r
+	k0 := k.p[0][0] / s
+	k1 := k.p[1][0] / s
+	y := z - k.x
+	k.x += k0 * y
+	k.v += k1 * y
+
+	// P = (I - K H) P, with H = [1 0]
+	p00, p01 := k.p[0][0], k.p[0][1]
+	k.p[0][0] -= k0 * p00
+	k.p[0][1] -= k0 * p01
+	k.p[1][0] -= k1 * p00
+	k.p[1][1] -= k1 * p01
+}
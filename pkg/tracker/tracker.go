@@ -0,0 +1,303 @@
+package tracker
+
+import (
+	"image"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.WithField("component", "tracker")
+
+type Config struct {
+	// How long a track lives on without being matched to a box.
+	MaxAge time.Duration
+
+	// A box matches a track if the IoU with the track's predicted box is at least
+	// MinIoU, or failing that, if their centres are closer than MaxDistance, given as
+	// a fraction of the track box diagonal.
+	MinIoU      float64
+	MaxDistance float64
+
+	// Number of centre points kept in each track's path.
+	MaxPath int
+}
+
+// Track is an object followed across frames.
+type Track struct {
+	ID        int
+	Label     string
+	FirstSeen time.Time
+	LastSeen  time.Time
+
+	// Smoothed box, and the path of its centre over time.
+	Box  image.Rectangle
+	Path []image.Point
+
+	// Smoothed box as of the hit before the last, to tell how the object moved.
+	PrevBox image.Rectangle
+	hitBox  image.Rectangle
+
+	// Frames in which the track was matched to a box.
+	Hits int
+
+	// Whether an alert has been sent for this track.
+	Alerted bool
+
+	cx, cy, w, h *kalman
+	predicted    time.Time
+}
+
+// Lifetime is how long the track has been seen for.
+func (t *Track) Lifetime() time.Duration {
+	return t.LastSeen.Sub(t.FirstSeen)
+}
+
+// Centre of the smoothed box.
+func (t *Track) Centre() image.Point {
+	return image.Pt((t.Box.Min.X+t.Box.Max.X)/2, (t.Box.Min.Y+t.Box.Max.Y)/2)
+}
+
+func (t *Track) copy() Track {
+	c := *t
+	c.Path = make([]image.Point, len(t.Path))
+	copy(c.Path, t.Path)
+	return c
+}
+
+func (t *Track) predict(now time.Time) {
+	dt := now.Sub(t.predicted).Seconds()
+	t.predicted = now
+	for _, k := range []*kalman{t.cx, t.cy, t.w, t.h} {
+		k.predict(dt)
+	}
+	t.setBox()
+}
+
+func (t *Track) update(box *frame.Box, now time.Time, maxPath int) {
+	c := box.Coords
+	t.cx.update(float64(c.Min.X+c.Max.X) / 2)
+	t.cy.update(float64(c.Min.Y+c.Max.Y) / 2)
+	t.w.update(float64(c.Dx()))
+	t.h.update(float64(c.Dy()))
+	t.setBox()
+	t.PrevBox = t.hitBox
+	t.hitBox = t.Box
+	t.LastSeen = now
+	t.Hits++
+	t.Path = append(t.Path, t.Centre())
+	if len(t.Path) > maxPath {
+		t.Path = t.Path[len(t.Path)-maxPath:]
+	}
+}
+
+func (t *Track) setBox() {
+	x1 := int(math.Round(t.cx.x - t.w.x/2))
+	y1 := int(math.Round(t.cy.x - t.h.x/2))
+	x2 := int(math.Round(t.cx.x + t.w.x/2))
+	y2 := int(math.Round(t.cy.x + t.h.x/2))
+	t.Box = image.Rect(x1, y1, x2, y2)
+}
+
+// Tracker follows the boxes of one camera across frames, giving them stable track IDs.
+type Tracker struct {
+	config Config
+	nextID int
+	tracks []*Track
+	lock   sync.Mutex
+}
+
+func New(config Config) *Tracker {
+	if config.MaxAge == 0 {
+		config.MaxAge = 5 * time.Second
+	}
+	if config.MinIoU == 0 {
+		config.MinIoU = 0.3
+	}
+	if config.MaxDistance == 0 {
+		config.MaxDistance = 0.5
+	}
+	if config.MaxPath == 0 {
+		config.MaxPath = 100
+	}
+	return &Tracker{
+		config: config,
+		nextID: 1,
+	}
+}
+
+// Update matches the boxes of a frame to the existing tracks, setting their TrackID.
+// Boxes that match no track start a new one. Tracks not seen for MaxAge are removed,
+// and returned.
+func (t *Tracker) Update(boxes []*frame.Box, now time.Time) []Track {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, track := range t.tracks {
+		track.predict(now)
+	}
+
+	// Greedy matching, best scoring pairs first.
+	type pair struct {
+		track *Track
+		box   *frame.Box
+		score float64
+	}
+	pairs := []pair{}
+	for _, track := range t.tracks {
+		for _, box := range boxes {
+			if track.Label != box.Label {
+				continue
+			}
+			if score := t.matchScore(track, box); score > 0 {
+				pairs = append(pairs, pair{track, box, score})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].score > pairs[j].score })
+	matchedTracks := map[*Track]bool{}
+	matchedBoxes := map[*frame.Box]bool{}
+	for _, p := range pairs {
+		if matchedTracks[p.track] || matchedBoxes[p.box] {
+			continue
+		}
+		matchedTracks[p.track] = true
+		matchedBoxes[p.box] = true
+		p.track.update(p.box, now, t.config.MaxPath)
+		p.box.TrackID = p.track.ID
+	}
+
+	for _, box := range boxes {
+		if matchedBoxes[box] {
+			continue
+		}
+		track := t.newTrack(box, now)
+		box.TrackID = track.ID
+		log.Debugf("new track %d: %s", track.ID, track.Label)
+	}
+
+	expired := []Track{}
+	live := []*Track{}
+	for _, track := range t.tracks {
+		if now.Sub(track.LastSeen) > t.config.MaxAge {
+			log.Debugf("track %d expired: %s, lifetime: %v", track.ID, track.Label, track.Lifetime())
+			expired = append(expired, track.copy())
+			continue
+		}
+		live = append(live, track)
+	}
+	t.tracks = live
+	return expired
+}
+
+// matchScore is above 1 for boxes overlapping the track, between 0 and 1 for boxes
+// close to the track, and 0 for no match.
+func (t *Tracker) matchScore(track *Track, box *frame.Box) float64 {
+	tb := &frame.Box{Coords: track.Box}
+	if iou := tb.IoU(box); iou >= t.config.MinIoU {
+		return 1 + iou
+	}
+	diag := math.Hypot(float64(track.Box.Dx()), float64(track.Box.Dy()))
+	maxDist := diag * t.config.MaxDistance
+	c := box.Coords
+	dist := math.Hypot(float64((c.Min.X+c.Max.X)/2-track.Centre().X), float64((c.Min.Y+c.Max.Y)/2-track.Centre().Y))
+	if maxDist <= 0 || dist >= maxDist {
+		return 0
+	}
+	return 1 - dist/maxDist
+}
+
+func (t *Tracker) newTrack(box *frame.Box, now time.Time) *Track {
+	c := box.Coords
+	track := &Track{
+		ID:        t.nextID,
+		Label:     box.Label,
+		FirstSeen: now,
+		LastSeen:  now,
+		Hits:      1,
+		cx:        newKalman(float64(c.Min.X+c.Max.X) / 2),
+		cy:        newKalman(float64(c.Min.Y+c.Max.Y) / 2),
+		w:         newKalman(float64(c.Dx())),
+		h:         newKalman(float64(c.Dy())),
+		predicted: now,
+	}
+	track.setBox()
+	track.PrevBox = track.Box
+	track.hitBox = track.Box
+	track.Path = []image.Point{track.Centre()}
+	t.nextID++
+	t.tracks = append(t.tracks, track)
+	return track
+}
+
+// Hold keeps the live tracks alive without matching, for frames that were not
+// inspected because nothing changed, so the same objects are assumed still in view.
+func (t *Tracker) Hold(now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, track := range t.tracks {
+		track.LastSeen = now
+		track.predicted = now
+	}
+}
+
+// Tracks returns a copy of the live tracks.
+func (t *Tracker) Tracks() []Track {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ret := []Track{}
+	for _, track := range t.tracks {
+		ret = append(ret, track.copy())
+	}
+	return ret
+}
+
+// Track returns a copy of the live track with the ID.
+func (t *Tracker) Track(id int) (Track, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, track := range t.tracks {
+		if track.ID == id {
+			return track.copy(), true
+		}
+	}
+	return Track{}, false
+}
+
+// Alerted reports whether an alert was already sent for the track.
+func (t *Tracker) Alerted(id int) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, track := range t.tracks {
+		if track.ID == id {
+			return track.Alerted
+		}
+	}
+	return false
+}
+
+// SetAlerted records that an alert was sent for the track, so it only alerts once.
+func (t *Tracker) SetAlerted(id int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, track := range t.tracks {
+		if track.ID == id {
+			track.Alerted = true
+		}
+	}
+}
+
+// ClearAlerted lets the track alert again, e.g. when it starts moving after standing still.
+func (t *Tracker) ClearAlerted(id int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, track := range t.tracks {
+		if track.ID == id {
+			track.Alerted = false
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marktheunissen/watchbot/pkg/frame"
+	"github.com/marktheunissen/watchbot/pkg/utils"
+)
+
+func TestTrackIDs(t *testing.T) {
+	tr := New(Config{MaxAge: 2 * time.Second})
+	now := time.Now()
+
+	person := &frame.Box{Label: "person", Coords: *utils.GetRect(100, 100, 50, 100)}
+	car := &frame.Box{Label: "car", Coords: *utils.GetRect(100, 100, 50, 100)}
+	tr.Update([]*frame.Box{person, car}, now)
+	if person.TrackID == 0 || car.TrackID == 0 || person.TrackID == car.TrackID {
+		t.Fatalf("want distinct track IDs, got person: %d, car: %d", person.TrackID, car.TrackID)
+	}
+
+	// The person walks to the right, and should keep its track.
+	for i := 1; i <= 10; i++ {
+		now = now.Add(200 * time.Millisecond)
+		moved := &frame.Box{Label: "person", Coords: *utils.GetRect(100+i*10, 100, 50, 100)}
+		tr.Update([]*frame.Box{moved}, now)
+		if moved.TrackID != person.TrackID {
+			t.Fatalf("frame %d: want track %d, got: %d", i, person.TrackID, moved.TrackID)
+		}
+	}
+	track, ok := tr.Track(person.TrackID)
+	if !ok {
+		t.Fatal("person track not found")
+	}
+	if track.Hits != 11 || len(track.Path) != 11 || track.Lifetime() != 2*time.Second {
+		t.Errorf("unexpected track: hits: %d, path: %d, lifetime: %v", track.Hits, len(track.Path), track.Lifetime())
+	}
+	if track.Path[10].X <= track.Path[0].X {
+		t.Errorf("want path moving right, got: %v", track.Path)
+	}
+
+	// Far away person starts a new track.
+	other := &frame.Box{Label: "person", Coords: *utils.GetRect(600, 100, 50, 100)}
+	expired := tr.Update([]*frame.Box{other}, now.Add(200*time.Millisecond))
+	if other.TrackID == person.TrackID {
+		t.Fatal("want new track for far away person")
+	}
+
+	// Car was not seen for longer than MaxAge.
+	if len(expired) != 1 || expired[0].ID != car.TrackID {
+		t.Fatalf("want car track expired, got: %+v", expired)
+	}
+	if _, ok := tr.Track(car.TrackID); ok {
+		t.Error("want car track removed")
+	}
+}
+
+func TestAlerted(t *testing.T) {
+	tr := New(Config{})
+	box := &frame.Box{Label: "person", Coords: *utils.GetRect(100, 100, 50, 100)}
+	tr.Update([]*frame.Box{box}, time.Now())
+	if tr.Alerted(box.TrackID) {
+		t.Fatal("want new track not alerted")
+	}
+	tr.SetAlerted(box.TrackID)
+	if !tr.Alerted(box.TrackID) {
+		t.Fatal("want track alerted")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"image"
 	"strings"
 	"time"
+	"unicode"
 )
 
 // Round out a duration for printing
@@ -43,6 +44,17 @@ func MarkdownCode(s string) string {
 	return fmt.Sprintf("```\n%s```\n", s)
 }
 
+// CutWord splits off the first word of s, on any white space, and returns it and the
+// rest, which may span lines.
+func CutWord(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i == -1 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
 func StringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
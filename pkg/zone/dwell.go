@@ -0,0 +1,170 @@
+package zone
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DwellRule alerts when a tracked object stays in a zone for at least MinDwell, and
+// again every Repeat while it stays, if Repeat is set. Without labels, the rule
+// applies to all labels.
+type DwellRule struct {
+	Zone     string
+	Labels   []string
+	MinDwell time.Duration
+	Repeat   time.Duration
+}
+
+func (r *DwellRule) matches(label string, zone string) bool {
+	if r.Zone != zone {
+		return false
+	}
+	if len(r.Labels) == 0 {
+		return true
+	}
+	for _, l := range r.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// DwellAlert is a track that has been in a zone for longer than the rule allows.
+type DwellAlert struct {
+	TrackID   int
+	Label     string
+	Zone      string
+	Duration  time.Duration
+	JPEGBytes []byte
+}
+
+type visitKey struct {
+	track int
+	zone  string
+}
+
+type visit struct {
+	label     string
+	entered   time.Time
+	alerted   time.Time
+	jpegBytes []byte
+}
+
+// Dwell times how long tracks stay in zones.
+type Dwell struct {
+	rules  []*DwellRule
+	visits map[visitKey]*visit
+	lock   sync.Mutex
+}
+
+func NewDwell(rules []*DwellRule) *Dwell {
+	return &Dwell{
+		rules:  rules,
+		visits: map[visitKey]*visit{},
+	}
+}
+
+func (d *Dwell) Rules() []*DwellRule {
+	return d.rules
+}
+
+// Covers reports whether every zone given has a dwell rule for the label, so that
+// objects there only alert once they have stayed long enough.
+func (d *Dwell) Covers(label string, zones []string) bool {
+	if len(zones) == 0 {
+		return false
+	}
+	for _, z := range zones {
+		if d.rule(label, z) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *Dwell) rule(label string, zone string) *DwellRule {
+	for _, r := range d.rules {
+		if r.matches(label, zone) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Update records the zones a track was seen in, with the frame to send if it alerts.
+// Visits to zones the track is no longer in are ended.
+func (d *Dwell) Update(trackID int, label string, zones []string, jpegBytes []byte, now time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	in := map[string]bool{}
+	for _, z := range zones {
+		if d.rule(label, z) == nil {
+			continue
+		}
+		in[z] = true
+		key := visitKey{trackID, z}
+		v, ok := d.visits[key]
+		if !ok {
+			v = &visit{label: label, entered: now}
+			d.visits[key] = v
+		}
+		v.jpegBytes = jpegBytes
+	}
+	for key := range d.visits {
+		if key.track == trackID && !in[key.zone] {
+			delete(d.visits, key)
+		}
+	}
+}
+
+// End forgets the visits of a track that is no longer in view.
+func (d *Dwell) End(trackID int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for key := range d.visits {
+		if key.track == trackID {
+			delete(d.visits, key)
+		}
+	}
+}
+
+// Due returns the visits that are due to alert. They stay due until SetAlerted.
+func (d *Dwell) Due(now time.Time) []DwellAlert {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	ret := []DwellAlert{}
+	for key, v := range d.visits {
+		r := d.rule(v.label, key.zone)
+		if r == nil {
+			continue
+		}
+		duration := now.Sub(v.entered)
+		if duration < r.MinDwell {
+			continue
+		}
+		if !v.alerted.IsZero() && (r.Repeat == 0 || now.Sub(v.alerted) < r.Repeat) {
+			continue
+		}
+		ret = append(ret, DwellAlert{
+			TrackID:   key.track,
+			Label:     v.label,
+			Zone:      key.zone,
+			Duration:  duration,
+			JPEGBytes: v.jpegBytes,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].TrackID < ret[j].TrackID })
+	return ret
+}
+
+// SetAlerted records that the alert for a visit was sent, so it only alerts again
+// after the rule's Repeat.
+func (d *Dwell) SetAlerted(trackID int, zone string, t time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if v, ok := d.visits[visitKey{trackID, zone}]; ok {
+		v.alerted = t
+	}
+}
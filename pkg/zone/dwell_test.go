@@ -0,0 +1,56 @@
+package zone
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDwell(t *testing.T) {
+	d := NewDwell([]*DwellRule{
+		{Zone: "garage", Labels: []string{"person"}, MinDwell: 30 * time.Second, Repeat: 5 * time.Minute},
+	})
+	if !d.Covers("person", []string{"garage"}) {
+		t.Error("want person in garage covered")
+	}
+	if d.Covers("car", []string{"garage"}) || d.Covers("person", []string{"garage", "driveway"}) {
+		t.Error("want car, and person also in driveway, not covered")
+	}
+
+	start := time.Now()
+	d.Update(1, "person", []string{"garage"}, nil, start)
+	d.Update(2, "car", []string{"garage"}, nil, start)
+	if due := d.Due(start.Add(20 * time.Second)); len(due) != 0 {
+		t.Errorf("want nothing due before min dwell, got: %+v", due)
+	}
+	due := d.Due(start.Add(40 * time.Second))
+	if len(due) != 1 || due[0].TrackID != 1 || due[0].Duration != 40*time.Second {
+		t.Fatalf("want track 1 due after 40s, got: %+v", due)
+	}
+
+	// Until the alert is sent, it stays due.
+	if due := d.Due(start.Add(50 * time.Second)); len(due) != 1 {
+		t.Fatalf("want track 1 still due until alerted, got: %+v", due)
+	}
+	d.SetAlerted(1, "garage", start.Add(50*time.Second))
+	if due := d.Due(start.Add(2 * time.Minute)); len(due) != 0 {
+		t.Errorf("want nothing due before repeat, got: %+v", due)
+	}
+	if due := d.Due(start.Add(6 * time.Minute)); len(due) != 1 {
+		t.Errorf("want track 1 due again after repeat, got: %+v", due)
+	}
+	d.SetAlerted(1, "garage", start.Add(6*time.Minute))
+	if due := d.Due(start.Add(7 * time.Minute)); len(due) != 0 {
+		t.Errorf("want nothing due before repeat, got: %+v", due)
+	}
+
+	// Leaving the zone starts the clock again.
+	d.Update(1, "person", nil, nil, start.Add(7*time.Minute))
+	d.Update(1, "person", []string{"garage"}, nil, start.Add(8*time.Minute))
+	if due := d.Due(start.Add(8*time.Minute + 10*time.Second)); len(due) != 0 {
+		t.Errorf("want nothing due after re-entering, got: %+v", due)
+	}
+	d.End(1)
+	if due := d.Due(start.Add(time.Hour)); len(due) != 0 {
+		t.Errorf("want nothing due after track ended, got: %+v", due)
+	}
+}
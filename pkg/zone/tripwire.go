@@ -0,0 +1,81 @@
+package zone
+
+import (
+	"fmt"
+	"image"
+)
+
+// Directions an object can cross a tripwire in.
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// Tripwire is a named line that raises an event when a tracked object crosses it.
+// Looking from A towards B, crossing from the left side to the right side is in,
+// so swapping the points swaps the directions.
+type Tripwire struct {
+	Name   string
+	A      image.Point
+	B      image.Point
+	Anchor string
+}
+
+func NewTripwire(name string, points string, anchor string) (*Tripwire, error) {
+	pts, err := parsePoints(points)
+	if err != nil {
+		return nil, fmt.Errorf("tripwire %s: %s", name, err)
+	}
+	if len(pts) != 2 {
+		return nil, fmt.Errorf("tripwire %s: a line needs 2 points, got: %d", name, len(pts))
+	}
+	if pts[0] == pts[1] {
+		return nil, fmt.Errorf("tripwire %s: points must differ", name)
+	}
+	if anchor == "" {
+		anchor = AnchorBottomCentre
+	}
+	if anchor != AnchorBottomCentre && anchor != AnchorCentroid {
+		return nil, fmt.Errorf("tripwire %s: unknown anchor: %s", name, anchor)
+	}
+	t := &Tripwire{
+		Name:   name,
+		A:      pts[0],
+		B:      pts[1],
+		Anchor: anchor,
+	}
+	return t, nil
+}
+
+// Cross returns the direction when an object moving from one box to the next crossed
+// the line, or an empty string when it didn't.
+func (t *Tripwire) Cross(from, to image.Rectangle) string {
+	p, q := AnchorPoint(from, t.Anchor), AnchorPoint(to, t.Anchor)
+
+	// Points exactly on the line count as the right side, so that an object stopping
+	// on the line crosses it once rather than twice.
+	fromRight := cross(t.A, t.B, p) >= 0
+	toRight := cross(t.A, t.B, q) >= 0
+	if fromRight == toRight {
+		return ""
+	}
+	// The step crossed the infinite line, it must also cross between A and B.
+	sa, sb := cross(p, q, t.A), cross(p, q, t.B)
+	if (sa > 0 && sb > 0) || (sa < 0 && sb < 0) {
+		return ""
+	}
+	if toRight {
+		return DirectionIn
+	}
+	return DirectionOut
+}
+
+func (t *Tripwire) String() string {
+	return fmt.Sprintf("%s (%s): %v-%v", t.Name, t.Anchor, t.A, t.B)
+}
+
+// cross is positive when c is to the right of the line from a to b, in image
+// coordinates where y points down.
+func cross(a, b, c image.Point) int {
+	return (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X)
+}
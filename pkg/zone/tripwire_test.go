@@ -0,0 +1,43 @@
+package zone
+
+import (
+	"image"
+	"testing"
+)
+
+func TestTripwireCross(t *testing.T) {
+	// A gate along the top of the frame, looking right, so moving down is in.
+	gate, err := NewTripwire("gate", "0,50 100,50", AnchorCentroid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	above := image.Rect(40, 20, 60, 40)
+	below := image.Rect(40, 60, 60, 80)
+	if d := gate.Cross(above, below); d != DirectionIn {
+		t.Errorf("want in, got: %q", d)
+	}
+	if d := gate.Cross(below, above); d != DirectionOut {
+		t.Errorf("want out, got: %q", d)
+	}
+	if d := gate.Cross(above, above.Add(image.Pt(10, 0))); d != "" {
+		t.Errorf("want no crossing, got: %q", d)
+	}
+
+	// Stopping on the line and moving on crosses once.
+	on := image.Rect(40, 40, 60, 60)
+	if d := gate.Cross(above, on); d != DirectionIn {
+		t.Errorf("want in onto the line, got: %q", d)
+	}
+	if d := gate.Cross(on, below); d != "" {
+		t.Errorf("want no crossing off the line, got: %q", d)
+	}
+
+	// Passing the end of the line isn't a crossing.
+	if d := gate.Cross(above.Add(image.Pt(200, 0)), below.Add(image.Pt(200, 0))); d != "" {
+		t.Errorf("want no crossing beyond the line, got: %q", d)
+	}
+
+	if _, err := NewTripwire("gate", "0,50 100,50 100,100", ""); err == nil {
+		t.Error("want error for 3 points")
+	}
+}
@@ -0,0 +1,226 @@
+package zone
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// Anchors decide which part of a box has to be inside a zone for the box to be in it.
+const (
+	// AnchorBottomCentre is where a person's feet or a car's wheels touch the ground,
+	// which works best for zones drawn on the ground in a camera looking down at an angle.
+	AnchorBottomCentre = "bottom-centre"
+	AnchorCentroid     = "centroid"
+
+	// AnchorOverlap requires a percentage of the box area to be inside the zone.
+	AnchorOverlap = "overlap"
+)
+
+// Polygon is a closed shape, the last point joins back up with the first.
+type Polygon []image.Point
+
+// Zone is a named polygon that boxes are tested against. Include zones name the
+// areas to alert on, exclude zones mask areas that should never alert.
+type Zone struct {
+	Name    string
+	Polygon Polygon
+	Exclude bool
+	Anchor  string
+
+	// With AnchorOverlap, the fraction of the box area (0-1) that has to be inside.
+	MinOverlap float64
+}
+
+func New(name string, points string, anchor string, minOverlap float64, exclude bool) (*Zone, error) {
+	polygon, err := ParsePolygon(points)
+	if err != nil {
+		return nil, fmt.Errorf("zone %s: %s", name, err)
+	}
+	if anchor == "" {
+		anchor = AnchorBottomCentre
+	}
+	switch anchor {
+	case AnchorBottomCentre, AnchorCentroid:
+	case AnchorOverlap:
+		if minOverlap == 0 {
+			minOverlap = 0.5
+		}
+		if minOverlap < 0 || minOverlap > 1 {
+			return nil, fmt.Errorf("zone %s: min overlap must be between 0 and 1, got: %v", name, minOverlap)
+		}
+	default:
+		return nil, fmt.Errorf("zone %s: unknown anchor: %s", name, anchor)
+	}
+	z := &Zone{
+		Name:       name,
+		Polygon:    polygon,
+		Exclude:    exclude,
+		Anchor:     anchor,
+		MinOverlap: minOverlap,
+	}
+	return z, nil
+}
+
+// ParsePolygon reads points given as "x,y x,y x,y ...".
+func ParsePolygon(s string) (Polygon, error) {
+	polygon, err := parsePoints(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(polygon) < 3 {
+		return nil, errors.New("a polygon needs at least 3 points")
+	}
+	return polygon, nil
+}
+
+func parsePoints(s string) ([]image.Point, error) {
+	points := []image.Point{}
+	for _, field := range strings.Fields(s) {
+		xy := strings.Split(field, ",")
+		if len(xy) != 2 {
+			return nil, fmt.Errorf("point %q is not x,y", field)
+		}
+		x, err := strconv.Atoi(xy[0])
+		if err != nil {
+			return nil, fmt.Errorf("point %q: %s", field, err)
+		}
+		y, err := strconv.Atoi(xy[1])
+		if err != nil {
+			return nil, fmt.Errorf("point %q: %s", field, err)
+		}
+		points = append(points, image.Pt(x, y))
+	}
+	return points, nil
+}
+
+// AnchorPoint is the point of the box that is tested against zones and lines.
+func AnchorPoint(r image.Rectangle, anchor string) image.Point {
+	if anchor == AnchorCentroid {
+		return image.Pt((r.Min.X+r.Max.X)/2, (r.Min.Y+r.Max.Y)/2)
+	}
+	return image.Pt((r.Min.X+r.Max.X)/2, r.Max.Y)
+}
+
+// ContainsBox tests the box against the zone using the zone's anchor.
+func (z *Zone) ContainsBox(r image.Rectangle) bool {
+	if z.Anchor == AnchorOverlap {
+		area := r.Dx() * r.Dy()
+		if area == 0 {
+			return false
+		}
+		return z.Polygon.ClipArea(r)/float64(area) >= z.MinOverlap
+	}
+	return z.Polygon.Contains(AnchorPoint(r, z.Anchor))
+}
+
+func (z *Zone) String() string {
+	kind := "include"
+	if z.Exclude {
+		kind = "exclude"
+	}
+	return fmt.Sprintf("%s (%s, %s): %v", z.Name, kind, z.Anchor, z.Polygon)
+}
+
+// Contains uses the even-odd rule, so points exactly on an edge may fall either way.
+func (p Polygon) Contains(pt image.Point) bool {
+	in := false
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		a, b := p[i], p[j]
+		if (a.Y > pt.Y) != (b.Y > pt.Y) {
+			x := float64(b.X-a.X)*float64(pt.Y-a.Y)/float64(b.Y-a.Y) + float64(a.X)
+			if float64(pt.X) < x {
+				in = !in
+			}
+		}
+	}
+	return in
+}
+
+func (p Polygon) Bounds() image.Rectangle {
+	if len(p) == 0 {
+		return image.Rectangle{}
+	}
+	r := image.Rectangle{Min: p[0], Max: p[0]}
+	for _, pt := range p[1:] {
+		r = r.Union(image.Rectangle{Min: pt, Max: pt})
+	}
+	return r
+}
+
+// Area is the area enclosed by the polygon, given the edges don't cross.
+func (p Polygon) Area() float64 {
+	return area(toFloat(p))
+}
+
+// ClipArea is the area of the polygon that falls inside the rectangle.
+func (p Polygon) ClipArea(r image.Rectangle) float64 {
+	// Sutherland-Hodgman, clipping against each edge of the rectangle in turn.
+	poly := toFloat(p)
+	edges := []func(pt fpoint) bool{
+		func(pt fpoint) bool { return pt.x >= float64(r.Min.X) },
+		func(pt fpoint) bool { return pt.x <= float64(r.Max.X) },
+		func(pt fpoint) bool { return pt.y >= float64(r.Min.Y) },
+		func(pt fpoint) bool { return pt.y <= float64(r.Max.Y) },
+	}
+	intersects := []func(a, b fpoint) fpoint{
+		func(a, b fpoint) fpoint { return intersectX(a, b, float64(r.Min.X)) },
+		func(a, b fpoint) fpoint { return intersectX(a, b, float64(r.Max.X)) },
+		func(a, b fpoint) fpoint { return intersectY(a, b, float64(r.Min.Y)) },
+		func(a, b fpoint) fpoint { return intersectY(a, b, float64(r.Max.Y)) },
+	}
+	for e, inside := range edges {
+		if len(poly) == 0 {
+			return 0
+		}
+		out := []fpoint{}
+		prev := poly[len(poly)-1]
+		for _, cur := range poly {
+			if inside(cur) {
+				if !inside(prev) {
+					out = append(out, intersects[e](prev, cur))
+				}
+				out = append(out, cur)
+			} else if inside(prev) {
+				out = append(out, intersects[e](prev, cur))
+			}
+			prev = cur
+		}
+		poly = out
+	}
+	return area(poly)
+}
+
+type fpoint struct {
+	x, y float64
+}
+
+func toFloat(p Polygon) []fpoint {
+	ret := make([]fpoint, len(p))
+	for i, pt := range p {
+		ret[i] = fpoint{float64(pt.X), float64(pt.Y)}
+	}
+	return ret
+}
+
+func intersectX(a, b fpoint, x float64) fpoint {
+	return fpoint{x, a.y + (b.y-a.y)*(x-a.x)/(b.x-a.x)}
+}
+
+func intersectY(a, b fpoint, y float64) fpoint {
+	return fpoint{a.x + (b.x-a.x)*(y-a.y)/(b.y-a.y), y}
+}
+
+// area uses the shoelace formula.
+func area(p []fpoint) float64 {
+	sum := 0.0
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		sum += p[j].x*p[i].y - p[i].x*p[j].y
+	}
+	if sum < 0 {
+		sum = -sum
+	}
+	return sum / 2
+}
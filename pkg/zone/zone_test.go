@@ -0,0 +1,74 @@
+package zone
+
+import (
+	"image"
+	"testing"
+)
+
+func TestParsePolygon(t *testing.T) {
+	p, err := ParsePolygon("0,0 100,0 100,100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 3 || p[1] != image.Pt(100, 0) {
+		t.Errorf("want 3 points, got: %v", p)
+	}
+	if _, err := ParsePolygon("0,0 100,0"); err == nil {
+		t.Error("want error for 2 points")
+	}
+	if _, err := ParsePolygon("0,0 100 100,100"); err == nil {
+		t.Error("want error for bad point")
+	}
+}
+
+func TestContainsBox(t *testing.T) {
+	// A diagonal strip, like a driveway running from bottom left to top right.
+	points := "0,100 0,60 60,0 100,0"
+	box := image.Rect(10, 0, 30, 50)
+
+	z, err := New("driveway", points, "", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z.Anchor != AnchorBottomCentre {
+		t.Errorf("want default anchor, got: %s", z.Anchor)
+	}
+	if !z.ContainsBox(box) {
+		t.Errorf("want bottom centre of %v inside %v", box, z.Polygon)
+	}
+
+	z, _ = New("driveway", points, AnchorCentroid, 0, false)
+	if z.ContainsBox(box) {
+		t.Errorf("want centroid of %v outside %v", box, z.Polygon)
+	}
+
+	z, _ = New("driveway", points, AnchorOverlap, 0.15, false)
+	if !z.ContainsBox(box) {
+		t.Errorf("want overlap of %v above 0.15", box)
+	}
+	z, _ = New("driveway", points, AnchorOverlap, 0.6, false)
+	if z.ContainsBox(box) {
+		t.Errorf("want overlap of %v below 0.6", box)
+	}
+
+	if _, err := New("driveway", points, "feet", 0, false); err == nil {
+		t.Error("want error for unknown anchor")
+	}
+}
+
+func TestClipArea(t *testing.T) {
+	p, _ := ParsePolygon("0,0 100,0 100,100 0,100")
+	if a := p.Area(); a != 10000 {
+		t.Errorf("want area 10000, got: %v", a)
+	}
+	if a := p.ClipArea(image.Rect(50, 50, 150, 150)); a != 2500 {
+		t.Errorf("want clipped area 2500, got: %v", a)
+	}
+	if a := p.ClipArea(image.Rect(200, 200, 300, 300)); a != 0 {
+		t.Errorf("want no clipped area, got: %v", a)
+	}
+	tri, _ := ParsePolygon("0,0 100,0 0,100")
+	if a := tri.ClipArea(image.Rect(0, 0, 50, 50)); a != 2500 {
+		t.Errorf("want triangle clipped area 2500, got: %v", a)
+	}
+}